@@ -1,6 +1,7 @@
 package gps
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -33,6 +34,25 @@ type sourceBridge interface {
 	verifyRootDir(path string) error
 	vendorCodeExists(ProjectIdentifier) (bool, error)
 	breakLock()
+	prefetch(ProjectIdentifier, Version)
+	waitPrefetch()
+}
+
+// ctxSourceManager is implemented by SourceManagers that can accept the
+// cancellation context of a solve run on each call, and stop waiting on the
+// call when it is canceled. The bridge prefers these methods when they're
+// available, and falls back on the plain SourceManager methods otherwise.
+//
+// Canceling the context must not affect the SourceManager beyond the call;
+// it may well be shared with other solve runs.
+type ctxSourceManager interface {
+	sourceExists(context.Context, ProjectIdentifier) (bool, error)
+	syncSourceFor(context.Context, ProjectIdentifier) error
+	listVersions(context.Context, ProjectIdentifier) ([]PairedVersion, error)
+	revisionPresentIn(context.Context, ProjectIdentifier, Revision) (bool, error)
+	listPackages(context.Context, ProjectIdentifier, Version) (pkgtree.PackageTree, error)
+	getManifestAndLock(context.Context, ProjectIdentifier, Version, ProjectAnalyzer) (Manifest, Lock, error)
+	deduceProjectRoot(context.Context, string) (ProjectRoot, error)
 }
 
 // bridge is an adapter around a proper SourceManager. It provides localized
@@ -69,6 +89,23 @@ type bridge struct {
 
 	// Whether to sort version lists for downgrade.
 	down bool
+
+	// Background fetcher for information the solver will probably need soon.
+	// Created on first use.
+	pf *prefetcher
+}
+
+// downgrade indicates whether the version list for the given project should be
+// sorted for downgrade, taking any project-specific version order into
+// account.
+func (b *bridge) downgrade(pr ProjectRoot) bool {
+	switch b.s.rd.ord[pr] {
+	case UpgradeOrder:
+		return false
+	case DowngradeOrder:
+		return true
+	}
+	return b.down
 }
 
 // Global factory func to create a bridge. This exists solely to allow tests to
@@ -84,11 +121,22 @@ var mkBridge = func(s *solver, sm SourceManager, down bool) sourceBridge {
 
 func (b *bridge) GetManifestAndLock(id ProjectIdentifier, v Version, an ProjectAnalyzer) (Manifest, Lock, error) {
 	if b.s.rd.isRoot(id.ProjectRoot) {
+		if id.ProjectRoot != ProjectRoot(b.s.rd.rpt.ImportRoot) {
+			// The other roots of a workspace share the main root's lock.
+			return b.s.rd.root(id.ProjectRoot).rm, nil, nil
+		}
 		return b.s.rd.rm, b.s.rd.rl, nil
 	}
 
 	b.s.mtr.push("b-gmal")
-	m, l, e := b.sm.GetManifestAndLock(id, v, an)
+	var m Manifest
+	var l Lock
+	var e error
+	if csm, ok := b.sm.(ctxSourceManager); ok {
+		m, l, e = csm.getManifestAndLock(b.ctx(), id, v, an)
+	} else {
+		m, l, e = b.sm.GetManifestAndLock(id, v, an)
+	}
 	b.s.mtr.pop()
 	return m, l, e
 }
@@ -99,14 +147,20 @@ func (b *bridge) listVersions(id ProjectIdentifier) ([]Version, error) {
 	}
 
 	b.s.mtr.push("b-list-versions")
-	pvl, err := b.sm.ListVersions(id)
+	var pvl []PairedVersion
+	var err error
+	if csm, ok := b.sm.(ctxSourceManager); ok {
+		pvl, err = csm.listVersions(b.ctx(), id)
+	} else {
+		pvl, err = b.sm.ListVersions(id)
+	}
 	if err != nil {
 		b.s.mtr.pop()
 		return nil, err
 	}
 
 	vl := hidePair(pvl)
-	if b.down {
+	if b.downgrade(id.ProjectRoot) {
 		SortForDowngrade(vl)
 	} else {
 		SortForUpgrade(vl)
@@ -119,14 +173,26 @@ func (b *bridge) listVersions(id ProjectIdentifier) ([]Version, error) {
 
 func (b *bridge) RevisionPresentIn(id ProjectIdentifier, r Revision) (bool, error) {
 	b.s.mtr.push("b-rev-present-in")
-	i, e := b.sm.RevisionPresentIn(id, r)
+	var i bool
+	var e error
+	if csm, ok := b.sm.(ctxSourceManager); ok {
+		i, e = csm.revisionPresentIn(b.ctx(), id, r)
+	} else {
+		i, e = b.sm.RevisionPresentIn(id, r)
+	}
 	b.s.mtr.pop()
 	return i, e
 }
 
 func (b *bridge) SourceExists(id ProjectIdentifier) (bool, error) {
 	b.s.mtr.push("b-source-exists")
-	i, e := b.sm.SourceExists(id)
+	var i bool
+	var e error
+	if csm, ok := b.sm.(ctxSourceManager); ok {
+		i, e = csm.sourceExists(b.ctx(), id)
+	} else {
+		i, e = b.sm.SourceExists(id)
+	}
 	b.s.mtr.pop()
 	return i, e
 }
@@ -149,15 +215,25 @@ func (b *bridge) vendorCodeExists(id ProjectIdentifier) (bool, error) {
 // responsible for that code.
 func (b *bridge) ListPackages(id ProjectIdentifier, v Version) (pkgtree.PackageTree, error) {
 	if b.s.rd.isRoot(id.ProjectRoot) {
-		return b.s.rd.rpt, nil
+		return b.s.rd.root(id.ProjectRoot).rpt, nil
 	}
 
 	b.s.mtr.push("b-list-pkgs")
-	pt, err := b.sm.ListPackages(id, v)
+	pt, err := b.listPackages(id, v)
 	b.s.mtr.pop()
 	return pt, err
 }
 
+// listPackages passes a ListPackages call through to the underlying
+// SourceManager, without tracking metrics. It is safe to call from goroutines
+// other than the solver's.
+func (b *bridge) listPackages(id ProjectIdentifier, v Version) (pkgtree.PackageTree, error) {
+	if csm, ok := b.sm.(ctxSourceManager); ok {
+		return csm.listPackages(b.ctx(), id, v)
+	}
+	return b.sm.ListPackages(id, v)
+}
+
 func (b *bridge) ExportProject(id ProjectIdentifier, v Version, path string) error {
 	panic("bridge should never be used to ExportProject")
 }
@@ -177,7 +253,13 @@ func (b *bridge) verifyRootDir(path string) error {
 
 func (b *bridge) DeduceProjectRoot(ip string) (ProjectRoot, error) {
 	b.s.mtr.push("b-deduce-proj-root")
-	pr, e := b.sm.DeduceProjectRoot(ip)
+	var pr ProjectRoot
+	var e error
+	if csm, ok := b.sm.(ctxSourceManager); ok {
+		pr, e = csm.deduceProjectRoot(b.ctx(), ip)
+	} else {
+		pr, e = b.sm.DeduceProjectRoot(ip)
+	}
 	b.s.mtr.pop()
 	return pr, e
 }
@@ -203,10 +285,10 @@ func (b *bridge) breakLock() {
 			pi, v := lp.pi, lp.Version()
 			go func() {
 				// Sync first
-				b.sm.SyncSourceFor(pi)
+				b.SyncSourceFor(pi)
 				// Preload the package info for the locked version, too, as
 				// we're more likely to need that
-				b.sm.ListPackages(pi, v)
+				b.listPackages(pi, v)
 			}()
 		}
 	}
@@ -215,5 +297,16 @@ func (b *bridge) breakLock() {
 func (b *bridge) SyncSourceFor(id ProjectIdentifier) error {
 	// we don't track metrics here b/c this is often called in its own goroutine
 	// by the solver, and the metrics design is for wall time on a single thread
+	if csm, ok := b.sm.(ctxSourceManager); ok {
+		return csm.syncSourceFor(b.ctx(), id)
+	}
 	return b.sm.SyncSourceFor(id)
 }
+
+// ctx returns the cancellation context for the current solve run.
+func (b *bridge) ctx() context.Context {
+	if b.s.ctx == nil {
+		return context.TODO()
+	}
+	return b.s.ctx
+}
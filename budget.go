@@ -0,0 +1,280 @@
+package gps
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// maxReportedFailures is the number of distinct failure reasons reported in a
+// BudgetExceededError.
+const maxReportedFailures = 10
+
+// budget tracks the limits placed on a solving run, along with the progress
+// information reported if those limits are exceeded.
+type budget struct {
+	// The limits from SolveParameters. Zero means no limit.
+	maxAttempts int
+	maxDuration time.Duration
+
+	// When the current solving run started.
+	start time.Time
+
+	// Cancels the solver's context when a limit is exceeded.
+	cancel context.CancelFunc
+
+	// The name of the limit that was exceeded, if any.
+	exceeded string
+
+	// The selections making up the largest partial solution reached so far,
+	// excluding the root projects, and the number of projects it contained.
+	deepest []atomWithPackages
+	depth   int
+
+	// The number of times each kind of failure occurred for each project.
+	fails map[FailureCount]int
+}
+
+func (b *budget) active() bool {
+	return b.maxAttempts > 0 || b.maxDuration > 0
+}
+
+// A FailureCount records how many times the solver rejected versions of a
+// project for a particular kind of reason.
+type FailureCount struct {
+	// Project is the root of the project whose versions were rejected.
+	Project ProjectRoot
+	// Reason is a brief description of the kind of failure.
+	Reason string
+	// Count is the number of times this kind of failure occurred.
+	Count int
+}
+
+// BudgetExceededError indicates that a solving run was abandoned because it
+// exceeded the MaxAttempts or MaxDuration given in SolveParameters. It reports
+// how far the solver had gotten, to help pin down what made the problem so
+// difficult.
+type BudgetExceededError struct {
+	// Budget is the name of the limit that was exceeded: "attempts" or
+	// "duration".
+	Budget string
+	// Attempts is the number of attempts the solver had made.
+	Attempts int
+	// Elapsed is the time the solving run took.
+	Elapsed time.Duration
+	// Deepest is the largest partial solution that the solver reached.
+	Deepest []LockedProject
+	// Unselected lists the projects still waiting to be selected when the
+	// solver stopped.
+	Unselected []ProjectRoot
+	// Failures lists the most frequent reasons for which the solver rejected
+	// versions, from most to least frequent.
+	Failures []FailureCount
+	// Metrics reports on the work the solver did before it stopped.
+	Metrics SolveMetrics
+}
+
+func (e *BudgetExceededError) Error() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "solving exceeded its %s budget after %v attempts in %s; the deepest partial solution reached contained %v projects, with %v yet to be selected", e.Budget, e.Attempts, e.Elapsed, len(e.Deepest), len(e.Unselected))
+	if len(e.Failures) > 0 {
+		fmt.Fprintf(&buf, "\nMost frequent failures:")
+		for _, fc := range e.Failures {
+			fmt.Fprintf(&buf, "\n\t%s: %s (%v times)", fc.Project, fc.Reason, fc.Count)
+		}
+	}
+
+	return buf.String()
+}
+
+// failureReason returns a brief description of the kind of failure represented
+// by an error from the solver.
+func failureReason(err error) string {
+	switch err.(type) {
+	case *VersionNotAllowedFailure:
+		return "version not allowed by constraints"
+	case *ConstraintNotAllowedFailure:
+		return "constraint on a dependency not satisfied by its selected version"
+	case *DisjointConstraintFailure:
+		return "constraint on a dependency disjoint with existing constraints"
+	case *NonexistentRevisionFailure:
+		return "revision does not exist"
+	case *SourceMismatchFailure:
+		return "source for a dependency conflicts with existing sources"
+	case *CheckeeHasProblemPackagesFailure:
+		return "required packages missing or broken"
+	case *DepHasProblemPackagesFailure:
+		return "packages required from a dependency missing or broken"
+	case *MissingSourceFailure:
+		return "source could not be found"
+	case *LearnedConflictFailure:
+		return "completes a previously learned conflict"
+	case *LockChurnFailure:
+		return "changes too many locked projects"
+	case *VersionExcludedFailure:
+		return "version excluded by policy"
+	case *PolicyVetoFailure:
+		return "vetoed by policy"
+	}
+	return "other"
+}
+
+// startRun sets up the solver's context and metrics for a solving run,
+// applying any time budget to the context.
+func (s *solver) startRun(ctx context.Context) {
+	s.mtr = newMetrics()
+	s.vUnify.mtr = s.mtr
+	s.sel.vu.mtr = s.mtr
+
+	s.bgt.start = time.Now()
+	if s.bgt.maxDuration > 0 {
+		ctx, s.bgt.cancel = context.WithTimeout(ctx, s.bgt.maxDuration)
+	} else {
+		ctx, s.bgt.cancel = context.WithCancel(ctx)
+	}
+	s.ctx = ctx
+}
+
+// endRun releases the resources held for a solving run. Canceling the run's
+// context stops any prefetches still in flight, which are then waited for, so
+// that no prefetch starts a call to the SourceManager after it returns.
+//
+// Waiting for a prefetch means waiting for the SourceManager call it is in the
+// middle of to return. A SourceManager that takes contexts gives up on its
+// part of the work once the run is canceled, though work it shares with other
+// callers, such as a deduction already underway, carries on for them. One that
+// does not is waited on until the call finishes.
+func (s *solver) endRun() {
+	s.bgt.cancel()
+	s.b.waitPrefetch()
+}
+
+// attachMetrics adds the metrics for the solving run to the error that ended
+// it, if it has a place for them.
+func (s *solver) attachMetrics(err error) {
+	switch terr := err.(type) {
+	case *SolveCanceledError:
+		terr.Metrics = s.mtr.export(s.attempts)
+	case *BudgetExceededError:
+		terr.Metrics = s.mtr.export(s.attempts)
+	}
+}
+
+// interrupted returns the error to report if the solving run was cut short,
+// either by the caller canceling the provided context, or by exceeding a
+// budget. Otherwise, nil is returned.
+func (s *solver) interrupted(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return &SolveCanceledError{
+			Attempts: s.attempts,
+			Err:      ctx.Err(),
+		}
+	}
+	if s.ctx.Err() == nil {
+		return nil
+	}
+
+	if s.bgt.exceeded == "" {
+		// Our own context was canceled, but the caller's wasn't, so it must
+		// have been the deadline.
+		s.bgt.exceeded = "duration"
+	}
+	return s.budgetErr()
+}
+
+// countAttempt is called each time the solver completes a backtrack, and stops
+// the solving run if that exceeds the attempt budget.
+func (s *solver) countAttempt() {
+	s.attempts++
+	if s.bgt.maxAttempts > 0 && s.attempts >= s.bgt.maxAttempts && s.bgt.exceeded == "" {
+		s.bgt.exceeded = "attempts"
+		s.bgt.cancel()
+	}
+}
+
+// noteSelection records the current selection if it is the deepest yet
+// reached.
+func (s *solver) noteSelection() {
+	// Every selected project other than the roots has a version queue.
+	if !s.bgt.active() || len(s.vqs) <= s.bgt.depth {
+		return
+	}
+
+	s.bgt.depth = len(s.vqs)
+	s.bgt.deepest = s.bgt.deepest[:0]
+	for _, p := range s.sel.projects[s.rd.nroots():] {
+		s.bgt.deepest = append(s.bgt.deepest, p.a)
+	}
+}
+
+// noteFailure records a failure encountered while looking for a version of
+// the given project.
+func (s *solver) noteFailure(id ProjectIdentifier, err error) {
+	if !s.bgt.active() {
+		return
+	}
+
+	if s.bgt.fails == nil {
+		s.bgt.fails = make(map[FailureCount]int)
+	}
+	s.bgt.fails[FailureCount{Project: id.ProjectRoot, Reason: failureReason(err)}]++
+}
+
+func (s *solver) budgetErr() *BudgetExceededError {
+	e := &BudgetExceededError{
+		Budget:   s.bgt.exceeded,
+		Attempts: s.attempts,
+		Elapsed:  time.Since(s.bgt.start),
+	}
+
+	all := make(map[atom]map[string]struct{})
+	for _, awp := range s.bgt.deepest {
+		pm, has := all[awp.a]
+		if !has {
+			pm = make(map[string]struct{})
+			all[awp.a] = pm
+		}
+		for _, pkg := range awp.pl {
+			pm[pkg] = struct{}{}
+		}
+	}
+	for pa, pl := range all {
+		e.Deepest = append(e.Deepest, pa2lp(pa, pl))
+	}
+	sort.Sort(lpsorter(e.Deepest))
+
+	seen := make(map[ProjectRoot]bool)
+	for _, bmi := range s.unsel.sl {
+		if !seen[bmi.id.ProjectRoot] {
+			seen[bmi.id.ProjectRoot] = true
+			e.Unselected = append(e.Unselected, bmi.id.ProjectRoot)
+		}
+	}
+
+	for fc, n := range s.bgt.fails {
+		fc.Count = n
+		e.Failures = append(e.Failures, fc)
+	}
+	sort.Sort(fcountsorter(e.Failures))
+	if len(e.Failures) > maxReportedFailures {
+		e.Failures = e.Failures[:maxReportedFailures]
+	}
+
+	return e
+}
+
+type fcountsorter []FailureCount
+
+func (s fcountsorter) Len() int      { return len(s) }
+func (s fcountsorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s fcountsorter) Less(i, j int) bool {
+	if s[i].Count != s[j].Count {
+		return s[i].Count > s[j].Count
+	}
+	if s[i].Project != s[j].Project {
+		return s[i].Project < s[j].Project
+	}
+	return s[i].Reason < s[j].Reason
+}
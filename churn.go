@@ -0,0 +1,149 @@
+package gps
+
+import "sort"
+
+// A ForcedChange describes a project from the root lock that could not be kept
+// at its locked version.
+type ForcedChange struct {
+	// Ident identifies the project that changed.
+	Ident ProjectIdentifier
+
+	// Old is the version of the project in the root lock.
+	Old Version
+
+	// New is the version of the project in the solution, or nil if the
+	// solution no longer includes the project at all.
+	New Version
+
+	// Constraints holds the constraints on the project that do not allow the
+	// locked version, keyed by the root of the project that declared each one.
+	// Constraints from the root project are keyed by its own root.
+	//
+	// If the locked version was allowed by all constraints, but still could
+	// not be used - for example, because its own dependencies conflicted with
+	// the rest of the solution - this is empty.
+	Constraints map[ProjectRoot]Constraint
+}
+
+// keepsLock indicates whether selecting the provided atom would leave its
+// project as it is in the root lock.
+//
+// Projects that are not in the root lock, or which were explicitly marked for
+// change, are never considered to be moved away from the lock.
+func (s *solver) keepsLock(a atom) bool {
+	pr := a.id.ProjectRoot
+	lp, has := s.rd.rlm[pr]
+	if !has {
+		return true
+	}
+	if _, chng := s.rd.chng[pr]; chng {
+		return true
+	}
+	return lp.Version().Matches(a.v)
+}
+
+// checkLockChurn rejects atoms that would make the current selection change at
+// least as many locked projects as the best solution found so far, when solving
+// for minimal churn.
+func (s *solver) checkLockChurn(a atom) error {
+	if s.maxChurn == 0 || s.keepsLock(a) {
+		return nil
+	}
+
+	var with []atom
+	for _, sel := range s.sel.projects[s.rd.nroots():] {
+		if sel.first && !s.keepsLock(sel.a.a) {
+			with = append(with, sel.a.a)
+		}
+	}
+
+	if len(with)+1 < s.maxChurn {
+		return nil
+	}
+
+	// Only choosing different versions of the projects that have already been
+	// moved can make room for this one.
+	for _, oa := range with {
+		s.fail(oa.id)
+	}
+	return &LockChurnFailure{
+		goal: a,
+		with: with,
+		best: s.maxChurn,
+	}
+}
+
+// forcedChanges reports all the projects in the root lock that are not kept at
+// their locked version by the current selection, along with the constraints
+// that disallowed the locked version.
+func (s *solver) forcedChanges() []ForcedChange {
+	var fcl []ForcedChange
+	for pr, lp := range s.rd.rlm {
+		if _, chng := s.rd.chng[pr]; chng {
+			continue
+		}
+
+		sel, has := s.sel.selected(lp.pi)
+		if has && s.keepsLock(sel.a) {
+			continue
+		}
+
+		fc := ForcedChange{
+			Ident:       lp.pi,
+			Old:         lp.Version(),
+			Constraints: make(map[ProjectRoot]Constraint),
+		}
+		if has {
+			fc.New = sel.a.v
+		}
+		for _, dep := range s.sel.getDependenciesOn(lp.pi) {
+			if !dep.dep.Constraint.Matches(fc.Old) {
+				fc.Constraints[dep.depender.id.ProjectRoot] = dep.dep.Constraint
+			}
+		}
+		fcl = append(fcl, fc)
+	}
+
+	sort.Sort(fcsorter(fcl))
+	return fcl
+}
+
+type fcsorter []ForcedChange
+
+func (s fcsorter) Len() int           { return len(s) }
+func (s fcsorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s fcsorter) Less(i, j int) bool { return s[i].Ident.less(s[j].Ident) }
+
+// minimizeChurn continues searching from a solution that has already been
+// found, looking for solutions that move fewer of the projects in the root
+// lock. It returns the best solution it finds, with that solution's forced
+// changes.
+//
+// The search is exhaustive, but anything that could not improve on the best
+// solution so far is pruned. If solving is canceled partway through, the best
+// solution found up to that point is returned.
+func (s *solver) minimizeChurn(best solution) solution {
+	s.maxChurn = len(best.fc)
+
+	for s.maxChurn > 0 {
+		s.traceInfo("found solution changing %v locked projects; looking for a better one", s.maxChurn)
+
+		if !s.resume() {
+			break
+		}
+
+		all, err := s.solve()
+		if err != nil {
+			break
+		}
+		if fc := s.forcedChanges(); len(fc) < s.maxChurn {
+			// The search moves on from here, so the solution has to be made
+			// while its selection is still in place.
+			best = s.mkSolution(all, fc)
+			s.maxChurn = len(fc)
+		}
+	}
+
+	s.maxChurn = 0
+	return best
+}
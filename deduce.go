@@ -579,7 +579,7 @@ func (dc *deductionCoordinator) deduceRootPath(ctx context.Context, path string)
 			// metadata is in flight. Fold this request in with the existing
 			// one(s) by calling the deduction method, which will avoid
 			// duplication of work through a sync.Once.
-			return d.deduce(ctx, path)
+			return dc.deduceWith(ctx, d, path)
 		}
 
 		panic(fmt.Sprintf("unexpected %T in deductionCoordinator.rootxt: %v", data, data))
@@ -625,7 +625,30 @@ func (dc *deductionCoordinator) deduceRootPath(ctx context.Context, path string)
 	dc.mut.Unlock()
 
 	// Trigger the HTTP-backed deduction process for this requestor.
-	return hmd.deduce(ctx, path)
+	return dc.deduceWith(ctx, hmd, path)
+}
+
+// deduceWith deduces the path using the provided httpMetadataDeducer. If the
+// deducer's HTTP request was abandoned because the context it was made under
+// was canceled, the deducer is dropped from the rootxt, so that it doesn't
+// hand the same failure to every later caller; if the context for this call
+// is still live, the deduction is then tried afresh.
+func (dc *deductionCoordinator) deduceWith(ctx context.Context, hmd *httpMetadataDeducer, path string) (pathDeduction, error) {
+	pd, err := hmd.deduce(ctx, path)
+	if err == nil || !hmd.canceled {
+		return pd, err
+	}
+
+	dc.mut.Lock()
+	if data, has := dc.rootxt.Get(hmd.basePath); has && data == hmd {
+		dc.rootxt.Delete(hmd.basePath)
+	}
+	dc.mut.Unlock()
+
+	if ctx.Err() != nil {
+		return pathDeduction{}, err
+	}
+	return dc.deduceRootPath(ctx, path)
 }
 
 // pathDeduction represents the results of a successful import path deduction -
@@ -679,9 +702,11 @@ func (dc *deductionCoordinator) deduceKnownPaths(path string) (pathDeduction, er
 }
 
 type httpMetadataDeducer struct {
-	once       sync.Once
-	deduced    pathDeduction
-	deduceErr  error
+	once      sync.Once
+	deduced   pathDeduction
+	deduceErr error
+	// Whether the HTTP request was abandoned, rather than failing
+	canceled   bool
 	basePath   string
 	returnFunc func(pathDeduction)
 	suprvsr    *supervisor
@@ -704,6 +729,10 @@ func (hmd *httpMetadataDeducer) deduce(ctx context.Context, path string) (pathDe
 			root, vcs, reporoot, err = parseMetadata(ctx, path, u.Scheme)
 			return err
 		})
+		if err != nil && ctx.Err() != nil {
+			hmd.deduceErr, hmd.canceled = ctx.Err(), true
+			return
+		}
 		if err != nil {
 			hmd.deduceErr = fmt.Errorf("unable to deduce repository and source type for: %q", opath)
 			return
@@ -0,0 +1,293 @@
+package gps
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// A DepGraph is the graph of dependencies among the projects in a solution:
+// which projects depend on which others, under what constraints, and for which
+// packages. Its nodes are the atoms selected by the solver, along with the
+// root projects, which have no version.
+type DepGraph struct {
+	// All edges, sorted by depender, then dependency
+	edges []DepEdge
+	// Indexes into edges, by the root of the depender and the dependency
+	from, to map[ProjectRoot][]int
+	// The root projects, sorted
+	roots []ProjectRoot
+}
+
+// A DepEdge is a dependency of one project in a solution on another.
+type DepEdge struct {
+	// From is the depending atom. It has no Version if it is a root project.
+	From Atom
+	// To is the atom selected for the dependency.
+	To Atom
+	// Constraint is the constraint the depender placed on the dependency, after
+	// any overrides in the root manifest were applied.
+	Constraint Constraint
+	// Overridden indicates that the Constraint came from an override.
+	Overridden bool
+	// Packages lists the packages in the dependency that the depender imports,
+	// sorted.
+	Packages []string
+}
+
+func (e DepEdge) String() string {
+	return fmt.Sprintf("%s -> %s (%s)", atomString(e.From), atomString(e.To), e.Constraint)
+}
+
+// A DepPath is a chain of dependencies, leading from a root project to another
+// project in a solution. Each edge's To is the next edge's From.
+type DepPath []DepEdge
+
+func (p DepPath) String() string {
+	if len(p) == 0 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(atomString(p[0].From))
+	for _, e := range p {
+		fmt.Fprintf(&buf, " -> %s (%s)", atomString(e.To), e.Constraint)
+	}
+	return buf.String()
+}
+
+// mkDepGraph builds the graph of dependencies from the current selection.
+func (s *solver) mkDepGraph() *DepGraph {
+	g := &DepGraph{
+		from: make(map[ProjectRoot][]int),
+		to:   make(map[ProjectRoot][]int),
+	}
+	// Roots are selected as packages only, as there's no version of them to
+	// choose, but they always come first.
+	for _, sel := range s.sel.projects {
+		if sel.a.a.v != rootRev {
+			break
+		}
+		g.roots = append(g.roots, sel.a.a.id.ProjectRoot)
+	}
+	sort.Sort(projectRootSorter(g.roots))
+
+	// A project may come to depend on another more than once, as more of its
+	// packages are selected; those are combined into a single edge.
+	type key struct{ from, to ProjectRoot }
+	idx := make(map[key]int)
+	for pr, deps := range s.sel.deps {
+		awp, has := s.sel.selected(ProjectIdentifier{ProjectRoot: pr})
+		if !has {
+			continue
+		}
+		to := toAtom(awp.a)
+
+		for _, dep := range deps {
+			k := key{from: dep.depender.id.ProjectRoot, to: pr}
+			if i, has := idx[k]; has {
+				g.edges[i].Packages = append(g.edges[i].Packages, dep.dep.pl...)
+				continue
+			}
+
+			idx[k] = len(g.edges)
+			g.edges = append(g.edges, DepEdge{
+				From:       toAtom(dep.depender),
+				To:         to,
+				Constraint: dep.dep.Constraint,
+				Overridden: dep.dep.overrConstraint,
+				Packages:   append([]string(nil), dep.dep.pl...),
+			})
+		}
+	}
+
+	sort.Sort(depEdgeSorter(g.edges))
+	for k := range g.edges {
+		e := &g.edges[k]
+		e.Packages = uniqueSorted(e.Packages)
+		g.from[e.From.Ident.ProjectRoot] = append(g.from[e.From.Ident.ProjectRoot], k)
+		g.to[e.To.Ident.ProjectRoot] = append(g.to[e.To.Ident.ProjectRoot], k)
+	}
+	return g
+}
+
+func uniqueSorted(l []string) []string {
+	sort.Strings(l)
+	var k int
+	for _, s := range l {
+		if k == 0 || l[k-1] != s {
+			l[k] = s
+			k++
+		}
+	}
+	return l[:k]
+}
+
+type depEdgeSorter []DepEdge
+
+func (s depEdgeSorter) Len() int      { return len(s) }
+func (s depEdgeSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s depEdgeSorter) Less(i, j int) bool {
+	if s[i].From.Ident.ProjectRoot != s[j].From.Ident.ProjectRoot {
+		return s[i].From.Ident.ProjectRoot < s[j].From.Ident.ProjectRoot
+	}
+	return s[i].To.Ident.ProjectRoot < s[j].To.Ident.ProjectRoot
+}
+
+func (g *DepGraph) pick(il []int) []DepEdge {
+	if len(il) == 0 {
+		return nil
+	}
+	el := make([]DepEdge, len(il))
+	for k, i := range il {
+		el[k] = g.edges[i]
+	}
+	return el
+}
+
+// Roots returns the root projects from which the graph starts, sorted.
+func (g *DepGraph) Roots() []ProjectRoot {
+	if g == nil {
+		return nil
+	}
+	return g.roots
+}
+
+// Edges returns every edge in the graph, sorted by the root of the depender,
+// then by the root of the dependency.
+func (g *DepGraph) Edges() []DepEdge {
+	if g == nil {
+		return nil
+	}
+	return append([]DepEdge(nil), g.edges...)
+}
+
+// Dependencies returns the edges from the given project to those it depends
+// on, sorted by the root of the dependency.
+func (g *DepGraph) Dependencies(pr ProjectRoot) []DepEdge {
+	if g == nil {
+		return nil
+	}
+	return g.pick(g.from[pr])
+}
+
+// Dependers returns the edges to the given project from those that depend on
+// it, sorted by the root of the depender. Their constraints are the ones that
+// together determined which version of the project could be selected.
+func (g *DepGraph) Dependers(pr ProjectRoot) []DepEdge {
+	if g == nil {
+		return nil
+	}
+	return g.pick(g.to[pr])
+}
+
+// maxWhyPaths is the most paths DepGraph.Why returns. The number of paths to a
+// project can grow exponentially with the size of the graph, so they can't all
+// be found.
+const maxWhyPaths = 32
+
+// Why returns the paths by which the root projects come to depend on the given
+// project, shortest first. The last edge in each path carries one of the
+// constraints that determined the version of the project selected.
+//
+// At most 32 paths are returned, as the number of paths can grow exponentially
+// with the size of the graph. They are the shortest ones; where not all the
+// paths of the same length fit, which are left out is arbitrary, but always
+// the same for the same graph.
+//
+// No project appears more than once in a single path. Nil is returned if the
+// project is not in the graph, or is a root project.
+func (g *DepGraph) Why(pr ProjectRoot) []DepPath {
+	if g == nil || len(g.to[pr]) == 0 {
+		return nil
+	}
+
+	// Find how far each project is from the nearest root.
+	dist := make(map[ProjectRoot]int)
+	var queue []ProjectRoot
+	for _, r := range g.roots {
+		dist[r] = 0
+		queue = append(queue, r)
+	}
+	for len(queue) > 0 {
+		from := queue[0]
+		queue = queue[1:]
+		for _, i := range g.from[from] {
+			to := g.edges[i].To.Ident.ProjectRoot
+			if _, has := dist[to]; !has {
+				dist[to] = dist[from] + 1
+				queue = append(queue, to)
+			}
+		}
+	}
+
+	// Walk back from the project towards the roots, so that only edges that
+	// actually lead to it are followed. Partial paths are taken up in order of
+	// the length of the shortest path they could be part of, so paths are
+	// found shortest first, and the walk stops once it has enough of them.
+	type partial struct {
+		// The edges walked back along, from the project on
+		rev  []int
+		head ProjectRoot
+	}
+	onPath := func(p partial, pr ProjectRoot) bool {
+		if p.head == pr {
+			return true
+		}
+		for _, i := range p.rev {
+			if g.edges[i].To.Ident.ProjectRoot == pr {
+				return true
+			}
+		}
+		return false
+	}
+
+	var paths []DepPath
+	pending := map[int][]partial{dist[pr]: {{head: pr}}}
+	for l := dist[pr]; len(pending) > 0 && len(paths) < maxWhyPaths; l++ {
+		for len(pending[l]) > 0 && len(paths) < maxWhyPaths {
+			stack := pending[l]
+			p := stack[len(stack)-1]
+			pending[l] = stack[:len(stack)-1]
+
+			if len(p.rev) > 0 && g.edges[p.rev[len(p.rev)-1]].From.Version == nil {
+				path := make(DepPath, len(p.rev))
+				for k, i := range p.rev {
+					path[len(p.rev)-1-k] = g.edges[i]
+				}
+				paths = append(paths, path)
+				continue
+			}
+
+			// Pushed in reverse, so that edges come off the stack in order.
+			el := g.to[p.head]
+			for k := len(el) - 1; k >= 0; k-- {
+				from := g.edges[el[k]].From.Ident.ProjectRoot
+				d, has := dist[from]
+				if !has || onPath(p, from) {
+					continue
+				}
+				np := partial{
+					rev:  append(p.rev[:len(p.rev):len(p.rev)], el[k]),
+					head: from,
+				}
+				pending[len(np.rev)+d] = append(pending[len(np.rev)+d], np)
+			}
+		}
+		delete(pending, l)
+	}
+
+	sort.Stable(depPathSorter(paths))
+	return paths
+}
+
+type depPathSorter []DepPath
+
+func (s depPathSorter) Len() int      { return len(s) }
+func (s depPathSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s depPathSorter) Less(i, j int) bool {
+	if len(s[i]) != len(s[j]) {
+		return len(s[i]) < len(s[j])
+	}
+	return s[i].String() < s[j].String()
+}
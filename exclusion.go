@@ -0,0 +1,168 @@
+package gps
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// An ExcludingManifest is a RootManifest that also declares versions of
+// dependencies that the solver must never select, even where constraints
+// would otherwise admit them. It is an optional extension; the solver checks
+// for it with a type assertion on the root manifest.
+//
+// Exclusions are a means of retracting known-bad versions - "anything
+// matching ^1.4.0, except 1.4.3" - which constraints alone cannot express.
+type ExcludingManifest interface {
+	RootManifest
+
+	// ExcludedVersions returns the exclusions for each project.
+	ExcludedVersions() map[ProjectRoot]VersionExclusion
+}
+
+// A VersionExclusion describes the versions of a single project that are
+// excluded from solving.
+type VersionExclusion struct {
+	// Versions lists the excluded versions. A Revision excludes every version
+	// paired with it; any other version excludes that version, regardless of
+	// the revision it is paired with.
+	Versions []Version
+
+	// Patterns lists patterns, in the syntax of path.Match, that exclude any
+	// version with a matching name. Revisions are never matched. For example,
+	// "*-rc*" excludes release candidate tags.
+	Patterns []string
+
+	// Reason is an optional explanation of the exclusion, included in
+	// failure messages.
+	Reason string
+}
+
+// excludes indicates whether the exclusion applies to the given version. If
+// so, a description of the rule responsible is also returned.
+func (ve VersionExclusion) excludes(v Version) (string, bool) {
+	var r Revision
+	var uv UnpairedVersion
+	switch tv := v.(type) {
+	case Revision:
+		r = tv
+	case PairedVersion:
+		r, uv = tv.Underlying(), tv.Unpair()
+	case UnpairedVersion:
+		uv = tv
+	}
+
+	for _, ev := range ve.Versions {
+		switch tev := ev.(type) {
+		case Revision:
+			if tev == r {
+				return fmt.Sprintf("revision %s", tev), true
+			}
+		case PairedVersion:
+			if uv != nil && tev.Unpair().typedString() == uv.typedString() {
+				return fmt.Sprintf("version %s", tev.Unpair()), true
+			}
+		default:
+			if uv != nil && ev.typedString() == uv.typedString() {
+				return fmt.Sprintf("version %s", ev), true
+			}
+		}
+	}
+
+	if uv != nil {
+		for _, pat := range ve.Patterns {
+			if m, _ := path.Match(pat, uv.String()); m {
+				return fmt.Sprintf("pattern %q", pat), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// addExclusions folds the exclusions declared by a root manifest, if it
+// declares any, into the rootdata.
+func (rd *rootdata) addExclusions(m RootManifest) error {
+	em, ok := m.(ExcludingManifest)
+	if !ok {
+		return nil
+	}
+
+	for pr, ve := range em.ExcludedVersions() {
+		for _, pat := range ve.Patterns {
+			if _, err := path.Match(pat, ""); err != nil {
+				return badOptsFailure(fmt.Sprintf("invalid exclusion pattern %q for %s: %s", pat, pr, err))
+			}
+		}
+		if len(ve.Versions) == 0 && len(ve.Patterns) == 0 {
+			continue
+		}
+
+		if rd.excl == nil {
+			rd.excl = make(map[ProjectRoot]VersionExclusion)
+		}
+		// Copy defensively. Multiple roots' exclusions on the same project
+		// add up.
+		ex := rd.excl[pr]
+		ex.Versions = append(append([]Version(nil), ex.Versions...), ve.Versions...)
+		ex.Patterns = append(append([]string(nil), ex.Patterns...), ve.Patterns...)
+		if ex.Reason == "" {
+			ex.Reason = ve.Reason
+		}
+		rd.excl[pr] = ex
+	}
+
+	return nil
+}
+
+// checkExcluded checks that the atom has not been excluded by the root.
+//
+// An exclusion is a property of the atom alone, so the failure is learned from
+// immediately; that lets the solver backjump past a project for which
+// every remaining version is excluded.
+func (s *solver) checkExcluded(a atomWithPackages) error {
+	ve, has := s.rd.excl[a.a.id.ProjectRoot]
+	if !has {
+		return nil
+	}
+
+	rule, excluded := ve.excludes(a.a.v)
+	if !excluded {
+		return nil
+	}
+
+	err := &VersionExcludedFailure{
+		goal:   a.a,
+		rule:   rule,
+		reason: ve.Reason,
+	}
+	s.learn(a, err)
+	return err
+}
+
+// hashExclusions returns the exclusions as a sorted list of strings, suitable
+// for hashing. Reasons are left out, as they have no effect on solving.
+func (rd rootdata) hashExclusions() []string {
+	prs := make([]string, 0, len(rd.excl))
+	for pr := range rd.excl {
+		prs = append(prs, string(pr))
+	}
+	sort.Strings(prs)
+
+	var out []string
+	for _, pr := range prs {
+		ve := rd.excl[ProjectRoot(pr)]
+		vs := make([]string, 0, len(ve.Versions)+len(ve.Patterns))
+		for _, v := range ve.Versions {
+			vs = append(vs, v.typedString())
+		}
+		for _, pat := range ve.Patterns {
+			vs = append(vs, "pattern-"+pat)
+		}
+		sort.Strings(vs)
+
+		out = append(out, pr)
+		out = append(out, vs...)
+	}
+	return out
+}
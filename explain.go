@@ -0,0 +1,350 @@
+package gps
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A Derivation explains why solving failed, as a chain of conclusions about
+// which versions of which projects are incompatible with one another, in the
+// manner of PubGrub's error reporting.
+//
+// Each step of a Derivation draws a conclusion from facts about the projects
+// involved, such as their dependencies, and from the conclusions of earlier
+// steps, its Causes. The conclusion of the final step is the reason no
+// solution could be found.
+type Derivation struct {
+	// Failure is the failure the step explains.
+	Failure error
+	// Facts are the dependencies and other facts about the projects involved
+	// that the step relies on directly.
+	Facts []string
+	// Causes are the earlier steps that this one follows from.
+	Causes []*Derivation
+	// Conclusion states what the step establishes.
+	Conclusion string
+
+	// The atom that could not be selected, and those it conflicts with. A goal
+	// with no version stands for every version of its project.
+	goal Atom
+	with []Atom
+}
+
+// Explain derives an explanation for the failure of a solving run, from the
+// error it returned. The solver keeps a record, in each failure, of the
+// failures that led to it; Explain traces that record back to the facts
+// responsible.
+//
+// Nil is returned if err is not a SolveFailure; for example, if solving was
+// canceled or exceeded its budget, the problem may well have had a solution.
+func Explain(err error) *Derivation {
+	if ue, ok := err.(*UnsolvableError); ok {
+		err = ue.Err
+	}
+	if _, ok := err.(SolveFailure); !ok {
+		return nil
+	}
+	return newDeriver().derive(err)
+}
+
+type deriver struct {
+	// Steps already derived, by the failure they explain. Each failure is
+	// derived just once, even if it is the cause of several others.
+	done map[error]*Derivation
+}
+
+func newDeriver() *deriver {
+	return &deriver{
+		done: make(map[error]*Derivation),
+	}
+}
+
+func (dv *deriver) derive(err error) *Derivation {
+	_, ok := err.(SolveFailure)
+	if ok {
+		// All SolveFailures are pointers, so they're safe to use as keys.
+		if d, has := dv.done[err]; has {
+			return d
+		}
+	}
+
+	d := &Derivation{Failure: err}
+	if ok {
+		dv.done[err] = d
+	}
+
+	switch e := err.(type) {
+	case *NoVersionError:
+		d.goal = e.Goal()
+		if len(e.fails) == 0 {
+			d.Facts = []string{fmt.Sprintf("no versions of %s could be found", e.pn.errString())}
+		}
+		for _, f := range e.fails {
+			if f.f == nil {
+				// The version was given up on while backtracking, when
+				// everything tried after it failed.
+				a := Atom{Ident: e.pn, Version: f.v}
+				d.Causes = append(d.Causes, &Derivation{
+					Facts:      []string{fmt.Sprintf("everything tried after selecting %s failed", atomString(a))},
+					Conclusion: fmt.Sprintf("%s cannot be selected", atomString(a)),
+					goal:       a,
+				})
+				continue
+			}
+
+			c := dv.derive(f.f)
+			d.Causes = append(d.Causes, c)
+			d.with = appendAtoms(d.with, c.with, e.pn.ProjectRoot)
+		}
+	case *DisjointConstraintFailure:
+		d.goal = e.Goal()
+		d.Facts = []string{depFact(e.goal)}
+		sibs := e.failsib
+		if len(sibs) == 0 {
+			sibs = e.nofailsib
+		}
+		for _, sib := range sibs {
+			d.Facts = append(d.Facts, depFact(sib))
+			d.with = appendDeppers(d.with, sib.depender)
+		}
+	case *ConstraintNotAllowedFailure:
+		d.goal = e.Goal()
+		d.Facts = []string{depFact(e.goal)}
+		d.with = []Atom{{Ident: e.goal.dep.Ident, Version: e.v}}
+	case *VersionNotAllowedFailure:
+		d.goal = e.Goal()
+		for _, fp := range e.failparent {
+			d.Facts = append(d.Facts, depFact(fp))
+			d.with = appendDeppers(d.with, fp.depender)
+		}
+	case *SourceMismatchFailure:
+		d.goal = e.Goal()
+		d.Facts = []string{fmt.Sprintf("%s wants %s from %s", atomString(d.goal), e.shared, e.mismatch)}
+		for _, dep := range e.sel {
+			d.Facts = append(d.Facts, fmt.Sprintf("%s wants %s from %s", atomString(toAtom(dep.depender)), e.shared, e.current))
+			d.with = appendDeppers(d.with, dep.depender)
+		}
+	case *CheckeeHasProblemPackagesFailure:
+		d.goal = e.Goal()
+		for _, pp := range e.Packages() {
+			d.Facts = append(d.Facts, fmt.Sprintf("%s %s", pp.Path, problemString(pp.Err)))
+			for _, a := range pp.Dependers {
+				d.Facts = append(d.Facts, fmt.Sprintf("%s imports %s", atomString(a), pp.Path))
+				if a.Version != nil {
+					d.with = appendAtoms(d.with, []Atom{a}, "")
+				}
+			}
+		}
+	case *DepHasProblemPackagesFailure:
+		d.goal = e.Goal()
+		tgt := Atom{Ident: e.goal.dep.Ident, Version: e.v}
+		for _, pp := range e.Packages() {
+			d.Facts = append(d.Facts, fmt.Sprintf("%s imports %s", atomString(d.goal), pp.Path))
+			d.Facts = append(d.Facts, fmt.Sprintf("in %s, %s %s", atomString(tgt), pp.Path, problemString(pp.Err)))
+		}
+		d.with = []Atom{tgt}
+	case *NonexistentRevisionFailure:
+		d.goal = e.Goal()
+		d.Facts = []string{
+			depFact(e.goal),
+			fmt.Sprintf("%s has no revision %s", e.goal.dep.Ident.errString(), e.r),
+		}
+	case *LearnedConflictFailure:
+		d.goal = e.Goal()
+		d.with = e.With()
+		if e.cause != nil {
+			d.Causes = []*Derivation{dv.derive(e.cause)}
+		}
+	case *LockChurnFailure:
+		d.goal = e.Goal()
+		d.Facts = []string{fmt.Sprintf("%s would change more locked projects than a solution already found", atomString(d.goal))}
+	case *VersionExcludedFailure:
+		d.goal = e.Goal()
+		fact := fmt.Sprintf("the root manifest excludes %s (%s)", atomString(d.goal), e.rule)
+		if e.reason != "" {
+			fact += ": " + e.reason
+		}
+		d.Facts = []string{fact}
+	case *PolicyVetoFailure:
+		d.goal = e.Goal()
+		if e.dep.ProjectRoot == "" {
+			d.Facts = []string{fmt.Sprintf("policy disallows %s: %s", atomString(d.goal), e.reason)}
+		} else {
+			d.Facts = []string{fmt.Sprintf("policy disallows the dependency of %s on %s: %s", atomString(d.goal), e.dep.errString(), e.reason)}
+		}
+	case *MissingSourceFailure:
+		d.goal = e.Goal()
+		d.Facts = []string{e.Error()}
+	default:
+		d.Facts = []string{err.Error()}
+		d.Conclusion = "solving failed"
+		return d
+	}
+
+	d.Conclusion = d.conclude()
+	return d
+}
+
+// conclude states the step's conclusion from its goal and the atoms it
+// conflicts with.
+func (d *Derivation) conclude() string {
+	var with []string
+	for _, a := range d.with {
+		with = append(with, atomString(a))
+	}
+
+	if d.goal.Version == nil {
+		if len(with) == 0 {
+			return fmt.Sprintf("no version of %s can be selected", d.goal.Ident.errString())
+		}
+		return fmt.Sprintf("no version of %s can be selected together with %s", d.goal.Ident.errString(), joinAnd(with))
+	}
+
+	if len(with) == 0 {
+		return fmt.Sprintf("%s cannot be selected", atomString(d.goal))
+	}
+	return fmt.Sprintf("%s is incompatible with %s", atomString(d.goal), joinAnd(with))
+}
+
+// String renders the derivation as text, one paragraph for each step, ending
+// with the final conclusion. Steps that are relied on more than once are
+// numbered, so later steps can refer back to them.
+func (d *Derivation) String() string {
+	refs := make(map[*Derivation]int)
+	var count func(d *Derivation)
+	count = func(d *Derivation) {
+		refs[d]++
+		if refs[d] > 1 {
+			return
+		}
+		for _, c := range d.Causes {
+			count(c)
+		}
+	}
+	count(d)
+
+	var paras []string
+	nums := make(map[*Derivation]int)
+	written := make(map[*Derivation]bool)
+	var write func(d *Derivation)
+	write = func(d *Derivation) {
+		if written[d] {
+			return
+		}
+		written[d] = true
+
+		parts := append([]string(nil), d.Facts...)
+		for _, c := range d.Causes {
+			write(c)
+			if n, has := nums[c]; has {
+				parts = append(parts, fmt.Sprintf("%s (%d)", c.Conclusion, n))
+			} else {
+				parts = append(parts, c.Conclusion)
+			}
+		}
+
+		var buf bytes.Buffer
+		if refs[d] > 1 {
+			nums[d] = len(nums) + 1
+			fmt.Fprintf(&buf, "(%d) ", nums[d])
+		}
+		if len(parts) > 0 {
+			fmt.Fprintf(&buf, "Because %s, %s.", joinAnd(parts), d.Conclusion)
+		} else {
+			fmt.Fprintf(&buf, "%s.", capitalize(d.Conclusion))
+		}
+		paras = append(paras, buf.String())
+	}
+	write(d)
+
+	paras = append(paras, "So, solving failed.")
+	return strings.Join(paras, "\n\n")
+}
+
+func atomString(a Atom) string {
+	if a.Version == nil {
+		return fmt.Sprintf("root project %s", a.Ident.errString())
+	}
+	return fmt.Sprintf("%s %s", a.Ident.errString(), a.Version)
+}
+
+// depFact states a dependency as a fact.
+func depFact(dep dependency) string {
+	if dep.dep.Constraint == nil || IsAny(dep.dep.Constraint) {
+		return fmt.Sprintf("%s depends on %s", atomString(toAtom(dep.depender)), dep.dep.Ident.errString())
+	}
+	return fmt.Sprintf("%s depends on %s %s", atomString(toAtom(dep.depender)), dep.dep.Ident.errString(), dep.dep.Constraint)
+}
+
+func problemString(err error) string {
+	if err == nil {
+		return "is missing"
+	}
+	return fmt.Sprintf("does not contain usable Go code (%T)", err)
+}
+
+// appendDeppers adds a depender to a list of conflicting atoms, unless it is a
+// root project; the roots are always selected, so they go without saying.
+func appendDeppers(al []Atom, a atom) []Atom {
+	if a.v == rootRev {
+		return al
+	}
+	return appendAtoms(al, []Atom{toAtom(a)}, "")
+}
+
+// appendAtoms adds atoms to a list, skipping any already in it, and any from
+// the given project.
+func appendAtoms(al, add []Atom, skip ProjectRoot) []Atom {
+outer:
+	for _, a := range add {
+		if a.Ident.ProjectRoot == skip {
+			continue
+		}
+		for _, b := range al {
+			if a.Ident.eq(b.Ident) && a.String() == b.String() {
+				continue outer
+			}
+		}
+		al = append(al, a)
+	}
+	return al
+}
+
+// joinAnd joins a list in prose: "a", "a and b", "a, b and c".
+func joinAnd(l []string) string {
+	switch len(l) {
+	case 0:
+		return ""
+	case 1:
+		return l[0]
+	}
+	return strings.Join(l[:len(l)-1], ", ") + " and " + l[len(l)-1]
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func (s *solver) Explain() *Derivation {
+	switch s.runErr.(type) {
+	case nil, *SolveCanceledError, *BudgetExceededError:
+		return nil
+	}
+
+	nve, ok := s.exhausted.(*NoVersionError)
+	if !ok {
+		return Explain(s.runErr)
+	}
+
+	// Only the roots remain selected, so any dependencies on the project
+	// are theirs, and are why the project had to be selected at all.
+	d := Explain(nve)
+	for _, dep := range s.sel.getDependenciesOn(nve.pn) {
+		d.Facts = append(d.Facts, depFact(dep))
+	}
+	return d
+}
@@ -0,0 +1,371 @@
+package gps
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FixKind is the kind of change to the root project's inputs that a Fix
+// suggests.
+type FixKind uint8
+
+const (
+	// WidenConstraint replaces the root manifest's constraint on a project.
+	WidenConstraint FixKind = iota
+	// AddOverride adds an override for a project to the root manifest.
+	AddOverride
+	// UnlockProject allows a locked project to change, as if it were given in
+	// SolveParameters.ToChange.
+	UnlockProject
+	// IgnorePackage adds a package imported by the root project to the
+	// manifest's ignored packages.
+	IgnorePackage
+)
+
+func (k FixKind) String() string {
+	switch k {
+	case WidenConstraint:
+		return "widen-constraint"
+	case AddOverride:
+		return "add-override"
+	case UnlockProject:
+		return "unlock-project"
+	case IgnorePackage:
+		return "ignore-package"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(k))
+}
+
+// A Fix is a single change to the root project's inputs with which a failed
+// solve succeeds.
+type Fix struct {
+	Kind FixKind
+	// Project is the project the change concerns.
+	Project ProjectRoot
+	// Constraint is the new constraint on the Project, for WidenConstraint and
+	// AddOverride.
+	Constraint Constraint
+	// Package is the package to ignore, for IgnorePackage.
+	Package string
+	// Solution is the solution found with the change made.
+	Solution Solution
+}
+
+func (f Fix) String() string {
+	switch f.Kind {
+	case WidenConstraint:
+		return fmt.Sprintf("change the constraint on %s to %s", f.Project, f.Constraint)
+	case AddOverride:
+		return fmt.Sprintf("override the constraint on %s with %s", f.Project, f.Constraint)
+	case UnlockProject:
+		return fmt.Sprintf("allow %s to change from its locked version", f.Project)
+	case IgnorePackage:
+		return fmt.Sprintf("ignore package %s", f.Package)
+	}
+	return f.Kind.String()
+}
+
+// UnsolvableError is returned in place of a SolveFailure when solving fails
+// and SolveParameters.SuggestFixes is set. It carries the changes to the root
+// project's inputs that were found to make solving succeed.
+type UnsolvableError struct {
+	// Err is the failure with which solving ended.
+	Err SolveFailure
+	// Fixes lists the changes with which solving succeeds, each one found by
+	// solving again with just that change made. It may be empty.
+	Fixes []Fix
+	// Metrics reports on the work the solver did in the failed run. The
+	// solves made in looking for fixes are not included.
+	Metrics SolveMetrics
+}
+
+func (e *UnsolvableError) Error() string {
+	if len(e.Fixes) == 0 {
+		return e.Err.Error()
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(strings.TrimSuffix(e.Err.Error(), "\n"))
+	fmt.Fprintf(&buf, "\n\nSolving would succeed with any one of these changes:")
+	for _, f := range e.Fixes {
+		fmt.Fprintf(&buf, "\n\t%s", f)
+	}
+	return buf.String()
+}
+
+// fixer searches for fixes for a failed solve, by solving again with each of
+// a set of candidate changes made to the original parameters.
+type fixer struct {
+	params SolveParameters
+	sm     SourceManager
+}
+
+func newFixer(params SolveParameters, sm SourceManager) *fixer {
+	// The solves with changed inputs are an implementation detail; they
+	// aren't to be traced or recorded.
+	params.SuggestFixes = false
+	params.Trace, params.TraceLogger = false, nil
+	params.Observer, params.TraceRecord = nil, nil
+	return &fixer{params: params, sm: sm}
+}
+
+// withFixes adds suggested fixes to a failure, if they were requested.
+func (s *solver) withFixes(ctx context.Context, err error) error {
+	sf, ok := err.(SolveFailure)
+	if !ok || s.fix == nil {
+		return err
+	}
+
+	return &UnsolvableError{
+		Err:     sf,
+		Fixes:   s.fix.find(ctx, s.involved(), s.rd),
+		Metrics: s.mtr.export(s.attempts),
+	}
+}
+
+// involved returns the roots of the projects, other than the root projects,
+// that took part in the failure of the most recent solve run, sorted.
+func (s *solver) involved() []ProjectRoot {
+	d := s.Explain()
+	if d == nil {
+		return nil
+	}
+
+	seen := make(map[ProjectRoot]bool)
+	done := make(map[*Derivation]bool)
+	var walk func(d *Derivation)
+	walk = func(d *Derivation) {
+		if done[d] {
+			return
+		}
+		done[d] = true
+
+		prl := []ProjectRoot{d.goal.Ident.ProjectRoot}
+		for _, a := range d.with {
+			prl = append(prl, a.Ident.ProjectRoot)
+		}
+		// The project on which dependencies disagree is involved, too.
+		switch e := d.Failure.(type) {
+		case interface {
+			Target() ProjectIdentifier
+		}:
+			prl = append(prl, e.Target().ProjectRoot)
+		case *SourceMismatchFailure:
+			prl = append(prl, e.Target())
+		}
+
+		for _, pr := range prl {
+			if pr != "" && !s.rd.isRoot(pr) {
+				seen[pr] = true
+			}
+		}
+		for _, c := range d.Causes {
+			walk(c)
+		}
+	}
+	walk(d)
+
+	prl := make([]ProjectRoot, 0, len(seen))
+	for pr := range seen {
+		prl = append(prl, pr)
+	}
+	sort.Sort(projectRootSorter(prl))
+	return prl
+}
+
+type projectRootSorter []ProjectRoot
+
+func (s projectRootSorter) Len() int           { return len(s) }
+func (s projectRootSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s projectRootSorter) Less(i, j int) bool { return s[i] < s[j] }
+
+// find tries each candidate change for each of the given projects, and
+// returns those with which solving succeeds.
+func (f *fixer) find(ctx context.Context, prl []ProjectRoot, rd rootdata) []Fix {
+	var fixes []Fix
+	imports := rd.externalImportList()
+
+	locked := make(map[ProjectRoot]bool)
+	if f.params.Lock != nil && !f.params.ChangeAll {
+		for _, lp := range f.params.Lock.Projects() {
+			locked[lp.Ident().ProjectRoot] = true
+		}
+		for _, pr := range f.params.ToChange {
+			delete(locked, pr)
+		}
+	}
+
+	for _, pr := range prl {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if f.params.Manifest != nil {
+			if pp, has := f.params.Manifest.DependencyConstraints()[pr]; has && !IsAny(pp.Constraint) {
+				if fix, ok := f.tryConstraint(ctx, Fix{Kind: WidenConstraint, Project: pr}); ok {
+					fixes = append(fixes, fix)
+				}
+			}
+		}
+
+		if fix, ok := f.tryConstraint(ctx, Fix{Kind: AddOverride, Project: pr}); ok {
+			fixes = append(fixes, fix)
+		}
+
+		if locked[pr] {
+			if fix, ok := f.try(ctx, Fix{Kind: UnlockProject, Project: pr}); ok {
+				fixes = append(fixes, fix)
+			}
+		}
+
+		for _, ip := range imports {
+			if ip == string(pr) || strings.HasPrefix(ip, string(pr)+"/") {
+				if fix, ok := f.try(ctx, Fix{Kind: IgnorePackage, Project: pr, Package: ip}); ok {
+					fixes = append(fixes, fix)
+				}
+			}
+		}
+	}
+
+	return fixes
+}
+
+// tryConstraint tries a change of constraint on a project. The constraint is
+// first removed altogether; if that succeeds, a constraint allowing just the
+// versions compatible with the one selected is tried, too, and suggested
+// instead if it also succeeds.
+func (f *fixer) tryConstraint(ctx context.Context, fix Fix) (Fix, bool) {
+	fix.Constraint = Any()
+	fix, ok := f.try(ctx, fix)
+	if !ok {
+		return fix, false
+	}
+
+	for _, lp := range fix.Solution.Projects() {
+		if lp.Ident().ProjectRoot != fix.Project {
+			continue
+		}
+
+		var c Constraint = lp.Version()
+		if pv, ok := c.(PairedVersion); ok {
+			c = pv.Unpair()
+		}
+		if sv, ok := c.(semVersion); ok {
+			if sc, err := NewSemverConstraint("^" + sv.String()); err == nil {
+				c = sc
+			}
+		}
+		if narrow, ok := f.try(ctx, Fix{Kind: fix.Kind, Project: fix.Project, Constraint: c}); ok {
+			return narrow, true
+		}
+	}
+	return fix, true
+}
+
+// try solves with a change made to the parameters, returning the fix along
+// with the solution, if one is found.
+func (f *fixer) try(ctx context.Context, fix Fix) (Fix, bool) {
+	params := f.params
+	switch fix.Kind {
+	case WidenConstraint, AddOverride, IgnorePackage:
+		params.Manifest = fixedManifest{RootManifest: params.Manifest, fix: fix}
+	case UnlockProject:
+		params.ToChange = append(append([]ProjectRoot(nil), params.ToChange...), fix.Project)
+	}
+
+	s, err := Prepare(params, f.sm)
+	if err != nil {
+		return fix, false
+	}
+	soln, err := s.SolveContext(ctx)
+	if err != nil {
+		return fix, false
+	}
+	fix.Solution = soln
+	return fix, true
+}
+
+// fixedManifest is a root manifest with a fix applied to it.
+type fixedManifest struct {
+	RootManifest
+	fix Fix
+}
+
+func (m fixedManifest) DependencyConstraints() ProjectConstraints {
+	var pc ProjectConstraints
+	if m.RootManifest != nil {
+		pc = m.RootManifest.DependencyConstraints()
+	}
+	if m.fix.Kind != WidenConstraint {
+		return pc
+	}
+
+	npc := make(ProjectConstraints, len(pc))
+	for pr, pp := range pc {
+		npc[pr] = pp
+	}
+	pp := npc[m.fix.Project]
+	pp.Constraint = m.fix.Constraint
+	npc[m.fix.Project] = pp
+	return npc
+}
+
+func (m fixedManifest) TestDependencyConstraints() ProjectConstraints {
+	if m.RootManifest == nil {
+		return nil
+	}
+	return m.RootManifest.TestDependencyConstraints()
+}
+
+func (m fixedManifest) Overrides() ProjectConstraints {
+	var ovr ProjectConstraints
+	if m.RootManifest != nil {
+		ovr = m.RootManifest.Overrides()
+	}
+	if m.fix.Kind != AddOverride {
+		return ovr
+	}
+
+	novr := make(ProjectConstraints, len(ovr)+1)
+	for pr, pp := range ovr {
+		novr[pr] = pp
+	}
+	pp := novr[m.fix.Project]
+	pp.Constraint = m.fix.Constraint
+	novr[m.fix.Project] = pp
+	return novr
+}
+
+func (m fixedManifest) IgnoredPackages() map[string]bool {
+	var ig map[string]bool
+	if m.RootManifest != nil {
+		ig = m.RootManifest.IgnoredPackages()
+	}
+	if m.fix.Kind != IgnorePackage {
+		return ig
+	}
+
+	nig := make(map[string]bool, len(ig)+1)
+	for pkg, in := range ig {
+		nig[pkg] = in
+	}
+	nig[m.fix.Package] = true
+	return nig
+}
+
+func (m fixedManifest) RequiredPackages() map[string]bool {
+	if m.RootManifest == nil {
+		return nil
+	}
+	return m.RootManifest.RequiredPackages()
+}
+
+func (m fixedManifest) ExcludedVersions() map[ProjectRoot]VersionExclusion {
+	if em, ok := m.RootManifest.(ExcludingManifest); ok {
+		return em.ExcludedVersions()
+	}
+	return nil
+}
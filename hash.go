@@ -18,6 +18,12 @@ const (
 	hhIgnores     = "-IGNORES-"
 	hhOverrides   = "-OVERRIDES-"
 	hhAnalyzer    = "-ANALYZER-"
+	hhStrategy    = "-STRATEGY-"
+	hhOrders      = "-VERSION ORDERS-"
+	hhWorkspace   = "-WORKSPACE-"
+	hhRootPkgs    = "-ROOT PACKAGES-"
+	hhDepTests    = "-DEPENDENCY TESTS-"
+	hhExclusions  = "-EXCLUSIONS-"
 )
 
 // HashInputs computes a hash digest of all data in SolveParams and the
@@ -39,6 +45,13 @@ func (s *solver) HashInputs() (digest []byte) {
 	return
 }
 
+// A sectionWriter is an io.Writer that is told where each section of the
+// hashing inputs begins.
+type sectionWriter interface {
+	io.Writer
+	beginSection(header string)
+}
+
 func (s *solver) writeHashingInputs(w io.Writer) {
 	writeString := func(s string) {
 		// Skip zero-length string writes; it doesn't affect the real hash
@@ -50,11 +63,18 @@ func (s *solver) writeHashingInputs(w io.Writer) {
 			w.Write([]byte(s))
 		}
 	}
+	sw, _ := w.(sectionWriter)
+	writeSection := func(header string) {
+		if sw != nil {
+			sw.beginSection(header)
+		}
+		writeString(header)
+	}
 
 	// We write "section headers" into the hash purely to ease scanning when
 	// debugging this input-constructing algorithm; as long as the headers are
 	// constant, then they're effectively a no-op.
-	writeString(hhConstraints)
+	writeSection(hhConstraints)
 
 	// getApplicableConstraints will apply overrides, incorporate requireds,
 	// apply local ignores, drop stdlib imports, and finally trim out
@@ -66,7 +86,7 @@ func (s *solver) writeHashingInputs(w io.Writer) {
 	}
 
 	// Write out each discrete import, including those derived from requires.
-	writeString(hhImportsReqs)
+	writeSection(hhImportsReqs)
 	imports := s.rd.externalImportList()
 	sort.Strings(imports)
 	for _, im := range imports {
@@ -76,7 +96,7 @@ func (s *solver) writeHashingInputs(w io.Writer) {
 	// Add ignores, skipping any that point under the current project root;
 	// those will have already been implicitly incorporated by the import
 	// lister.
-	writeString(hhIgnores)
+	writeSection(hhIgnores)
 	ig := make([]string, 0, len(s.rd.ig))
 	for pkg := range s.rd.ig {
 		if !strings.HasPrefix(pkg, s.rd.rpt.ImportRoot) || !isPathPrefixOrEqual(s.rd.rpt.ImportRoot, pkg) {
@@ -92,7 +112,7 @@ func (s *solver) writeHashingInputs(w io.Writer) {
 	// Overrides *also* need their own special entry distinct from basic
 	// constraints, to represent the unique effects they can have on the entire
 	// solving process beyond root's immediate scope.
-	writeString(hhOverrides)
+	writeSection(hhOverrides)
 	for _, pc := range s.rd.ovr.asSortedSlice() {
 		writeString(string(pc.Ident.ProjectRoot))
 		if pc.Ident.Source != "" {
@@ -103,10 +123,96 @@ func (s *solver) writeHashingInputs(w io.Writer) {
 		}
 	}
 
-	writeString(hhAnalyzer)
+	writeSection(hhAnalyzer)
 	an, av := s.rd.an.Info()
 	writeString(an)
 	writeString(strconv.Itoa(av))
+
+	// The default strategy is left out entirely, so that hashes computed
+	// before strategies existed remain valid.
+	if s.rd.strat != DefaultSolveStrategy {
+		writeSection(hhStrategy)
+		writeString(s.rd.strat.String())
+	}
+
+	// Likewise for project-specific version orders.
+	if len(s.rd.ord) > 0 {
+		writeSection(hhOrders)
+		prs := make([]string, 0, len(s.rd.ord))
+		for pr := range s.rd.ord {
+			prs = append(prs, string(pr))
+		}
+		sort.Strings(prs)
+		for _, pr := range prs {
+			writeString(pr)
+			writeString(s.rd.ord[ProjectRoot(pr)].String())
+		}
+	}
+
+	// Likewise for a solve scoped to some of the root's packages.
+	if s.rd.scope != nil {
+		writeSection(hhRootPkgs)
+		pkgs := make([]string, 0, len(s.rd.scope))
+		for pkg := range s.rd.scope {
+			pkgs = append(pkgs, pkg)
+		}
+		sort.Strings(pkgs)
+		for _, pkg := range pkgs {
+			writeString(pkg)
+		}
+	}
+
+	// Likewise for the dependencies whose tests are incorporated.
+	if s.rd.testall {
+		writeSection(hhDepTests)
+		writeString("all")
+	} else if len(s.rd.tests) > 0 {
+		writeSection(hhDepTests)
+		prs := make([]string, 0, len(s.rd.tests))
+		for pr := range s.rd.tests {
+			prs = append(prs, string(pr))
+		}
+		sort.Strings(prs)
+		for _, pr := range prs {
+			writeString(pr)
+		}
+	}
+
+	// Likewise for versions excluded by the root.
+	if len(s.rd.excl) > 0 {
+		writeSection(hhExclusions)
+		for _, ex := range s.rd.hashExclusions() {
+			writeString(ex)
+		}
+	}
+
+	// And for the additional roots of a workspace, each of which contributes
+	// the same kinds of inputs as the main root.
+	if len(s.rd.ws) > 0 {
+		writeSection(hhWorkspace)
+		for _, r := range s.rd.ws {
+			writeString(r.rpt.ImportRoot)
+			for _, pd := range s.rd.applicableConstraintsOf(r) {
+				writeString(string(pd.Ident.ProjectRoot))
+				writeString(pd.Ident.Source)
+				writeString(pd.Constraint.typedString())
+			}
+			for _, im := range s.rd.externalImportListOf(r) {
+				writeString(im)
+			}
+
+			ig := make([]string, 0, len(r.ig))
+			for pkg := range r.ig {
+				if !inRoot(r.rpt.ImportRoot, pkg) {
+					ig = append(ig, pkg)
+				}
+			}
+			sort.Strings(ig)
+			for _, igp := range ig {
+				writeString(igp)
+			}
+		}
+	}
 }
 
 // bytes.Buffer wrapper that injects newlines after each call to Write().
@@ -583,3 +583,198 @@ func diffHashingInputs(s Solver, wnt []string) string {
 	tw.Flush()
 	return buf.String()
 }
+
+func TestHashInputsStrategy(t *testing.T) {
+	fix := basicFixtures["shared dependency with overlapping constraints"]
+
+	params := SolveParameters{
+		RootDir:         string(fix.ds[0].n),
+		RootPackageTree: fix.rootTree(),
+		Manifest:        fix.rootmanifest(),
+		ProjectAnalyzer: naiveAnalyzer{},
+		Strategy:        MinimalVersionSelection,
+	}
+
+	s, err := Prepare(params, newdepspecSM(fix.ds, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error while prepping solver: %s", err)
+	}
+
+	dig := s.HashInputs()
+	h := sha256.New()
+
+	elems := []string{
+		hhConstraints,
+		"a",
+		"sv-1.0.0",
+		"b",
+		"sv-1.0.0",
+		hhImportsReqs,
+		"a",
+		"b",
+		hhIgnores,
+		hhOverrides,
+		hhAnalyzer,
+		"naive-analyzer",
+		"1",
+		hhStrategy,
+		"mvs",
+	}
+	for _, v := range elems {
+		h.Write([]byte(v))
+	}
+	correct := h.Sum(nil)
+
+	if !bytes.Equal(dig, correct) {
+		t.Errorf("Hashes are not equal. Inputs:\n%s", diffHashingInputs(s, elems))
+	}
+}
+
+func TestHashInputsVersionOrders(t *testing.T) {
+	fix := basicFixtures["shared dependency with overlapping constraints"]
+
+	params := SolveParameters{
+		RootDir:         string(fix.ds[0].n),
+		RootPackageTree: fix.rootTree(),
+		Manifest:        fix.rootmanifest(),
+		ProjectAnalyzer: naiveAnalyzer{},
+		VersionOrders: map[ProjectRoot]VersionOrder{
+			"b": DowngradeOrder,
+			"a": UpgradeOrder,
+			"c": DefaultOrder,
+		},
+	}
+
+	s, err := Prepare(params, newdepspecSM(fix.ds, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error while prepping solver: %s", err)
+	}
+
+	dig := s.HashInputs()
+	h := sha256.New()
+
+	elems := []string{
+		hhConstraints,
+		"a",
+		"sv-1.0.0",
+		"b",
+		"sv-1.0.0",
+		hhImportsReqs,
+		"a",
+		"b",
+		hhIgnores,
+		hhOverrides,
+		hhAnalyzer,
+		"naive-analyzer",
+		"1",
+		hhOrders,
+		"a",
+		"upgrade",
+		"b",
+		"downgrade",
+	}
+	for _, v := range elems {
+		h.Write([]byte(v))
+	}
+	correct := h.Sum(nil)
+
+	if !bytes.Equal(dig, correct) {
+		t.Errorf("Hashes are not equal. Inputs:\n%s", diffHashingInputs(s, elems))
+	}
+
+	// Version orders have no effect under MVS, so they're left out
+	params.Strategy = MinimalVersionSelection
+	s, err = Prepare(params, newdepspecSM(fix.ds, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error while prepping solver: %s", err)
+	}
+
+	dig = s.HashInputs()
+	h = sha256.New()
+
+	elems = []string{
+		hhConstraints,
+		"a",
+		"sv-1.0.0",
+		"b",
+		"sv-1.0.0",
+		hhImportsReqs,
+		"a",
+		"b",
+		hhIgnores,
+		hhOverrides,
+		hhAnalyzer,
+		"naive-analyzer",
+		"1",
+		hhStrategy,
+		"mvs",
+	}
+	for _, v := range elems {
+		h.Write([]byte(v))
+	}
+	correct = h.Sum(nil)
+
+	if !bytes.Equal(dig, correct) {
+		t.Errorf("Hashes are not equal. Inputs:\n%s", diffHashingInputs(s, elems))
+	}
+}
+
+func TestHashInputsRootPackages(t *testing.T) {
+	fix := bimodalFixture{
+		ds: []depspec{
+			dsp(mkDepspec("root 0.0.0", "a 1.0.0", "b 1.0.0"),
+				pkg("root", "a"),
+				pkg("root/cmd", "b"),
+			),
+			dsp(mkDepspec("a 1.0.0"),
+				pkg("a")),
+			dsp(mkDepspec("b 1.0.0"),
+				pkg("b")),
+		},
+	}
+
+	params := SolveParameters{
+		RootDir:         string(fix.ds[0].n),
+		RootPackageTree: fix.rootTree(),
+		Manifest:        fix.rootmanifest(),
+		ProjectAnalyzer: naiveAnalyzer{},
+		RootPackages:    []string{"./cmd"},
+	}
+
+	s, err := Prepare(params, newbmSM(fix))
+	if err != nil {
+		t.Fatalf("Unexpected error while prepping solver: %s", err)
+	}
+
+	dig := s.HashInputs()
+	h := sha256.New()
+
+	// The constraint on a drops out along with the import of it.
+	elems := []string{
+		hhConstraints,
+		"b",
+		"sv-1.0.0",
+		hhImportsReqs,
+		"b",
+		hhIgnores,
+		hhOverrides,
+		hhAnalyzer,
+		"naive-analyzer",
+		"1",
+		hhRootPkgs,
+		"root/cmd",
+	}
+	for _, v := range elems {
+		h.Write([]byte(v))
+	}
+	correct := h.Sum(nil)
+
+	if !bytes.Equal(dig, correct) {
+		t.Errorf("Hashes are not equal. Inputs:\n%s", diffHashingInputs(s, elems))
+	}
+
+	// The solution holds only what the scoped packages need.
+	fix.r = mksolution("b 1.0.0")
+	soln, err := fixSolve(params, newbmSM(fix), t)
+	fixtureSolveSimpleChecks(fix, soln, err, t)
+}
@@ -0,0 +1,142 @@
+package gps
+
+// A SelectionHeuristic decides the order in which the solver works through
+// the projects and packages that are waiting to be selected.
+//
+// The order has no bearing on whether a solution can be found, but it can
+// greatly affect how much work it takes to find one, and, where more than one
+// solution exists, which of them is found first.
+type SelectionHeuristic interface {
+	// Less reports whether candidate i should be selected before candidate
+	// j. It must describe a strict weak ordering, and must give the same
+	// answer for the same candidates for as long as the solver's state is
+	// unchanged.
+	Less(st SelectionState, i, j SelectionCandidate) bool
+}
+
+// A SelectionCandidate is a project, or some additional packages from an
+// already-selected project, that is waiting to be selected.
+type SelectionCandidate struct {
+	// Ident identifies the project.
+	Ident ProjectIdentifier
+	// Packages lists the packages from the project that are to be selected.
+	Packages []string
+	// Selected indicates that the project has already been selected, and the
+	// candidate would only add packages to it.
+	Selected bool
+	// Locked indicates that the project is present in the root lock.
+	Locked bool
+}
+
+// SelectionState gives a SelectionHeuristic access to information about the
+// current state of the solver.
+type SelectionState interface {
+	// VersionCount returns the number of versions available for the given
+	// project. It may trigger network activity, if the version list has not
+	// yet been retrieved.
+	VersionCount(ProjectIdentifier) int
+
+	// Dependers returns the number of selected projects that depend on the
+	// given project.
+	Dependers(ProjectIdentifier) int
+
+	// Constraint returns the intersection of the constraints that selected
+	// projects place on the given project.
+	Constraint(ProjectIdentifier) Constraint
+}
+
+// DefaultSelectionHeuristic is the SelectionHeuristic the solver uses when
+// none is given in SolveParameters. It prefers, in order:
+//
+//  1. additional packages from projects that are already selected
+//  2. projects that are in the root lock
+//  3. projects with fewer available versions
+//
+// with ties broken by project name.
+type DefaultSelectionHeuristic struct{}
+
+// Less implements SelectionHeuristic.
+func (DefaultSelectionHeuristic) Less(st SelectionState, i, j SelectionCandidate) bool {
+	iname, jname := i.Ident, j.Ident
+
+	// Most important thing is pushing package additions ahead of project
+	// additions. Package additions can't walk their version queue, so all they
+	// do is narrow the possibility of success; better to find out early and
+	// fast if they're going to fail than wait until after we've done real work
+	// on a project and have to backtrack across it.
+	if i.Selected && !j.Selected {
+		return true
+	}
+	if !i.Selected && j.Selected {
+		return false
+	}
+
+	if iname.eq(jname) {
+		return false
+	}
+
+	switch {
+	case i.Locked && !j.Locked:
+		return true
+	case !i.Locked && j.Locked:
+		return false
+	case i.Locked && j.Locked:
+		return iname.less(jname)
+	}
+
+	// Now, sort by number of available versions. This will trigger network
+	// activity, but at this point we know that the project we're looking at
+	// isn't locked by the root. And, because being locked by root is the only
+	// way avoid that call when making a version queue, we know we're gonna have
+	// to pay that cost anyway.
+	iv, jv := st.VersionCount(iname), st.VersionCount(jname)
+
+	// Packages with fewer versions to pick from are less likely to benefit from
+	// backtracking, so deal with them earlier in order to minimize the amount
+	// of superfluous backtracking through them we do.
+	switch {
+	case iv == 0 && jv != 0:
+		return true
+	case iv != 0 && jv == 0:
+		return false
+	case iv != jv:
+		return iv < jv
+	}
+
+	// Finally, if all else fails, fall back to comparing by name
+	return iname.less(jname)
+}
+
+// selectionState implements SelectionState on top of a solver.
+type selectionState struct {
+	s *solver
+}
+
+func (st selectionState) VersionCount(id ProjectIdentifier) int {
+	// We can safely ignore an err from listVersions here because, if there is
+	// an actual problem, it'll be noted and handled somewhere else saner in the
+	// solving algorithm.
+	vl, _ := st.s.b.listVersions(id)
+	return len(vl)
+}
+
+func (st selectionState) Dependers(id ProjectIdentifier) int {
+	return st.s.sel.depperCount(id)
+}
+
+func (st selectionState) Constraint(id ProjectIdentifier) Constraint {
+	return st.s.sel.getConstraint(id)
+}
+
+// candidate converts an item from the unselected queue into the form given to
+// a SelectionHeuristic.
+func (s *solver) candidate(bmi bimodalIdentifier) SelectionCandidate {
+	_, sel := s.sel.selected(bmi.id)
+	_, lock := s.rd.rlm[bmi.id.ProjectRoot]
+	return SelectionCandidate{
+		Ident:    bmi.id,
+		Packages: bmi.pl,
+		Selected: sel,
+		Locked:   lock,
+	}
+}
@@ -0,0 +1,677 @@
+package gps
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/sdboyer/gps/pkgtree"
+)
+
+// This file contains the JSON forms of gps' basic types, used in the records
+// gps writes and reads. Interface types, like Version and Manifest, don't lend
+// themselves to encoding/json, so each has a plain struct counterpart, along
+// with functions to convert between the two.
+
+// jsonVersion is the JSON form of a Version, or of any other Constraint.
+type jsonVersion struct {
+	// One of "branch", "version", "semver" or "revision" for versions; for
+	// other constraints, one of "range", "any" or "none".
+	Type string `json:"type"`
+	// The version's name, or the constraint's body, if it has one.
+	Value string `json:"value,omitempty"`
+	// The revision underlying a version, or the revision itself.
+	Revision Revision `json:"revision,omitempty"`
+	// Whether a branch is the default branch.
+	Default bool `json:"default,omitempty"`
+}
+
+func toJSONVersion(v Version) *jsonVersion {
+	if v == nil {
+		return nil
+	}
+
+	var jv *jsonVersion
+	switch tv := v.(type) {
+	case Revision:
+		return &jsonVersion{Type: "revision", Revision: tv}
+	case versionPair:
+		jv = toJSONVersion(tv.v)
+		jv.Revision = tv.r
+	case branchVersion:
+		jv = &jsonVersion{Type: "branch", Value: tv.name, Default: tv.isDefault}
+	case semVersion:
+		jv = &jsonVersion{Type: "semver", Value: tv.String()}
+	case plainVersion:
+		jv = &jsonVersion{Type: "version", Value: string(tv)}
+	default:
+		jv = &jsonVersion{Type: "unknown", Value: v.String()}
+	}
+	return jv
+}
+
+func toJSONConstraint(c Constraint) *jsonVersion {
+	switch tc := c.(type) {
+	case nil:
+		return nil
+	case Version:
+		return toJSONVersion(tc)
+	case semverConstraint:
+		return &jsonVersion{Type: "range", Value: tc.String()}
+	case anyConstraint:
+		return &jsonVersion{Type: "any"}
+	case noneConstraint:
+		return &jsonVersion{Type: "none"}
+	}
+	return &jsonVersion{Type: "unknown", Value: c.String()}
+}
+
+func (jv *jsonVersion) version() (Version, error) {
+	if jv == nil {
+		return nil, nil
+	}
+
+	var uv UnpairedVersion
+	switch jv.Type {
+	case "revision":
+		return jv.Revision, nil
+	case "branch":
+		if jv.Default {
+			uv = newDefaultBranch(jv.Value)
+		} else {
+			uv = NewBranch(jv.Value)
+		}
+	case "semver":
+		sv := NewVersion(jv.Value)
+		if _, ok := sv.(semVersion); !ok {
+			return nil, fmt.Errorf("%q is not a valid semantic version", jv.Value)
+		}
+		uv = sv
+	case "version":
+		uv = plainVersion(jv.Value)
+	default:
+		return nil, fmt.Errorf("unknown version type %q", jv.Type)
+	}
+
+	if jv.Revision != "" {
+		return uv.Is(jv.Revision), nil
+	}
+	return uv, nil
+}
+
+func (jv *jsonVersion) constraint() (Constraint, error) {
+	if jv == nil {
+		return nil, nil
+	}
+
+	switch jv.Type {
+	case "range":
+		return NewSemverConstraint(jv.Value)
+	case "any":
+		return Any(), nil
+	case "none":
+		return none, nil
+	}
+	return jv.version()
+}
+
+// jsonIdent is the JSON form of a ProjectIdentifier.
+type jsonIdent struct {
+	Root   ProjectRoot `json:"root"`
+	Source string      `json:"source,omitempty"`
+}
+
+func toJSONIdent(id ProjectIdentifier) *jsonIdent {
+	return &jsonIdent{Root: id.ProjectRoot, Source: id.Source}
+}
+
+// jsonProps is the JSON form of ProjectProperties.
+type jsonProps struct {
+	Source     string       `json:"source,omitempty"`
+	Constraint *jsonVersion `json:"constraint,omitempty"`
+}
+
+func toJSONConstraints(pc ProjectConstraints) map[ProjectRoot]jsonProps {
+	if len(pc) == 0 {
+		return nil
+	}
+
+	jpc := make(map[ProjectRoot]jsonProps, len(pc))
+	for pr, pp := range pc {
+		jpc[pr] = jsonProps{
+			Source:     pp.Source,
+			Constraint: toJSONConstraint(pp.Constraint),
+		}
+	}
+	return jpc
+}
+
+func fromJSONConstraints(jpc map[ProjectRoot]jsonProps) (ProjectConstraints, error) {
+	pc := make(ProjectConstraints, len(jpc))
+	for pr, jpp := range jpc {
+		c, err := jpp.Constraint.constraint()
+		if err != nil {
+			return nil, fmt.Errorf("bad constraint on %s: %s", pr, err)
+		}
+		pc[pr] = ProjectProperties{
+			Source:     jpp.Source,
+			Constraint: c,
+		}
+	}
+	return pc, nil
+}
+
+// jsonManifest is the JSON form of a Manifest, or of a RootManifest.
+type jsonManifest struct {
+	Deps     map[ProjectRoot]jsonProps `json:"deps,omitempty"`
+	TestDeps map[ProjectRoot]jsonProps `json:"test_deps,omitempty"`
+
+	// Only for root manifests.
+	Overrides  map[ProjectRoot]jsonProps     `json:"overrides,omitempty"`
+	Ignored    []string                      `json:"ignored,omitempty"`
+	Required   []string                      `json:"required,omitempty"`
+	Exclusions map[ProjectRoot]jsonExclusion `json:"exclusions,omitempty"`
+}
+
+// jsonExclusion is the JSON form of a VersionExclusion.
+type jsonExclusion struct {
+	Versions []*jsonVersion `json:"versions,omitempty"`
+	Patterns []string       `json:"patterns,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+}
+
+func toJSONManifest(m Manifest) *jsonManifest {
+	if m == nil {
+		return nil
+	}
+
+	jm := &jsonManifest{
+		Deps:     toJSONConstraints(m.DependencyConstraints()),
+		TestDeps: toJSONConstraints(m.TestDependencyConstraints()),
+	}
+
+	if rm, ok := m.(RootManifest); ok {
+		jm.Overrides = toJSONConstraints(rm.Overrides())
+		jm.Ignored = setToList(rm.IgnoredPackages())
+		jm.Required = setToList(rm.RequiredPackages())
+	}
+
+	if em, ok := m.(ExcludingManifest); ok {
+		for pr, ve := range em.ExcludedVersions() {
+			if jm.Exclusions == nil {
+				jm.Exclusions = make(map[ProjectRoot]jsonExclusion)
+			}
+			je := jsonExclusion{
+				Patterns: ve.Patterns,
+				Reason:   ve.Reason,
+			}
+			for _, v := range ve.Versions {
+				je.Versions = append(je.Versions, toJSONVersion(v))
+			}
+			jm.Exclusions[pr] = je
+		}
+	}
+
+	return jm
+}
+
+func (jm *jsonManifest) manifest() (SimpleManifest, error) {
+	if jm == nil {
+		return SimpleManifest{}, nil
+	}
+
+	deps, err := fromJSONConstraints(jm.Deps)
+	if err != nil {
+		return SimpleManifest{}, err
+	}
+	tdeps, err := fromJSONConstraints(jm.TestDeps)
+	if err != nil {
+		return SimpleManifest{}, err
+	}
+	return SimpleManifest{Deps: deps, TestDeps: tdeps}, nil
+}
+
+// decodedRootManifest is a RootManifest decoded from its JSON form. It
+// carries any version exclusions, as well.
+type decodedRootManifest struct {
+	simpleRootManifest
+	excl map[ProjectRoot]VersionExclusion
+}
+
+func (m decodedRootManifest) ExcludedVersions() map[ProjectRoot]VersionExclusion {
+	return m.excl
+}
+
+func (jm *jsonManifest) rootManifest() (RootManifest, error) {
+	m, err := jm.manifest()
+	if err != nil {
+		return nil, err
+	}
+	rm := decodedRootManifest{
+		simpleRootManifest: simpleRootManifest{
+			c:   m.Deps,
+			tc:  m.TestDeps,
+			ovr: make(ProjectConstraints),
+			ig:  make(map[string]bool),
+			req: make(map[string]bool),
+		},
+	}
+	if jm == nil {
+		return rm, nil
+	}
+
+	rm.ovr, err = fromJSONConstraints(jm.Overrides)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range jm.Ignored {
+		rm.ig[pkg] = true
+	}
+	for _, pkg := range jm.Required {
+		rm.req[pkg] = true
+	}
+
+	for pr, je := range jm.Exclusions {
+		if rm.excl == nil {
+			rm.excl = make(map[ProjectRoot]VersionExclusion)
+		}
+		ve := VersionExclusion{
+			Patterns: je.Patterns,
+			Reason:   je.Reason,
+		}
+		for _, jv := range je.Versions {
+			v, err := jv.version()
+			if err != nil {
+				return nil, fmt.Errorf("bad exclusion on %s: %s", pr, err)
+			}
+			ve.Versions = append(ve.Versions, v)
+		}
+		rm.excl[pr] = ve
+	}
+
+	return rm, nil
+}
+
+// jsonLock is the JSON form of a Lock.
+type jsonLock struct {
+	InputHash []byte              `json:"input_hash,omitempty"`
+	Projects  []jsonLockedProject `json:"projects"`
+}
+
+// jsonLockedProject is the JSON form of a LockedProject.
+type jsonLockedProject struct {
+	Root     ProjectRoot  `json:"root"`
+	Source   string       `json:"source,omitempty"`
+	Version  *jsonVersion `json:"version"`
+	Packages []string     `json:"packages,omitempty"`
+}
+
+func toJSONLockedProjects(lps []LockedProject) []jsonLockedProject {
+	jlps := make([]jsonLockedProject, len(lps))
+	for k, lp := range lps {
+		jlps[k] = jsonLockedProject{
+			Root:     lp.pi.ProjectRoot,
+			Source:   lp.pi.Source,
+			Version:  toJSONVersion(lp.Version()),
+			Packages: lp.pkgs,
+		}
+	}
+	return jlps
+}
+
+func fromJSONLockedProjects(jlps []jsonLockedProject) ([]LockedProject, error) {
+	lps := make([]LockedProject, len(jlps))
+	for k, jlp := range jlps {
+		v, err := jlp.Version.version()
+		if err != nil {
+			return nil, fmt.Errorf("bad version for %s: %s", jlp.Root, err)
+		}
+		if v == nil {
+			return nil, fmt.Errorf("no version for %s", jlp.Root)
+		}
+		lps[k] = NewLockedProject(ProjectIdentifier{ProjectRoot: jlp.Root, Source: jlp.Source}, v, jlp.Packages)
+	}
+	return lps, nil
+}
+
+func toJSONLock(l Lock) *jsonLock {
+	if l == nil {
+		return nil
+	}
+
+	return &jsonLock{
+		InputHash: l.InputHash(),
+		Projects:  toJSONLockedProjects(l.Projects()),
+	}
+}
+
+func (jl *jsonLock) lock() (Lock, error) {
+	if jl == nil {
+		return nil, nil
+	}
+
+	lps, err := fromJSONLockedProjects(jl.Projects)
+	if err != nil {
+		return nil, err
+	}
+	return safeLock{h: jl.InputHash, p: lps}, nil
+}
+
+// jsonPackageTree is the JSON form of a pkgtree.PackageTree.
+type jsonPackageTree struct {
+	ImportRoot string                 `json:"import_root"`
+	Packages   map[string]jsonPackage `json:"packages"`
+}
+
+// jsonPackage is the JSON form of a pkgtree.PackageOrErr. Errors are kept
+// only as their message.
+type jsonPackage struct {
+	Name        string   `json:"name,omitempty"`
+	ImportPath  string   `json:"import_path,omitempty"`
+	CommentPath string   `json:"comment_path,omitempty"`
+	Imports     []string `json:"imports,omitempty"`
+	TestImports []string `json:"test_imports,omitempty"`
+	Err         string   `json:"error,omitempty"`
+}
+
+func toJSONPackageTree(ptree pkgtree.PackageTree) *jsonPackageTree {
+	jpt := &jsonPackageTree{
+		ImportRoot: ptree.ImportRoot,
+		Packages:   make(map[string]jsonPackage, len(ptree.Packages)),
+	}
+	for ip, poe := range ptree.Packages {
+		if poe.Err != nil {
+			jpt.Packages[ip] = jsonPackage{Err: poe.Err.Error()}
+			continue
+		}
+		jpt.Packages[ip] = jsonPackage{
+			Name:        poe.P.Name,
+			ImportPath:  poe.P.ImportPath,
+			CommentPath: poe.P.CommentPath,
+			Imports:     poe.P.Imports,
+			TestImports: poe.P.TestImports,
+		}
+	}
+	return jpt
+}
+
+func (jpt *jsonPackageTree) packageTree() pkgtree.PackageTree {
+	if jpt == nil {
+		return pkgtree.PackageTree{}
+	}
+
+	ptree := pkgtree.PackageTree{
+		ImportRoot: jpt.ImportRoot,
+		Packages:   make(map[string]pkgtree.PackageOrErr, len(jpt.Packages)),
+	}
+	for ip, jp := range jpt.Packages {
+		if jp.Err != "" {
+			ptree.Packages[ip] = pkgtree.PackageOrErr{Err: errors.New(jp.Err)}
+			continue
+		}
+		ptree.Packages[ip] = pkgtree.PackageOrErr{
+			P: pkgtree.Package{
+				Name:        jp.Name,
+				ImportPath:  jp.ImportPath,
+				CommentPath: jp.CommentPath,
+				Imports:     jp.Imports,
+				TestImports: jp.TestImports,
+			},
+		}
+	}
+	return ptree
+}
+
+// setToList returns the members of a set, sorted.
+func setToList(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+
+	l := make([]string, 0, len(set))
+	for s, in := range set {
+		if in {
+			l = append(l, s)
+		}
+	}
+	sort.Strings(l)
+	return l
+}
+
+// jsonFailure is the JSON form of a SolveFailure, or of any other error that
+// appears within one. Fields other than the kind and message are set according
+// to the kind of failure.
+type jsonFailure struct {
+	// The kind of failure, or "error" for errors that aren't SolveFailures.
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+
+	Goal       *jsonAtom      `json:"goal,omitempty"`
+	Constraint *jsonVersion   `json:"constraint,omitempty"`
+	Dependers  []jsonDepender `json:"dependers,omitempty"`
+
+	Target           *jsonIdent           `json:"target,omitempty"`
+	Current          *jsonVersion         `json:"current,omitempty"`
+	Selected         *jsonVersion         `json:"selected,omitempty"`
+	Revision         Revision             `json:"revision,omitempty"`
+	CurrentSource    string               `json:"current_source,omitempty"`
+	MismatchedSource string               `json:"mismatched_source,omitempty"`
+	Packages         []jsonProblemPackage `json:"packages,omitempty"`
+	Failures         []jsonVersionFailure `json:"failures,omitempty"`
+	With             []jsonAtom           `json:"with,omitempty"`
+	Cause            *jsonFailure         `json:"cause,omitempty"`
+	Best             int                  `json:"best,omitempty"`
+	Rule             string               `json:"rule,omitempty"`
+	Reason           string               `json:"reason,omitempty"`
+}
+
+// jsonAtom is the JSON form of an Atom.
+type jsonAtom struct {
+	Root    ProjectRoot  `json:"root"`
+	Source  string       `json:"source,omitempty"`
+	Version *jsonVersion `json:"version,omitempty"`
+}
+
+// jsonDepender is the JSON form of a Depender.
+type jsonDepender struct {
+	jsonAtom
+	Constraint *jsonVersion `json:"constraint"`
+	Conflicts  bool         `json:"conflicts,omitempty"`
+}
+
+// jsonProblemPackage is the JSON form of a ProblemPackage. A missing package
+// has no error.
+type jsonProblemPackage struct {
+	Path      string     `json:"path"`
+	Err       string     `json:"error,omitempty"`
+	Dependers []jsonAtom `json:"dependers,omitempty"`
+}
+
+// jsonVersionFailure is the JSON form of a VersionFailure.
+type jsonVersionFailure struct {
+	Version *jsonVersion `json:"version"`
+	Failure *jsonFailure `json:"failure"`
+}
+
+func toJSONAtom(a Atom) jsonAtom {
+	return jsonAtom{
+		Root:    a.Ident.ProjectRoot,
+		Source:  a.Ident.Source,
+		Version: toJSONVersion(a.Version),
+	}
+}
+
+func toJSONAtoms(al []Atom) []jsonAtom {
+	var jal []jsonAtom
+	for _, a := range al {
+		jal = append(jal, toJSONAtom(a))
+	}
+	return jal
+}
+
+func toJSONProblemPackages(pl []ProblemPackage) []jsonProblemPackage {
+	jpl := make([]jsonProblemPackage, len(pl))
+	for k, p := range pl {
+		jpl[k] = jsonProblemPackage{
+			Path:      p.Path,
+			Dependers: toJSONAtoms(p.Dependers),
+		}
+		if p.Err != nil {
+			jpl[k].Err = p.Err.Error()
+		}
+	}
+	return jpl
+}
+
+func toJSONFailure(err error) *jsonFailure {
+	if err == nil {
+		return nil
+	}
+
+	jf := &jsonFailure{
+		Kind:    "error",
+		Message: err.Error(),
+	}
+	sf, ok := err.(SolveFailure)
+	if !ok {
+		return jf
+	}
+
+	goal := toJSONAtom(sf.Goal())
+	jf.Goal = &goal
+	jf.Constraint = toJSONConstraint(sf.Constraint())
+	for _, d := range sf.Dependers() {
+		jf.Dependers = append(jf.Dependers, jsonDepender{
+			jsonAtom:   toJSONAtom(d.Atom),
+			Constraint: toJSONConstraint(d.Constraint),
+			Conflicts:  d.Conflicts,
+		})
+	}
+
+	switch te := err.(type) {
+	case *NoVersionError:
+		jf.Kind = "no-versions"
+		for _, f := range te.Failures() {
+			jf.Failures = append(jf.Failures, jsonVersionFailure{
+				Version: toJSONVersion(f.Version),
+				Failure: toJSONFailure(f.Err),
+			})
+		}
+	case *DisjointConstraintFailure:
+		jf.Kind = "disjoint-constraint"
+		jf.Target = toJSONIdent(te.Target())
+		jf.Current = toJSONConstraint(te.Current())
+	case *ConstraintNotAllowedFailure:
+		jf.Kind = "constraint-not-allowed"
+		jf.Target = toJSONIdent(te.Target())
+		jf.Selected = toJSONVersion(te.Selected())
+	case *VersionNotAllowedFailure:
+		jf.Kind = "version-not-allowed"
+	case *MissingSourceFailure:
+		jf.Kind = "missing-source"
+	case *SourceMismatchFailure:
+		jf.Kind = "source-mismatch"
+		jf.Target = &jsonIdent{Root: te.Target()}
+		jf.CurrentSource = te.Current()
+		jf.MismatchedSource = te.Mismatch()
+	case *CheckeeHasProblemPackagesFailure:
+		jf.Kind = "checkee-problem-packages"
+		jf.Packages = toJSONProblemPackages(te.Packages())
+	case *DepHasProblemPackagesFailure:
+		jf.Kind = "dep-problem-packages"
+		jf.Target = toJSONIdent(te.Target())
+		jf.Selected = toJSONVersion(te.Selected())
+		jf.Packages = toJSONProblemPackages(te.Packages())
+	case *NonexistentRevisionFailure:
+		jf.Kind = "nonexistent-revision"
+		jf.Target = toJSONIdent(te.Target())
+		jf.Revision = te.Revision()
+	case *LearnedConflictFailure:
+		jf.Kind = "learned-conflict"
+		jf.With = toJSONAtoms(te.With())
+		jf.Cause = toJSONFailure(te.Cause())
+	case *LockChurnFailure:
+		jf.Kind = "lock-churn"
+		jf.With = toJSONAtoms(te.With())
+		jf.Best = te.Best()
+	case *VersionExcludedFailure:
+		jf.Kind = "version-excluded"
+		jf.Rule = te.Rule()
+		jf.Reason = te.Reason()
+	case *PolicyVetoFailure:
+		jf.Kind = "policy-veto"
+		if te.Target().ProjectRoot != "" {
+			jf.Target = toJSONIdent(te.Target())
+		}
+		if te.Reason() != nil {
+			jf.Reason = te.Reason().Error()
+		}
+	}
+	return jf
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *NoVersionError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONFailure(e))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *DisjointConstraintFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONFailure(e))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *ConstraintNotAllowedFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONFailure(e))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *VersionNotAllowedFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONFailure(e))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *MissingSourceFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONFailure(e))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *SourceMismatchFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONFailure(e))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *CheckeeHasProblemPackagesFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONFailure(e))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *DepHasProblemPackagesFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONFailure(e))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *NonexistentRevisionFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONFailure(e))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *LearnedConflictFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONFailure(e))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *LockChurnFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONFailure(e))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *VersionExcludedFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONFailure(e))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *PolicyVetoFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONFailure(e))
+}
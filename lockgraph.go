@@ -0,0 +1,433 @@
+package gps
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/sdboyer/gps/pkgtree"
+)
+
+// LockGraphOptions control how NewLockGraph builds a graph from a Lock.
+type LockGraphOptions struct {
+	// Packages expands the graph from projects to the packages in them. Each
+	// package in use is a node, with edges to the packages in other projects
+	// that it reaches. Imports between packages in the same project are
+	// followed, but not drawn.
+	Packages bool
+
+	// RootPackageTree is the package tree of the root project. If it is given,
+	// the root project is included in the graph, with edges to the locked
+	// projects it imports.
+	RootPackageTree pkgtree.PackageTree
+
+	// Manifest is the root project's manifest, from which the constraints on
+	// the root project's edges are taken. It is optional.
+	Manifest Manifest
+
+	// ProjectAnalyzer reads the manifests of the locked projects, from which
+	// the constraints on their edges are taken. It is required.
+	ProjectAnalyzer ProjectAnalyzer
+}
+
+// A LockGraph is the graph of imports among the projects in a Lock, at their
+// locked versions, or among the packages in them. It can be rendered as
+// Graphviz DOT, as JSON, or as an indented tree of text.
+type LockGraph struct {
+	// All nodes, sorted by name
+	nodes  []*LockGraphNode
+	byName map[string]*LockGraphNode
+}
+
+// A LockGraphNode is a project in a LockGraph, or a package in one.
+type LockGraphNode struct {
+	// Name is the root of the project, or the import path of the package.
+	Name string
+	// Ident identifies the project, and Version is its locked version. The root
+	// project has no Version.
+	Ident   ProjectIdentifier
+	Version Version
+	// Root indicates that the node is, or is in, the root project.
+	Root bool
+	// Edges lists the node's dependencies, sorted by name.
+	Edges []LockGraphEdge
+}
+
+// A LockGraphEdge is a dependency of one node in a LockGraph on another.
+type LockGraphEdge struct {
+	// To is the node depended on.
+	To *LockGraphNode
+	// Constraint is the constraint the depending project's manifest places on
+	// the project depended on, or nil if it has none.
+	Constraint Constraint
+}
+
+func (n *LockGraphNode) label() string {
+	if n.Version == nil {
+		return n.Name
+	}
+	return fmt.Sprintf("%s@%s", n.Name, n.Version)
+}
+
+// NewLockGraph builds the graph of the projects in a Lock. The imports of each
+// project are found from the packages it lists in the Lock, by analyzing the
+// project at its locked version with the SourceManager. Imports that can't be
+// attributed to a locked project, such as those from the standard library,
+// are left out.
+func NewLockGraph(l Lock, sm SourceManager, opts LockGraphOptions) (*LockGraph, error) {
+	if l == nil {
+		return nil, errors.New("must provide non-nil Lock to NewLockGraph")
+	}
+	if opts.ProjectAnalyzer == nil {
+		return nil, errors.New("must provide a ProjectAnalyzer to NewLockGraph")
+	}
+
+	g := &LockGraph{
+		byName: make(map[string]*LockGraphNode),
+	}
+	lps := l.Projects()
+	prl := make([]ProjectRoot, 0, len(lps))
+	for _, lp := range lps {
+		prl = append(prl, lp.Ident().ProjectRoot)
+	}
+	// Longer roots sort after their prefixes, so searching from the end finds
+	// the longest match first.
+	sort.Sort(projectRootSorter(prl))
+	projectFor := func(ip string) (ProjectRoot, bool) {
+		for k := len(prl) - 1; k >= 0; k-- {
+			if strings.HasPrefix(ip, string(prl[k])) && isPathPrefixOrEqual(string(prl[k]), ip) {
+				return prl[k], true
+			}
+		}
+		return "", false
+	}
+
+	nodes := make(map[ProjectRoot]*LockGraphNode)
+	for _, lp := range lps {
+		n := &LockGraphNode{
+			Name:    string(lp.Ident().ProjectRoot),
+			Ident:   lp.Ident(),
+			Version: lp.Version(),
+		}
+		nodes[lp.Ident().ProjectRoot] = n
+		if !opts.Packages {
+			g.add(n)
+		}
+	}
+
+	// node returns the node for a package, in the given project.
+	node := func(pr ProjectRoot, ip string) *LockGraphNode {
+		if !opts.Packages {
+			return nodes[pr]
+		}
+		if n, has := g.byName[ip]; has {
+			return n
+		}
+		pn := nodes[pr]
+		return g.add(&LockGraphNode{
+			Name:    ip,
+			Ident:   pn.Ident,
+			Version: pn.Version,
+			Root:    pn.Root,
+		})
+	}
+
+	// link adds the edges from the packages of a project to everything they
+	// reach in other locked projects.
+	link := func(pr ProjectRoot, pl []string, rm pkgtree.ReachMap, pc ProjectConstraints) {
+		for _, pkg := range pl {
+			from := node(pr, pkg)
+			for _, ex := range rm[pkg].External {
+				dpr, has := projectFor(ex)
+				if !has || dpr == pr {
+					continue
+				}
+				from.addEdge(node(dpr, ex), pc[dpr].Constraint)
+			}
+		}
+	}
+
+	if opts.RootPackageTree.ImportRoot != "" {
+		pr := ProjectRoot(opts.RootPackageTree.ImportRoot)
+		nodes[pr] = &LockGraphNode{
+			Name:  string(pr),
+			Ident: ProjectIdentifier{ProjectRoot: pr},
+			Root:  true,
+		}
+		if !opts.Packages {
+			g.add(nodes[pr])
+		}
+
+		var pc ProjectConstraints
+		if opts.Manifest != nil {
+			pc = opts.Manifest.DependencyConstraints()
+		}
+		rm, _ := opts.RootPackageTree.ToReachMap(true, true, false, nil)
+		pl := make([]string, 0, len(rm))
+		for pkg := range rm {
+			pl = append(pl, pkg)
+		}
+		sort.Strings(pl)
+		link(pr, pl, rm, pc)
+	}
+
+	for _, lp := range lps {
+		id, v := lp.Ident(), lp.Version()
+		m, _, err := sm.GetManifestAndLock(id, v, opts.ProjectAnalyzer)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading the manifest of %s at %s: %s", id.errString(), v, err)
+		}
+		ptree, err := sm.ListPackages(id, v)
+		if err != nil {
+			return nil, fmt.Errorf("error while listing packages in %s at %s: %s", id.errString(), v, err)
+		}
+
+		var pc ProjectConstraints
+		if m != nil {
+			pc = m.DependencyConstraints()
+		}
+		rm, _ := ptree.ToReachMap(true, false, true, nil)
+
+		// Lock package lists are relative to the project root.
+		pl := make([]string, 0, len(lp.Packages()))
+		for _, pkg := range lp.Packages() {
+			if pkg == "." {
+				pl = append(pl, string(id.ProjectRoot))
+			} else {
+				pl = append(pl, string(id.ProjectRoot)+"/"+pkg)
+			}
+		}
+		if len(pl) == 0 {
+			for pkg := range rm {
+				pl = append(pl, pkg)
+			}
+		}
+		sort.Strings(pl)
+		for _, pkg := range pl {
+			// Make sure that even packages that import nothing appear.
+			node(id.ProjectRoot, pkg)
+		}
+		link(id.ProjectRoot, pl, rm, pc)
+	}
+
+	sort.Sort(lockGraphNodeSorter(g.nodes))
+	for _, n := range g.nodes {
+		sort.Sort(lockGraphEdgeSorter(n.Edges))
+	}
+	return g, nil
+}
+
+func (g *LockGraph) add(n *LockGraphNode) *LockGraphNode {
+	g.nodes = append(g.nodes, n)
+	g.byName[n.Name] = n
+	return n
+}
+
+func (n *LockGraphNode) addEdge(to *LockGraphNode, c Constraint) {
+	for _, e := range n.Edges {
+		if e.To == to {
+			return
+		}
+	}
+	n.Edges = append(n.Edges, LockGraphEdge{To: to, Constraint: c})
+}
+
+type lockGraphNodeSorter []*LockGraphNode
+
+func (s lockGraphNodeSorter) Len() int           { return len(s) }
+func (s lockGraphNodeSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s lockGraphNodeSorter) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+type lockGraphEdgeSorter []LockGraphEdge
+
+func (s lockGraphEdgeSorter) Len() int           { return len(s) }
+func (s lockGraphEdgeSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s lockGraphEdgeSorter) Less(i, j int) bool { return s[i].To.Name < s[j].To.Name }
+
+// Nodes returns every node in the graph, sorted by name. The slice is the
+// caller's own, but the nodes in it belong to the graph, and must not be
+// modified.
+func (g *LockGraph) Nodes() []*LockGraphNode {
+	return append([]*LockGraphNode(nil), g.all()...)
+}
+
+// Node returns the node with the given name, or nil if there isn't one.
+func (g *LockGraph) Node(name string) *LockGraphNode {
+	if g == nil {
+		return nil
+	}
+	return g.byName[name]
+}
+
+// all returns the graph's own slice of nodes, which is empty for a nil graph.
+func (g *LockGraph) all() []*LockGraphNode {
+	if g == nil {
+		return nil
+	}
+	return g.nodes
+}
+
+// tops returns the nodes from which the tree text starts: the root project,
+// and anything nothing else depends on. If a cycle leaves some nodes
+// unreachable from those, the first of them in name order is added, too,
+// until every node is reachable.
+func (g *LockGraph) tops() []*LockGraphNode {
+	depended := make(map[*LockGraphNode]bool)
+	for _, n := range g.all() {
+		for _, e := range n.Edges {
+			depended[e.To] = true
+		}
+	}
+
+	var tops []*LockGraphNode
+	reached := make(map[*LockGraphNode]bool)
+	var reach func(n *LockGraphNode)
+	reach = func(n *LockGraphNode) {
+		if reached[n] {
+			return
+		}
+		reached[n] = true
+		for _, e := range n.Edges {
+			reach(e.To)
+		}
+	}
+	for _, n := range g.all() {
+		if n.Root || !depended[n] {
+			tops = append(tops, n)
+			reach(n)
+		}
+	}
+	for _, n := range g.all() {
+		if !reached[n] {
+			tops = append(tops, n)
+			reach(n)
+		}
+	}
+
+	sort.Stable(lockGraphTopSorter(tops))
+	return tops
+}
+
+// lockGraphTopSorter puts root nodes before all others.
+type lockGraphTopSorter []*LockGraphNode
+
+func (s lockGraphTopSorter) Len() int           { return len(s) }
+func (s lockGraphTopSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s lockGraphTopSorter) Less(i, j int) bool { return s[i].Root && !s[j].Root }
+
+// WriteTree writes the graph as an indented tree of text, in the manner of
+// npm ls. Each node's dependencies are listed beneath it, along with the
+// constraint on each. A node's dependencies are listed only the first time it
+// appears; after that, it is marked as deduped.
+func (g *LockGraph) WriteTree(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	done := make(map[*LockGraphNode]bool)
+
+	var write func(n *LockGraphNode, prefix string)
+	write = func(n *LockGraphNode, prefix string) {
+		done[n] = true
+		for k, e := range n.Edges {
+			branch, indent := "├── ", "│   "
+			if k == len(n.Edges)-1 {
+				branch, indent = "└── ", "    "
+			}
+
+			fmt.Fprintf(bw, "%s%s%s", prefix, branch, e.To.label())
+			if e.Constraint != nil && !IsAny(e.Constraint) {
+				fmt.Fprintf(bw, " (%s)", e.Constraint)
+			}
+			if done[e.To] && len(e.To.Edges) > 0 {
+				fmt.Fprint(bw, " deduped\n")
+				continue
+			}
+			fmt.Fprintln(bw)
+			write(e.To, prefix+indent)
+		}
+	}
+
+	for _, n := range g.tops() {
+		if done[n] {
+			continue
+		}
+		fmt.Fprintln(bw, n.label())
+		write(n, "")
+	}
+	return bw.Flush()
+}
+
+// WriteDOT writes the graph as a Graphviz DOT digraph. Root nodes are drawn in
+// bold, and edges are labelled with their constraints.
+func (g *LockGraph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph deps {")
+	fmt.Fprintln(bw, "\tnode [shape=box];")
+
+	for _, n := range g.all() {
+		var attrs string
+		if n.Root {
+			attrs = ", style=bold"
+		}
+		fmt.Fprintf(bw, "\t\"%s\" [label=\"%s\"%s];\n", dotEscaper.Replace(n.Name), dotEscaper.Replace(n.label()), attrs)
+	}
+	for _, n := range g.all() {
+		for _, e := range n.Edges {
+			if e.Constraint != nil && !IsAny(e.Constraint) {
+				fmt.Fprintf(bw, "\t\"%s\" -> \"%s\" [label=\"%s\"];\n", dotEscaper.Replace(n.Name), dotEscaper.Replace(e.To.Name), dotEscaper.Replace(e.Constraint.String()))
+			} else {
+				fmt.Fprintf(bw, "\t\"%s\" -> \"%s\";\n", dotEscaper.Replace(n.Name), dotEscaper.Replace(e.To.Name))
+			}
+		}
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// jsonLockGraph is the JSON form of a LockGraph.
+type jsonLockGraph struct {
+	Nodes []jsonLockGraphNode `json:"nodes"`
+	Edges []jsonLockGraphEdge `json:"edges"`
+}
+
+type jsonLockGraphNode struct {
+	Name    string       `json:"name"`
+	Ident   *jsonIdent   `json:"ident"`
+	Version *jsonVersion `json:"version,omitempty"`
+	Root    bool         `json:"root,omitempty"`
+}
+
+type jsonLockGraphEdge struct {
+	From       string       `json:"from"`
+	To         string       `json:"to"`
+	Constraint *jsonVersion `json:"constraint,omitempty"`
+}
+
+// WriteJSON writes the graph as a single JSON object, with a list of nodes and
+// a list of the edges between them, which refer to the nodes by name. Versions
+// and constraints take the same form as in solve records.
+func (g *LockGraph) WriteJSON(w io.Writer) error {
+	jg := jsonLockGraph{
+		Nodes: make([]jsonLockGraphNode, 0, len(g.all())),
+		Edges: make([]jsonLockGraphEdge, 0),
+	}
+	for _, n := range g.all() {
+		jg.Nodes = append(jg.Nodes, jsonLockGraphNode{
+			Name:    n.Name,
+			Ident:   toJSONIdent(n.Ident),
+			Version: toJSONVersion(n.Version),
+			Root:    n.Root,
+		})
+		for _, e := range n.Edges {
+			je := jsonLockGraphEdge{From: n.Name, To: e.To.Name}
+			if e.Constraint != nil {
+				je.Constraint = toJSONConstraint(e.Constraint)
+			}
+			jg.Edges = append(jg.Edges, je)
+		}
+	}
+	return json.NewEncoder(w).Encode(jg)
+}
@@ -0,0 +1,160 @@
+package gps
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLockGraph(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a ^1.0.0", "b ^1.0.0"),
+			mkDepspec("a 1.0.0", "c ^1.0.0"),
+			mkDepspec("b 1.0.0", "a ^1.0.0", "c ^1.1.0"),
+			mkDepspec("c 1.0.0"),
+			mkDepspec("c 1.1.0"),
+		},
+	}
+	params := fix.params()
+	sm := newdepspecSM(fix.ds, nil)
+	soln, err := fixSolve(params, sm, t)
+	if err != nil {
+		t.Fatalf("Unexpected error while solving: %s", err)
+	}
+
+	if _, err = NewLockGraph(soln, sm, LockGraphOptions{}); err == nil {
+		t.Error("Expected an error without a ProjectAnalyzer")
+	}
+
+	g, err := NewLockGraph(soln, sm, LockGraphOptions{
+		RootPackageTree: params.RootPackageTree,
+		Manifest:        params.Manifest,
+		ProjectAnalyzer: naiveAnalyzer{},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error while building graph: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err = g.WriteTree(&buf); err != nil {
+		t.Fatalf("Unexpected error writing tree: %s", err)
+	}
+	want := `root
+├── a@1.0.0 (^1.0.0)
+│   └── c@1.1.0 (^1.0.0)
+└── b@1.0.0 (^1.0.0)
+    ├── a@1.0.0 (^1.0.0) deduped
+    └── c@1.1.0 (^1.1.0)
+`
+	if buf.String() != want {
+		t.Errorf("Unexpected tree:\n\t(GOT):\n%s\n\t(WNT):\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err = g.WriteDOT(&buf); err != nil {
+		t.Fatalf("Unexpected error writing DOT: %s", err)
+	}
+	for _, want := range []string{
+		"digraph deps {",
+		"\t\"root\" [label=\"root\", style=bold];",
+		"\t\"b\" [label=\"b@1.0.0\"];",
+		"\t\"b\" -> \"c\" [label=\"^1.1.0\"];",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected DOT to contain %q, got:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err = g.WriteJSON(&buf); err != nil {
+		t.Fatalf("Unexpected error writing JSON: %s", err)
+	}
+	var jg jsonLockGraph
+	if err = json.Unmarshal(buf.Bytes(), &jg); err != nil {
+		t.Fatalf("Unexpected error decoding JSON: %s", err)
+	}
+	if len(jg.Nodes) != 4 || len(jg.Edges) != 5 {
+		t.Errorf("Expected 4 nodes and 5 edges in JSON, got %d and %d:\n%s", len(jg.Nodes), len(jg.Edges), buf.String())
+	}
+
+	nodes := g.Nodes()
+	nodes[0] = nil
+	if g.Nodes()[0] == nil {
+		t.Error("Expected Nodes to return a copy of the graph's nodes")
+	}
+}
+
+func TestLockGraphNil(t *testing.T) {
+	var g *LockGraph
+	if g.Nodes() != nil {
+		t.Errorf("Expected no nodes in a nil graph, got %v", g.Nodes())
+	}
+	if n := g.Node("root"); n != nil {
+		t.Errorf("Expected no node in a nil graph, got %+v", n)
+	}
+
+	var buf bytes.Buffer
+	if err := g.WriteTree(&buf); err != nil || buf.Len() != 0 {
+		t.Errorf("Expected an empty tree from a nil graph, got %q (err %v)", buf.String(), err)
+	}
+	buf.Reset()
+	if err := g.WriteDOT(&buf); err != nil || !strings.Contains(buf.String(), "digraph deps {") {
+		t.Errorf("Expected an empty digraph from a nil graph, got %q (err %v)", buf.String(), err)
+	}
+	buf.Reset()
+	if err := g.WriteJSON(&buf); err != nil || strings.TrimSpace(buf.String()) != `{"nodes":[],"edges":[]}` {
+		t.Errorf("Expected an empty JSON graph from a nil graph, got %q (err %v)", buf.String(), err)
+	}
+}
+
+func TestLockGraphPackages(t *testing.T) {
+	fix := bimodalFixture{
+		ds: []depspec{
+			dsp(mkDepspec("root 0.0.0"),
+				pkg("root", "a/foo"),
+			),
+			dsp(mkDepspec("a 1.0.0"),
+				pkg("a", "b"),
+				pkg("a/foo", "a/bar"),
+				pkg("a/bar", "b/baz"),
+			),
+			dsp(mkDepspec("b 1.0.0"),
+				pkg("b"),
+				pkg("b/baz"),
+			),
+		},
+	}
+	params := fix.params()
+	sm := newbmSM(fix)
+	soln, err := fixSolve(params, sm, t)
+	if err != nil {
+		t.Fatalf("Unexpected error while solving: %s", err)
+	}
+
+	g, err := NewLockGraph(soln, sm, LockGraphOptions{
+		Packages:        true,
+		RootPackageTree: params.RootPackageTree,
+		ProjectAnalyzer: naiveAnalyzer{},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error while building graph: %s", err)
+	}
+
+	// The import of a/bar by a/foo is followed to b/baz, but isn't drawn.
+	var buf bytes.Buffer
+	g.WriteTree(&buf)
+	want := `root
+└── a/foo@1.0.0
+    └── b/baz@1.0.0
+a/bar@1.0.0
+└── b/baz@1.0.0
+`
+	if buf.String() != want {
+		t.Errorf("Unexpected tree:\n\t(GOT):\n%s\n\t(WNT):\n%s", buf.String(), want)
+	}
+	if n := g.Node("a/foo"); n == nil || n.Root || n.Ident.ProjectRoot != "a" {
+		t.Errorf("Expected package a/foo to be a node in project a, got %+v", n)
+	}
+}
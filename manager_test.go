@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/Masterminds/semver"
+	"github.com/sdboyer/gps/pkgtree"
 )
 
 var bd string
@@ -511,6 +512,70 @@ func TestDeduceProjectRoot(t *testing.T) {
 	}
 }
 
+func TestSourceMgrUsableAfterCancel(t *testing.T) {
+	useRealBridge()
+	defer overrideMkBridge()
+
+	sm, clean := mkNaiveSM(t)
+	defer clean()
+
+	params := SolveParameters{
+		RootDir: bd,
+		RootPackageTree: pkgtree.PackageTree{
+			ImportRoot: "root",
+			Packages: map[string]pkgtree.PackageOrErr{
+				"root": {
+					P: pkgtree.Package{
+						ImportPath: "root",
+						Name:       "root",
+						Imports:    []string{"github.com/sdboyer/gps"},
+					},
+				},
+			},
+		},
+		ProjectAnalyzer: naiveAnalyzer{},
+	}
+	s, err := Prepare(params, sm)
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = s.SolveContext(ctx); err == nil {
+		t.Fatal("Solve should have failed after context was canceled")
+	} else if _, ok := err.(*SolveCanceledError); !ok {
+		t.Fatalf("Expected a *SolveCanceledError, got %T: %s", err, err)
+	}
+
+	in := "github.com/sdboyer/gps"
+	pr, err := sm.DeduceProjectRoot(in + "/pkgtree")
+	if err != nil {
+		t.Errorf("Problem while detecting root of %q after cancellation: %s", in, err)
+	} else if string(pr) != in {
+		t.Errorf("Wrong project root was deduced;\n\t(GOT) %s\n\t(WNT) %s", pr, in)
+	}
+
+	if testing.Short() {
+		return
+	}
+
+	// Deducing a vanity import path takes an HTTP request, the result of which
+	// is kept for good. Giving up on it partway through must not make that
+	// result an error.
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond, cancel)
+	vin := "golang.org/x/net"
+	sm.deduceProjectRoot(ctx, vin+"/context")
+
+	pr, err = sm.DeduceProjectRoot(vin + "/context")
+	if err != nil {
+		t.Errorf("Problem while detecting root of %q after cancellation: %s", vin, err)
+	} else if string(pr) != vin {
+		t.Errorf("Wrong project root was deduced;\n\t(GOT) %s\n\t(WNT) %s", pr, vin)
+	}
+}
+
 func TestMultiFetchThreadsafe(t *testing.T) {
 	// This test is quite slow, skip it on -short
 	if testing.Short() {
@@ -883,3 +948,38 @@ func TestSupervisor(t *testing.T) {
 		return nil
 	})
 }
+
+func TestSupervisorStats(t *testing.T) {
+	superv := newSupervisor(context.Background())
+
+	lv := callInfo{name: "foo", typ: ctListVersions}
+	superv.start(lv)
+	superv.done(lv)
+	superv.start(lv)
+	superv.done(lv)
+
+	ping := callInfo{name: "bar", typ: ctSourcePing}
+	superv.start(ping)
+	superv.start(ping)
+
+	st := superv.stats()
+	if len(st.Calls) != 1 || st.Calls["list-versions"].Count != 2 {
+		t.Errorf("Expected two completed list-versions calls, got %v", st.Calls)
+	}
+	if len(st.Running) != 1 {
+		t.Fatalf("Expected one running call, got %v", st.Running)
+	}
+	if rc := st.Running[0]; rc.Name != "bar" || rc.Type != "source-ping" || rc.Count != 2 {
+		t.Errorf("Unexpected running call %+v", rc)
+	}
+
+	// The snapshot isn't affected by later calls.
+	superv.done(ping)
+	superv.done(ping)
+	if st.Calls["source-ping"].Count != 0 || len(st.Running) != 1 {
+		t.Error("Stats snapshot changed after it was taken")
+	}
+	if st = superv.stats(); st.Calls["source-ping"].Count != 1 || len(st.Running) != 0 {
+		t.Errorf("Unexpected stats after completing calls: %+v", st)
+	}
+}
@@ -9,10 +9,36 @@ import (
 	"time"
 )
 
+// SolveMetrics reports on the work the solver did in a solving run.
+//
+// A Solution carries the metrics for the run that found it, as do the
+// *SolveCanceledError, *BudgetExceededError and *UnsolvableError with which a
+// run can end. The SolveFailure types do not; when a run ends with one of
+// those, its metrics are available from Solver.Metrics.
+type SolveMetrics struct {
+	// Phases gives the wall-clock time spent in each phase of solving, by the
+	// names used in trace output. Time spent in a nested phase is not counted
+	// toward the phase enclosing it.
+	Phases map[string]time.Duration
+	// Total is the sum of the time spent in all phases.
+	Total time.Duration
+	// Attempts is the number of attempts the solver made.
+	Attempts int
+	// Backtracks gives the number of times backtracking undid the selection
+	// of a version of each project.
+	Backtracks map[ProjectRoot]int
+	// QueueAdvances is the number of times the solver moved on from a version
+	// of a project to try the next one.
+	QueueAdvances int
+}
+
 type metrics struct {
 	stack []string
 	times map[string]time.Duration
 	last  time.Time
+
+	backtracks map[ProjectRoot]int
+	advances   int
 }
 
 func newMetrics() *metrics {
@@ -21,8 +47,27 @@ func newMetrics() *metrics {
 		times: map[string]time.Duration{
 			"other": 0,
 		},
-		last: time.Now(),
+		last:       time.Now(),
+		backtracks: make(map[ProjectRoot]int),
+	}
+}
+
+// export copies the metrics into a SolveMetrics.
+func (m *metrics) export(attempts int) SolveMetrics {
+	sm := SolveMetrics{
+		Phases:        make(map[string]time.Duration, len(m.times)),
+		Attempts:      attempts,
+		Backtracks:    make(map[ProjectRoot]int, len(m.backtracks)),
+		QueueAdvances: m.advances,
+	}
+	for n, d := range m.times {
+		sm.Phases[n] = d
+		sm.Total += d
+	}
+	for pr, n := range m.backtracks {
+		sm.Backtracks[pr] = n
 	}
+	return sm
 }
 
 func (m *metrics) push(name string) {
@@ -0,0 +1,414 @@
+package gps
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A nogood is a set of atoms that the solver has learned cannot all be part of
+// the same solution.
+//
+// Each element also records the packages from its project that were involved
+// when the nogood was learned. Selecting more packages from a project can only
+// add dependencies and constraints, never remove them, so a nogood applies to
+// any state in which at least those packages are selected.
+type nogood struct {
+	elems []nogoodElem
+	// The root of the project that was being checked when the nogood was
+	// learned, or the empty string if it was derived from an exhausted version
+	// queue.
+	checkee ProjectRoot
+	// The failure from which the nogood was learned.
+	cause error
+}
+
+type nogoodElem struct {
+	a  atom
+	pl []string
+}
+
+func (ng *nogood) key() string {
+	parts := make([]string, len(ng.elems))
+	for k, e := range ng.elems {
+		parts[k] = fmt.Sprintf("%s@%s%v", e.a.id.ProjectRoot, e.a.v.typedString(), e.pl)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+// others returns the atoms of all elements of the nogood that are not from the
+// given project.
+func (ng *nogood) others(pr ProjectRoot) []atom {
+	var al []atom
+	for _, e := range ng.elems {
+		if e.a.id.ProjectRoot != pr {
+			al = append(al, e.a)
+		}
+	}
+	return al
+}
+
+// nogoodStore holds the nogoods learned during a solve run.
+type nogoodStore struct {
+	// All nogoods, indexed by the roots of each of the projects they contain.
+	byRoot map[ProjectRoot][]*nogood
+	// Keys of all nogoods in the store, so that duplicates are dropped.
+	seen map[string]bool
+	// The nogood learned from or enforced by each failure the solver has
+	// encountered.
+	byFail map[error]*nogood
+}
+
+func newNogoodStore() *nogoodStore {
+	return &nogoodStore{
+		byRoot: make(map[ProjectRoot][]*nogood),
+		seen:   make(map[string]bool),
+		byFail: make(map[error]*nogood),
+	}
+}
+
+func (ns *nogoodStore) add(ng *nogood) {
+	ns.byFail[ng.cause] = ng
+
+	k := ng.key()
+	if ns.seen[k] {
+		return
+	}
+	ns.seen[k] = true
+
+	for _, e := range ng.elems {
+		ns.byRoot[e.a.id.ProjectRoot] = append(ns.byRoot[e.a.id.ProjectRoot], ng)
+	}
+}
+
+// forFailure returns the nogood associated with a failure, if there is one.
+//
+// Only the failure types from which the solver can learn are ever associated
+// with a nogood; checking for them before touching the map also guarantees we
+// never try to hash an error with an unhashable dynamic type.
+func (ns *nogoodStore) forFailure(err error) (*nogood, bool) {
+	switch err.(type) {
+	case *VersionNotAllowedFailure, *DisjointConstraintFailure,
+		*ConstraintNotAllowedFailure, *NonexistentRevisionFailure,
+		*LearnedConflictFailure, *VersionExcludedFailure, *PolicyVetoFailure:
+		ng, has := ns.byFail[err]
+		return ng, has
+	}
+	return nil, false
+}
+
+// culpritsOf returns the atoms, aside from the one being checked, that were
+// responsible for a failure returned from check().
+//
+// If the failure is of a kind that the solver cannot learn from, false is
+// returned.
+func culpritsOf(err error) ([]atom, bool) {
+	var al []atom
+	switch e := err.(type) {
+	case *VersionNotAllowedFailure:
+		for _, dep := range e.failparent {
+			al = append(al, dep.depender)
+		}
+	case *DisjointConstraintFailure:
+		deps := e.failsib
+		if len(deps) == 0 {
+			// If no single sibling was disjoint, then it was the combination of
+			// all of them that was the problem.
+			deps = e.nofailsib
+		}
+		for _, dep := range deps {
+			al = append(al, dep.depender)
+		}
+	case *ConstraintNotAllowedFailure:
+		al = append(al, atom{id: e.goal.dep.Ident, v: e.v})
+	case *NonexistentRevisionFailure, *VersionExcludedFailure, *PolicyVetoFailure:
+		// Nothing but the atom itself is at fault.
+	default:
+		return nil, false
+	}
+	return al, true
+}
+
+// established indicates whether the element's atom is currently selected, with
+// at least the element's packages.
+func (s *solver) established(e nogoodElem) bool {
+	sel, has := s.sel.selected(e.a.id)
+	if !has || sel.a.v.typedString() != e.a.v.typedString() {
+		return false
+	}
+
+	pm := s.sel.getSelectedPackagesIn(sel.a.id)
+	for _, pkg := range e.pl {
+		if _, has := pm[pkg]; !has {
+			return false
+		}
+	}
+	return true
+}
+
+// establishedAt returns the index in s.vqs of the version queue that was
+// current when the element first became established, or -1 if it was
+// established by the root project alone. False is returned if the element is
+// not established at all.
+func (s *solver) establishedAt(e nogoodElem) (int, bool) {
+	need := make(map[string]bool, len(e.pl))
+	for _, pkg := range e.pl {
+		need[pkg] = true
+	}
+
+	lvl := -1
+	for _, p := range s.sel.projects {
+		if p.first {
+			lvl++
+		}
+		if p.a.a.id.ProjectRoot != e.a.id.ProjectRoot {
+			continue
+		}
+		if p.a.a.v.typedString() != e.a.v.typedString() {
+			return 0, false
+		}
+
+		for _, pkg := range p.a.pl {
+			delete(need, pkg)
+		}
+		if len(need) == 0 {
+			return lvl, true
+		}
+	}
+	return 0, false
+}
+
+// elemsFor converts a list of atoms into nogood elements, using the packages
+// currently selected from each. The root project and the project given as
+// the second parameter are skipped.
+//
+// If any of the atoms are not currently selected, false is returned.
+func (s *solver) elemsFor(al []atom, skip ProjectRoot) ([]nogoodElem, bool) {
+	var el []nogoodElem
+	for _, a := range al {
+		pr := a.id.ProjectRoot
+		if pr == skip || s.rd.isRoot(pr) {
+			continue
+		}
+
+		sel, has := s.sel.selected(a.id)
+		if !has || sel.a.v.typedString() != a.v.typedString() {
+			return nil, false
+		}
+
+		var pl []string
+		for pkg := range s.sel.getSelectedPackagesIn(sel.a.id) {
+			pl = append(pl, pkg)
+		}
+		sort.Strings(pl)
+		el = append(el, nogoodElem{a: sel.a, pl: pl})
+	}
+	return mergeElems(el), true
+}
+
+// mergeElems collapses elements from the same project into one, taking the
+// union of their packages.
+func mergeElems(el []nogoodElem) []nogoodElem {
+	idx := make(map[ProjectRoot]int)
+	var out []nogoodElem
+	for _, e := range el {
+		k, has := idx[e.a.id.ProjectRoot]
+		if !has {
+			idx[e.a.id.ProjectRoot] = len(out)
+			out = append(out, nogoodElem{a: e.a, pl: e.pl})
+			continue
+		}
+
+		pm := make(map[string]bool)
+		for _, pkg := range out[k].pl {
+			pm[pkg] = true
+		}
+		for _, pkg := range e.pl {
+			pm[pkg] = true
+		}
+		pl := make([]string, 0, len(pm))
+		for pkg := range pm {
+			pl = append(pl, pkg)
+		}
+		sort.Strings(pl)
+		out[k].pl = pl
+	}
+	return out
+}
+
+// learn records a nogood from a failure returned by check() for the provided
+// atom, if the failure is one the solver can learn from.
+func (s *solver) learn(a atomWithPackages, err error) {
+	culprits, ok := culpritsOf(err)
+	if !ok {
+		return
+	}
+
+	el, ok := s.elemsFor(culprits, a.a.id.ProjectRoot)
+	if !ok {
+		return
+	}
+
+	// Whether a version is allowed at all has nothing to do with which of its
+	// packages are selected; for everything else, the packages matter.
+	var pl []string
+	if !versionOnly(err) {
+		pl = make([]string, len(a.pl))
+		copy(pl, a.pl)
+		sort.Strings(pl)
+	}
+
+	s.ngs.add(&nogood{
+		elems:   append([]nogoodElem{{a: a.a, pl: pl}}, el...),
+		checkee: a.a.id.ProjectRoot,
+		cause:   err,
+	})
+}
+
+// versionOnly indicates whether a failure was due to the version of the atom
+// being checked alone, regardless of its packages.
+func versionOnly(err error) bool {
+	switch e := err.(type) {
+	case *VersionNotAllowedFailure, *VersionExcludedFailure:
+		return true
+	case *PolicyVetoFailure:
+		return e.dep.ProjectRoot == ""
+	}
+	return false
+}
+
+// checkNogoods looks for a learned nogood that would be completed by selecting
+// the provided atom. If one is found, the failure to record for the atom is
+// returned; otherwise, nil.
+func (s *solver) checkNogoods(a atomWithPackages) error {
+	pr := a.a.id.ProjectRoot
+	pm := make(map[string]bool, len(a.pl))
+	for _, pkg := range a.pl {
+		pm[pkg] = true
+	}
+
+nextng:
+	for _, ng := range s.ngs.byRoot[pr] {
+		for _, e := range ng.elems {
+			if e.a.id.ProjectRoot != pr {
+				if !s.established(e) {
+					continue nextng
+				}
+				continue
+			}
+
+			if e.a.v.typedString() != a.a.v.typedString() {
+				continue nextng
+			}
+			for _, pkg := range e.pl {
+				if !pm[pkg] {
+					continue nextng
+				}
+			}
+		}
+
+		// All the other members of the nogood are selected, and this atom is
+		// the last one. Mark the others as failed, just as check() would.
+		with := ng.others(pr)
+		for _, oa := range with {
+			s.fail(oa.id)
+		}
+
+		if ng.checkee == pr {
+			// The exact same check failed before; report it the same way.
+			return ng.cause
+		}
+
+		err := &LearnedConflictFailure{
+			goal:  a.a,
+			with:  with,
+			cause: ng.cause,
+		}
+		s.ngs.byFail[err] = ng
+		return err
+	}
+
+	return nil
+}
+
+// backjump is called when a version queue has been exhausted. If every version
+// in the queue failed for a reason the solver has learned from, it derives a
+// new nogood from the union of those reasons, then arranges for backtracking
+// to jump directly to the most recent version queue that contributed to it.
+//
+// Otherwise, backjump does nothing, and backtracking proceeds from whichever
+// version queues were marked as failed along the way.
+func (s *solver) backjump(q *versionQueue) {
+	var el []nogoodElem
+	for _, fv := range q.fails {
+		ng, has := s.ngs.forFailure(fv.f)
+		if !has {
+			return
+		}
+		for _, e := range ng.elems {
+			if e.a.id.ProjectRoot != q.id.ProjectRoot && !s.rd.isRoot(e.a.id.ProjectRoot) {
+				el = append(el, e)
+			}
+		}
+	}
+
+	// Different choices for the projects that depend on this one could change
+	// the packages required from it, or remove the need for it altogether.
+	var dependers []atom
+	for _, dep := range s.sel.getDependenciesOn(q.id) {
+		dependers = append(dependers, dep.depender)
+	}
+	del, ok := s.elemsFor(dependers, q.id.ProjectRoot)
+	if !ok {
+		return
+	}
+	el = mergeElems(append(el, del...))
+
+	// Find the most recent version queue involved in the conflict. No choice
+	// made after it could have contributed, so every queue after it can be
+	// discarded without walking through its remaining versions.
+	target := -1
+	for _, e := range el {
+		lvl, ok := s.establishedAt(e)
+		if !ok {
+			return
+		}
+		if lvl > target {
+			target = lvl
+		}
+	}
+
+	fails := make([]failedVersion, len(q.fails))
+	copy(fails, q.fails)
+	ng := &nogood{
+		elems: el,
+		cause: &NoVersionError{
+			pn:    q.id,
+			fails: fails,
+		},
+	}
+	if len(el) > 0 {
+		s.ngs.add(ng)
+	}
+
+	for k := len(s.vqs) - 1; k > target; k-- {
+		s.vqs[k].failed = false
+	}
+	if target < 0 {
+		// Nothing but the root is responsible; there's no solution to find.
+		return
+	}
+
+	tq := s.vqs[target]
+	tq.failed = true
+	sel, _ := s.sel.selected(tq.id)
+	tf := &LearnedConflictFailure{
+		goal:  sel.a,
+		with:  ng.others(tq.id.ProjectRoot),
+		cause: ng.cause,
+	}
+	s.ngs.byFail[tf] = ng
+	tq.backjump = tf
+	s.traceInfo("backjump to %s", a2vs(sel.a))
+}
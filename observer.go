@@ -0,0 +1,207 @@
+package gps
+
+// A SolveObserver is notified of the solver's progress as it works, through
+// a set of typed callbacks. It allows tools to show progress, or a live view
+// of what the solver is doing, without parsing trace output; the text trace
+// enabled by SolveParameters.Trace is itself a SolveObserver.
+//
+// Callbacks are made synchronously, from the goroutine running the solver, so
+// a slow observer makes for a slow solve. Observers must not retain the slices
+// in events past the callback.
+type SolveObserver interface {
+	// SelectRoot is called when a root project is selected, at the start of
+	// a solve run. It is called once for each root.
+	SelectRoot(RootEvent)
+
+	// Visit is called when the solver takes up an item from the queue of
+	// projects and packages waiting to be selected, or returns to a project
+	// while backtracking to look for another version.
+	Visit(VisitEvent)
+
+	// TryVersion is called when the solver begins checking a version of a
+	// project.
+	TryVersion(TryEvent)
+
+	// CheckFailed is called when a version, or additional packages from a
+	// project, fail the solver's checks.
+	CheckFailed(FailEvent)
+
+	// Backtrack is called when the solver starts backtracking, and for each
+	// selection it undoes while doing so.
+	Backtrack(BacktrackEvent)
+
+	// Select is called when a version of a project, or additional packages
+	// from an already-selected project, pass all checks and are selected.
+	Select(SelectEvent)
+
+	// Note is called with informational messages about decisions the solver
+	// makes that aren't covered by the other callbacks.
+	Note(NoteEvent)
+
+	// Finish is called once, when the solve run has finished. When
+	// enumerating multiple solutions, it is called for each solution found,
+	// or just once with the error if none are found.
+	Finish(FinishEvent)
+}
+
+// RootEvent describes the selection of a root project.
+type RootEvent struct {
+	// ImportRoot is the import root of the root project.
+	ImportRoot string
+	// InternalPackages is the number of packages in the root project that
+	// are transitively valid.
+	InternalPackages int
+	// ExternalPackages is the number of packages from other projects that the
+	// root project imports, and Projects the number of projects they are from.
+	ExternalPackages, Projects int
+}
+
+// VisitEvent describes the solver taking up a project.
+type VisitEvent struct {
+	// Depth is the depth in the search at which the event occurred, as
+	// shown in trace output.
+	Depth int
+	// Ident identifies the project.
+	Ident ProjectIdentifier
+	// Packages lists the packages to be selected from the project.
+	Packages []string
+	// PkgOnly indicates that the project has already been selected, and only
+	// the Packages are to be added to it. In that case, there are no versions
+	// to try, only the one already selected.
+	PkgOnly bool
+	// Continue indicates that the solver is returning to the project while
+	// backtracking, rather than visiting it for the first time.
+	Continue bool
+	// Versions is the number of versions left to try. If AllLoaded is false,
+	// there are at least that many, but the full version list has yet to be
+	// retrieved.
+	Versions  int
+	AllLoaded bool
+}
+
+// TryEvent describes the solver checking a version of a project.
+type TryEvent struct {
+	// Depth is the depth in the search at which the event occurred, as
+	// shown in trace output.
+	Depth int
+	// Ident identifies the project, and Version the version being tried.
+	Ident   ProjectIdentifier
+	Version Version
+}
+
+// FailEvent describes a failed check.
+type FailEvent struct {
+	// Depth is the depth in the search at which the event occurred, as
+	// shown in trace output.
+	Depth int
+	// Ident identifies the project, and Version the version that was checked.
+	Ident   ProjectIdentifier
+	Version Version
+	// Err is the reason for the failure.
+	Err error
+	// Summary is a brief, possibly multi-line, description of the failure,
+	// suited to trace output.
+	Summary string
+}
+
+// BacktrackEvent describes a step in backtracking.
+type BacktrackEvent struct {
+	// Depth is the depth in the search at which the event occurred, as
+	// shown in trace output.
+	Depth int
+	// Ident identifies the project, and Packages the packages, involved.
+	Ident    ProjectIdentifier
+	Packages []string
+	// PkgOnly indicates that only packages were involved, not the selection
+	// of the project as a whole.
+	PkgOnly bool
+	// Start indicates that this event marks the beginning of backtracking,
+	// because the project or its packages could not be selected. Otherwise,
+	// it marks the undoing of a selection.
+	Start bool
+}
+
+// SelectEvent describes a selection.
+type SelectEvent struct {
+	// Depth is the depth in the search at which the event occurred, as
+	// shown in trace output.
+	Depth int
+	// Ident identifies the project, and Version the selected version.
+	Ident   ProjectIdentifier
+	Version Version
+	// Packages lists the packages selected.
+	Packages []string
+	// PkgOnly indicates that the project was already selected, and only
+	// the Packages were added to it.
+	PkgOnly bool
+}
+
+// NoteEvent carries an informational message from the solver.
+type NoteEvent struct {
+	// Depth is the depth in the search at which the event occurred, as
+	// shown in trace output.
+	Depth int
+	// Message is the text of the note.
+	Message string
+}
+
+// FinishEvent describes the end of a solve run.
+type FinishEvent struct {
+	// Solution is the solution found, if there was no error.
+	Solution Solution
+	// Err is the error with which solving failed, if it did.
+	Err error
+	// Attempts is the number of attempts the solver made.
+	Attempts int
+}
+
+// multiObserver passes all events along to each of a list of observers.
+type multiObserver []SolveObserver
+
+func (mo multiObserver) SelectRoot(ev RootEvent) {
+	for _, o := range mo {
+		o.SelectRoot(ev)
+	}
+}
+
+func (mo multiObserver) Visit(ev VisitEvent) {
+	for _, o := range mo {
+		o.Visit(ev)
+	}
+}
+
+func (mo multiObserver) TryVersion(ev TryEvent) {
+	for _, o := range mo {
+		o.TryVersion(ev)
+	}
+}
+
+func (mo multiObserver) CheckFailed(ev FailEvent) {
+	for _, o := range mo {
+		o.CheckFailed(ev)
+	}
+}
+
+func (mo multiObserver) Backtrack(ev BacktrackEvent) {
+	for _, o := range mo {
+		o.Backtrack(ev)
+	}
+}
+
+func (mo multiObserver) Select(ev SelectEvent) {
+	for _, o := range mo {
+		o.Select(ev)
+	}
+}
+
+func (mo multiObserver) Note(ev NoteEvent) {
+	for _, o := range mo {
+		o.Note(ev)
+	}
+}
+
+func (mo multiObserver) Finish(ev FinishEvent) {
+	for _, o := range mo {
+		o.Finish(ev)
+	}
+}
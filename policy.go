@@ -0,0 +1,74 @@
+package gps
+
+// A Policy decides which dependencies are acceptable at all, over and above
+// what the constraints on them allow. It can be used to enforce organizational
+// rules - for example, blocking particular projects, versions or licenses, or
+// sources that aren't fetched over HTTPS - while solving, rather than after
+// the fact.
+//
+// A veto is not fatal to solving: the solver looks for other versions of the
+// vetoed projects, and of the projects that depend on them, just as it does
+// when constraints can't be satisfied. Only if there's no way around the veto
+// does solving fail, with the reasons given by the Policy.
+//
+// Implementations must give the same answer each time they're called with the
+// same arguments during a solve run.
+type Policy interface {
+	// CheckSource is consulted once the solver has determined the
+	// ProjectIdentifier for a dependency, whether from a manifest or by
+	// deducing the root of an imported package. If the Source is empty, the
+	// ProjectRoot is also the source.
+	//
+	// A non-nil error vetoes the dependency, and with it, the version of the
+	// project that introduced it. The error is reported as the reason.
+	CheckSource(id ProjectIdentifier) error
+
+	// CheckVersion is consulted before a version of a project is selected.
+	//
+	// A non-nil error vetoes that version. The error is reported as the
+	// reason.
+	CheckVersion(id ProjectIdentifier, v Version) error
+}
+
+// checkPolicyVersion ensures that the Policy, if any, allows the atom.
+func (s *solver) checkPolicyVersion(pa atom) error {
+	if s.pol == nil {
+		return nil
+	}
+
+	if err := s.pol.CheckVersion(pa.id, pa.v); err != nil {
+		return &PolicyVetoFailure{
+			goal:   pa,
+			reason: err,
+		}
+	}
+	return nil
+}
+
+// checkPolicySource ensures that the Policy, if any, allows the source of the
+// given dependency of the atom.
+func (s *solver) checkPolicySource(a atom, cdep completeDep) error {
+	if s.pol == nil || s.rd.isRoot(cdep.Ident.ProjectRoot) {
+		return nil
+	}
+
+	// The same dependency comes up over and over again in the course of a
+	// solve run, so each answer is kept.
+	if s.polsrc == nil {
+		s.polsrc = make(map[ProjectIdentifier]error)
+	}
+	err, has := s.polsrc[cdep.Ident]
+	if !has {
+		err = s.pol.CheckSource(cdep.Ident)
+		s.polsrc[cdep.Ident] = err
+	}
+
+	if err != nil {
+		return &PolicyVetoFailure{
+			goal:   a,
+			dep:    cdep.Ident,
+			reason: err,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package gps
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultMaxPrefetch is the number of prefetch operations allowed to run
+// concurrently if SolveParameters.MaxPrefetch is not set.
+const defaultMaxPrefetch = 8
+
+// prefetcher warms the SourceManager's caches in the background, so that the
+// information the solver is about to ask for is already on hand when it does.
+//
+// Prefetching never changes the outcome of a solve run; all it does is make
+// calls the solver would probably have made anyway, earlier, and in parallel.
+// The solver itself never waits on a prefetch, nor sees any of its errors,
+// until the end of the solve run, when it waits for any still in flight to
+// stop, so that none outlive the run. A prefetch stops once the SourceManager
+// call it is making returns; see solver.endRun for how soon that is.
+type prefetcher struct {
+	// Limits the number of prefetch operations that run concurrently.
+	sem chan struct{}
+
+	// Tracks the prefetch operations that have been started.
+	wg sync.WaitGroup
+
+	// The projects, and versions thereof, that have already been prefetched.
+	// Only ever accessed from the solver's goroutine.
+	seen map[prefetchKey]bool
+}
+
+type prefetchKey struct {
+	id ProjectIdentifier
+	v  string
+}
+
+func newPrefetcher(max int) *prefetcher {
+	return &prefetcher{
+		sem:  make(chan struct{}, max),
+		seen: make(map[prefetchKey]bool),
+	}
+}
+
+// prefetch arranges for the version list of a project, and the manifest, lock
+// and package tree of one of its versions, to be loaded in the background.
+//
+// If no version is given, the one the solver is most likely to try first is
+// used: the locked version if there is one, or else the first version in the
+// sorted version list.
+//
+// It must only be called from the solver's goroutine.
+func (b *bridge) prefetch(id ProjectIdentifier, v Version) {
+	if b.s.rd.isRoot(id.ProjectRoot) || b.s.maxPrefetch < 0 {
+		return
+	}
+	if b.pf == nil {
+		max := b.s.maxPrefetch
+		if max == 0 {
+			max = defaultMaxPrefetch
+		}
+		b.pf = newPrefetcher(max)
+	}
+
+	if v == nil && !b.s.rd.needVersionsFor(id.ProjectRoot) {
+		v = b.s.rd.rlm[id.ProjectRoot].Version()
+	}
+
+	k := prefetchKey{id: id}
+	if v != nil {
+		k.v = v.typedString()
+	}
+	if b.pf.seen[k] {
+		return
+	}
+	b.pf.seen[k] = true
+
+	ctx := b.ctx()
+	vlist := b.s.rd.needVersionsFor(id.ProjectRoot)
+	down := b.downgrade(id.ProjectRoot)
+	an := b.s.rd.an
+
+	b.pf.wg.Add(1)
+	go func() {
+		defer b.pf.wg.Done()
+		select {
+		case b.pf.sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		defer func() { <-b.pf.sem }()
+
+		// Both cases may have been ready; a run that has ended gets no more
+		// calls made on its behalf.
+		if ctx.Err() != nil {
+			return
+		}
+		b.warm(ctx, id, v, vlist, down, an)
+	}()
+}
+
+// waitPrefetch waits for all prefetch operations that have been started to
+// stop. The run's context must already have been canceled, or this may wait
+// for every one of them to finish. Even then, a prefetch blocked in a call to
+// a SourceManager that does not take contexts is waited on until that call
+// returns.
+func (b *bridge) waitPrefetch() {
+	if b.pf != nil {
+		b.pf.wg.Wait()
+	}
+}
+
+// warm does the work of a single prefetch. It calls the SourceManager
+// directly, rather than going through the bridge, as the bridge's own caches
+// and metrics are not safe for concurrent use.
+func (b *bridge) warm(ctx context.Context, id ProjectIdentifier, v Version, vlist, down bool, an ProjectAnalyzer) {
+	csm, isctx := b.sm.(ctxSourceManager)
+
+	var err error
+	if isctx {
+		err = csm.syncSourceFor(ctx, id)
+	} else {
+		err = b.sm.SyncSourceFor(id)
+	}
+	if err != nil || ctx.Err() != nil {
+		return
+	}
+
+	if vlist {
+		var pvl []PairedVersion
+		if isctx {
+			pvl, err = csm.listVersions(ctx, id)
+		} else {
+			pvl, err = b.sm.ListVersions(id)
+		}
+		if err != nil || ctx.Err() != nil {
+			return
+		}
+
+		if v == nil && len(pvl) > 0 {
+			vl := make([]Version, len(pvl))
+			for k, pv := range pvl {
+				vl[k] = pv
+			}
+			if down {
+				SortForDowngrade(vl)
+			} else {
+				SortForUpgrade(vl)
+			}
+			v = vl[0]
+		}
+	}
+	if v == nil {
+		return
+	}
+
+	if isctx {
+		csm.getManifestAndLock(ctx, id, v, an)
+		csm.listPackages(ctx, id, v)
+	} else {
+		b.sm.GetManifestAndLock(id, v, an)
+		b.sm.ListPackages(id, v)
+	}
+}
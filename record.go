@@ -0,0 +1,533 @@
+package gps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/sdboyer/gps/pkgtree"
+)
+
+// A solve record, as written to SolveParameters.TraceRecord, is a series of
+// JSON objects, one per line. The first describes the inputs to the solve.
+// Each one after that is either a call the solver made to the SourceManager,
+// along with its result, or an event from the solver, in the order the solver
+// emitted them.
+//
+// Calls made by prefetching run in the background, so their lines may fall
+// anywhere in the record.
+type recordLine struct {
+	// One of "params", "call" or "event", which determines which of the other
+	// fields is set.
+	Kind   string      `json:"kind"`
+	Params *jsonParams `json:"params,omitempty"`
+	Call   *jsonCall   `json:"call,omitempty"`
+	Event  *jsonEvent  `json:"event,omitempty"`
+}
+
+// jsonParams is the JSON form of SolveParameters. Only parameters that are
+// plain data are included; a Policy, for example, can't be recorded.
+type jsonParams struct {
+	RootDir            string                       `json:"root_dir"`
+	Analyzer           jsonAnalyzer                 `json:"analyzer"`
+	RootPackageTree    *jsonPackageTree             `json:"root_package_tree"`
+	Manifest           *jsonManifest                `json:"manifest,omitempty"`
+	RootPackages       []string                     `json:"root_packages,omitempty"`
+	DependencyTests    []ProjectRoot                `json:"dependency_tests,omitempty"`
+	AllDependencyTests bool                         `json:"all_dependency_tests,omitempty"`
+	Workspace          []jsonWorkspaceRoot          `json:"workspace,omitempty"`
+	Lock               *jsonLock                    `json:"lock,omitempty"`
+	ToChange           []ProjectRoot                `json:"to_change,omitempty"`
+	ChangeAll          bool                         `json:"change_all,omitempty"`
+	Downgrade          bool                         `json:"downgrade,omitempty"`
+	VersionOrders      map[ProjectRoot]VersionOrder `json:"version_orders,omitempty"`
+	Strategy           SolveStrategy                `json:"strategy,omitempty"`
+	MaxAttempts        int                          `json:"max_attempts,omitempty"`
+}
+
+type jsonAnalyzer struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+type jsonWorkspaceRoot struct {
+	RootPackageTree *jsonPackageTree `json:"root_package_tree"`
+	Manifest        *jsonManifest    `json:"manifest,omitempty"`
+}
+
+func toJSONParams(params SolveParameters) *jsonParams {
+	jp := &jsonParams{
+		RootDir:            params.RootDir,
+		RootPackageTree:    toJSONPackageTree(params.RootPackageTree),
+		Manifest:           toJSONManifest(params.Manifest),
+		RootPackages:       params.RootPackages,
+		DependencyTests:    params.DependencyTests,
+		AllDependencyTests: params.AllDependencyTests,
+		Lock:               toJSONLock(params.Lock),
+		ToChange:           params.ToChange,
+		ChangeAll:          params.ChangeAll,
+		Downgrade:          params.Downgrade,
+		VersionOrders:      params.VersionOrders,
+		Strategy:           params.Strategy,
+		MaxAttempts:        params.MaxAttempts,
+	}
+	if params.ProjectAnalyzer != nil {
+		jp.Analyzer.Name, jp.Analyzer.Version = params.ProjectAnalyzer.Info()
+	}
+	for _, wr := range params.Workspace {
+		jp.Workspace = append(jp.Workspace, jsonWorkspaceRoot{
+			RootPackageTree: toJSONPackageTree(wr.RootPackageTree),
+			Manifest:        toJSONManifest(wr.Manifest),
+		})
+	}
+	return jp
+}
+
+func (jp *jsonParams) params() (SolveParameters, error) {
+	params := SolveParameters{
+		RootDir:            jp.RootDir,
+		ProjectAnalyzer:    recordedAnalyzer(jp.Analyzer),
+		RootPackageTree:    jp.RootPackageTree.packageTree(),
+		RootPackages:       jp.RootPackages,
+		DependencyTests:    jp.DependencyTests,
+		AllDependencyTests: jp.AllDependencyTests,
+		ToChange:           jp.ToChange,
+		ChangeAll:          jp.ChangeAll,
+		Downgrade:          jp.Downgrade,
+		VersionOrders:      jp.VersionOrders,
+		Strategy:           jp.Strategy,
+		MaxAttempts:        jp.MaxAttempts,
+	}
+
+	var err error
+	if jp.Manifest != nil {
+		if params.Manifest, err = jp.Manifest.rootManifest(); err != nil {
+			return SolveParameters{}, err
+		}
+	}
+	if params.Lock, err = jp.Lock.lock(); err != nil {
+		return SolveParameters{}, err
+	}
+	for _, jwr := range jp.Workspace {
+		wr := WorkspaceRoot{
+			RootPackageTree: jwr.RootPackageTree.packageTree(),
+		}
+		if jwr.Manifest != nil {
+			if wr.Manifest, err = jwr.Manifest.rootManifest(); err != nil {
+				return SolveParameters{}, err
+			}
+		}
+		params.Workspace = append(params.Workspace, wr)
+	}
+
+	return params, nil
+}
+
+// recordedAnalyzer stands in for the ProjectAnalyzer used in a recorded solve.
+// It can't analyze anything, but it reports the same Info(), so that inputs
+// hash the same.
+type recordedAnalyzer jsonAnalyzer
+
+func (a recordedAnalyzer) DeriveManifestAndLock(path string, pr ProjectRoot) (Manifest, Lock, error) {
+	return nil, nil, fmt.Errorf("analyzer %s is not available when replaying a solve record", a.Name)
+}
+
+func (a recordedAnalyzer) Info() (string, int) {
+	return a.Name, a.Version
+}
+
+// jsonCall is the JSON form of a call to a SourceManager method, and its
+// result.
+type jsonCall struct {
+	// The name of the SourceManager method, and its arguments.
+	Method  string       `json:"method"`
+	Ident   *jsonIdent   `json:"ident,omitempty"`
+	Version *jsonVersion `json:"version,omitempty"`
+	Path    string       `json:"path,omitempty"`
+
+	// The results. Which are set depends on the method.
+	OK       bool             `json:"ok,omitempty"`
+	Versions []*jsonVersion   `json:"versions,omitempty"`
+	Tree     *jsonPackageTree `json:"tree,omitempty"`
+	Manifest *jsonManifest    `json:"manifest,omitempty"`
+	Lock     *jsonLock        `json:"lock,omitempty"`
+	Root     ProjectRoot      `json:"root,omitempty"`
+	Err      string           `json:"error,omitempty"`
+}
+
+// key identifies the call by its method and arguments.
+func (c *jsonCall) key() string {
+	k := c.Method
+	if c.Ident != nil {
+		k += fmt.Sprintf(" %s(from %s)", c.Ident.Root, c.Ident.Source)
+	}
+	if c.Version != nil {
+		k += fmt.Sprintf(" %s:%s:%s:%v", c.Version.Type, c.Version.Value, c.Version.Revision, c.Version.Default)
+	}
+	if c.Path != "" {
+		k += " " + c.Path
+	}
+	return k
+}
+
+func (c *jsonCall) setErr(err error) {
+	if err != nil {
+		c.Err = err.Error()
+	}
+}
+
+// jsonEvent is the JSON form of an event sent to a SolveObserver.
+type jsonEvent struct {
+	// One of "root", "visit", "try", "fail", "backtrack", "select", "note"
+	// or "finish", naming the SolveObserver method.
+	Event string `json:"event"`
+
+	Depth            int                 `json:"depth,omitempty"`
+	ImportRoot       string              `json:"import_root,omitempty"`
+	InternalPackages int                 `json:"internal_packages,omitempty"`
+	ExternalPackages int                 `json:"external_packages,omitempty"`
+	Projects         int                 `json:"projects,omitempty"`
+	Ident            *jsonIdent          `json:"ident,omitempty"`
+	Version          *jsonVersion        `json:"version,omitempty"`
+	Packages         []string            `json:"packages,omitempty"`
+	PkgOnly          bool                `json:"pkgonly,omitempty"`
+	Continue         bool                `json:"continue,omitempty"`
+	Versions         int                 `json:"versions,omitempty"`
+	AllLoaded        bool                `json:"all_loaded,omitempty"`
+	Start            bool                `json:"start,omitempty"`
+	Err              string              `json:"error,omitempty"`
+	Summary          string              `json:"summary,omitempty"`
+	Message          string              `json:"message,omitempty"`
+	Attempts         int                 `json:"attempts,omitempty"`
+	Solution         []jsonLockedProject `json:"solution,omitempty"`
+}
+
+// eventEncoder is a SolveObserver that converts each event it receives into
+// its JSON form, and passes that along.
+type eventEncoder func(*jsonEvent)
+
+func (f eventEncoder) SelectRoot(ev RootEvent) {
+	f(&jsonEvent{
+		Event:            "root",
+		ImportRoot:       ev.ImportRoot,
+		InternalPackages: ev.InternalPackages,
+		ExternalPackages: ev.ExternalPackages,
+		Projects:         ev.Projects,
+	})
+}
+
+func (f eventEncoder) Visit(ev VisitEvent) {
+	f(&jsonEvent{
+		Event:     "visit",
+		Depth:     ev.Depth,
+		Ident:     toJSONIdent(ev.Ident),
+		Packages:  ev.Packages,
+		PkgOnly:   ev.PkgOnly,
+		Continue:  ev.Continue,
+		Versions:  ev.Versions,
+		AllLoaded: ev.AllLoaded,
+	})
+}
+
+func (f eventEncoder) TryVersion(ev TryEvent) {
+	f(&jsonEvent{
+		Event:   "try",
+		Depth:   ev.Depth,
+		Ident:   toJSONIdent(ev.Ident),
+		Version: toJSONVersion(ev.Version),
+	})
+}
+
+func (f eventEncoder) CheckFailed(ev FailEvent) {
+	f(&jsonEvent{
+		Event:   "fail",
+		Depth:   ev.Depth,
+		Ident:   toJSONIdent(ev.Ident),
+		Version: toJSONVersion(ev.Version),
+		Err:     ev.Err.Error(),
+		Summary: ev.Summary,
+	})
+}
+
+func (f eventEncoder) Backtrack(ev BacktrackEvent) {
+	f(&jsonEvent{
+		Event:    "backtrack",
+		Depth:    ev.Depth,
+		Ident:    toJSONIdent(ev.Ident),
+		Packages: ev.Packages,
+		PkgOnly:  ev.PkgOnly,
+		Start:    ev.Start,
+	})
+}
+
+func (f eventEncoder) Select(ev SelectEvent) {
+	f(&jsonEvent{
+		Event:    "select",
+		Depth:    ev.Depth,
+		Ident:    toJSONIdent(ev.Ident),
+		Version:  toJSONVersion(ev.Version),
+		Packages: ev.Packages,
+		PkgOnly:  ev.PkgOnly,
+	})
+}
+
+func (f eventEncoder) Note(ev NoteEvent) {
+	f(&jsonEvent{
+		Event:   "note",
+		Depth:   ev.Depth,
+		Message: ev.Message,
+	})
+}
+
+func (f eventEncoder) Finish(ev FinishEvent) {
+	je := &jsonEvent{
+		Event:    "finish",
+		Attempts: ev.Attempts,
+	}
+	if ev.Err != nil {
+		je.Err = ev.Err.Error()
+	} else {
+		// The order of projects in a solution isn't meaningful, but it needs
+		// to be stable.
+		lps := append([]LockedProject(nil), ev.Solution.Projects()...)
+		SortLockedProjects(lps)
+		je.Solution = toJSONLockedProjects(lps)
+	}
+	f(je)
+}
+
+// recordWriter writes the lines of a solve record. It is safe for concurrent
+// use.
+//
+// Errors in writing are not reported; the record simply ends at the first
+// one.
+type recordWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+
+	// The keys of the calls written so far.
+	calls map[string]bool
+}
+
+func newRecordWriter(w io.Writer) *recordWriter {
+	return &recordWriter{
+		enc:   json.NewEncoder(w),
+		calls: make(map[string]bool),
+	}
+}
+
+func (rw *recordWriter) write(line recordLine) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.err == nil {
+		rw.err = rw.enc.Encode(line)
+	}
+}
+
+// writeCall writes a call, unless an identical call has already been written.
+func (rw *recordWriter) writeCall(c *jsonCall) {
+	k := c.key()
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.err == nil && !rw.calls[k] {
+		rw.calls[k] = true
+		rw.err = rw.enc.Encode(recordLine{Kind: "call", Call: c})
+	}
+}
+
+func (rw *recordWriter) observer() SolveObserver {
+	return eventEncoder(func(je *jsonEvent) {
+		rw.write(recordLine{Kind: "event", Event: je})
+	})
+}
+
+// NewRecordingSourceManager returns a SourceManager that passes all calls
+// through to sm, and writes each call the solver relies on, along with its
+// result, to w. The calls are written as lines of a solve record, so a
+// ReplaySourceManager can later answer them, without sm and without any
+// network access.
+//
+// Recording across several solves, perhaps on several projects, captures a
+// whole dependency universe, for use in solving offline. Each call is written
+// only once, however many times it's made, and calls interrupted by a
+// canceled context are not written at all.
+//
+// Errors are recorded only as their messages. Errors in writing to w are not
+// reported; the record simply ends at the first one.
+func NewRecordingSourceManager(sm SourceManager, w io.Writer) SourceManager {
+	return recordingSM{
+		sm: sm,
+		rw: newRecordWriter(w),
+	}
+}
+
+// recordingSM is a SourceManager that passes all calls through to another,
+// writing each call, with its result, to a solve record.
+type recordingSM struct {
+	sm SourceManager
+	rw *recordWriter
+}
+
+var _ ctxSourceManager = recordingSM{}
+
+func (r recordingSM) record(ctx context.Context, c *jsonCall) {
+	if ctx.Err() == nil {
+		r.rw.writeCall(c)
+	}
+}
+
+func (r recordingSM) SourceExists(id ProjectIdentifier) (bool, error) {
+	return r.sourceExists(context.Background(), id)
+}
+
+func (r recordingSM) sourceExists(ctx context.Context, id ProjectIdentifier) (bool, error) {
+	var exists bool
+	var err error
+	if csm, ok := r.sm.(ctxSourceManager); ok {
+		exists, err = csm.sourceExists(ctx, id)
+	} else {
+		exists, err = r.sm.SourceExists(id)
+	}
+
+	c := &jsonCall{Method: "SourceExists", Ident: toJSONIdent(id), OK: exists}
+	c.setErr(err)
+	r.record(ctx, c)
+	return exists, err
+}
+
+func (r recordingSM) SyncSourceFor(id ProjectIdentifier) error {
+	return r.syncSourceFor(context.Background(), id)
+}
+
+func (r recordingSM) syncSourceFor(ctx context.Context, id ProjectIdentifier) error {
+	var err error
+	if csm, ok := r.sm.(ctxSourceManager); ok {
+		err = csm.syncSourceFor(ctx, id)
+	} else {
+		err = r.sm.SyncSourceFor(id)
+	}
+
+	c := &jsonCall{Method: "SyncSourceFor", Ident: toJSONIdent(id)}
+	c.setErr(err)
+	r.record(ctx, c)
+	return err
+}
+
+func (r recordingSM) ListVersions(id ProjectIdentifier) ([]PairedVersion, error) {
+	return r.listVersions(context.Background(), id)
+}
+
+func (r recordingSM) listVersions(ctx context.Context, id ProjectIdentifier) ([]PairedVersion, error) {
+	var pvl []PairedVersion
+	var err error
+	if csm, ok := r.sm.(ctxSourceManager); ok {
+		pvl, err = csm.listVersions(ctx, id)
+	} else {
+		pvl, err = r.sm.ListVersions(id)
+	}
+
+	c := &jsonCall{Method: "ListVersions", Ident: toJSONIdent(id)}
+	for _, pv := range pvl {
+		c.Versions = append(c.Versions, toJSONVersion(pv))
+	}
+	c.setErr(err)
+	r.record(ctx, c)
+	return pvl, err
+}
+
+func (r recordingSM) RevisionPresentIn(id ProjectIdentifier, rev Revision) (bool, error) {
+	return r.revisionPresentIn(context.Background(), id, rev)
+}
+
+func (r recordingSM) revisionPresentIn(ctx context.Context, id ProjectIdentifier, rev Revision) (bool, error) {
+	var present bool
+	var err error
+	if csm, ok := r.sm.(ctxSourceManager); ok {
+		present, err = csm.revisionPresentIn(ctx, id, rev)
+	} else {
+		present, err = r.sm.RevisionPresentIn(id, rev)
+	}
+
+	c := &jsonCall{Method: "RevisionPresentIn", Ident: toJSONIdent(id), Version: toJSONVersion(rev), OK: present}
+	c.setErr(err)
+	r.record(ctx, c)
+	return present, err
+}
+
+func (r recordingSM) ListPackages(id ProjectIdentifier, v Version) (pkgtree.PackageTree, error) {
+	return r.listPackages(context.Background(), id, v)
+}
+
+func (r recordingSM) listPackages(ctx context.Context, id ProjectIdentifier, v Version) (pkgtree.PackageTree, error) {
+	var ptree pkgtree.PackageTree
+	var err error
+	if csm, ok := r.sm.(ctxSourceManager); ok {
+		ptree, err = csm.listPackages(ctx, id, v)
+	} else {
+		ptree, err = r.sm.ListPackages(id, v)
+	}
+
+	c := &jsonCall{Method: "ListPackages", Ident: toJSONIdent(id), Version: toJSONVersion(v)}
+	if err == nil {
+		c.Tree = toJSONPackageTree(ptree)
+	}
+	c.setErr(err)
+	r.record(ctx, c)
+	return ptree, err
+}
+
+func (r recordingSM) GetManifestAndLock(id ProjectIdentifier, v Version, an ProjectAnalyzer) (Manifest, Lock, error) {
+	return r.getManifestAndLock(context.Background(), id, v, an)
+}
+
+func (r recordingSM) getManifestAndLock(ctx context.Context, id ProjectIdentifier, v Version, an ProjectAnalyzer) (Manifest, Lock, error) {
+	var m Manifest
+	var l Lock
+	var err error
+	if csm, ok := r.sm.(ctxSourceManager); ok {
+		m, l, err = csm.getManifestAndLock(ctx, id, v, an)
+	} else {
+		m, l, err = r.sm.GetManifestAndLock(id, v, an)
+	}
+
+	c := &jsonCall{
+		Method:   "GetManifestAndLock",
+		Ident:    toJSONIdent(id),
+		Version:  toJSONVersion(v),
+		Manifest: toJSONManifest(m),
+		Lock:     toJSONLock(l),
+	}
+	c.setErr(err)
+	r.record(ctx, c)
+	return m, l, err
+}
+
+func (r recordingSM) DeduceProjectRoot(ip string) (ProjectRoot, error) {
+	return r.deduceProjectRoot(context.Background(), ip)
+}
+
+func (r recordingSM) deduceProjectRoot(ctx context.Context, ip string) (ProjectRoot, error) {
+	var pr ProjectRoot
+	var err error
+	if csm, ok := r.sm.(ctxSourceManager); ok {
+		pr, err = csm.deduceProjectRoot(ctx, ip)
+	} else {
+		pr, err = r.sm.DeduceProjectRoot(ip)
+	}
+
+	c := &jsonCall{Method: "DeduceProjectRoot", Path: ip, Root: pr}
+	c.setErr(err)
+	r.record(ctx, c)
+	return pr, err
+}
+
+func (r recordingSM) ExportProject(id ProjectIdentifier, v Version, to string) error {
+	return r.sm.ExportProject(id, v, to)
+}
+
+func (r recordingSM) Release() {
+	r.sm.Release()
+}
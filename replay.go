@@ -0,0 +1,244 @@
+package gps
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/sdboyer/gps/pkgtree"
+)
+
+// A ReplaySourceManager is a SourceManager that answers the solver's calls
+// from a solve record, rather than from any real source.
+//
+// A record written to SolveParameters.TraceRecord describes a single solve
+// run. Together with the parameters from the same record, the
+// ReplaySourceManager repeats that run exactly, without any network access and
+// regardless of the state of any local caches. While replaying, it also
+// compares each decision the solver makes against the decisions in the record.
+// A difference indicates that the solver's behavior has changed since the
+// record was made; see Divergence. Such a run can be replayed just once.
+//
+// A record written by a SourceManager from NewRecordingSourceManager contains
+// only calls and their results. It can be used for any number of solves, with
+// any parameters, as long as they need no calls that weren't recorded.
+type ReplaySourceManager struct {
+	params SolveParameters
+	calls  map[string]*jsonCall
+	events []*jsonEvent
+
+	mu   sync.Mutex
+	next int
+	div  error
+}
+
+var _ SourceManager = &ReplaySourceManager{}
+
+// NewReplaySourceManager reads a solve record, and prepares to replay it.
+func NewReplaySourceManager(r io.Reader) (*ReplaySourceManager, error) {
+	rsm := &ReplaySourceManager{
+		calls: make(map[string]*jsonCall),
+	}
+
+	var k int
+	dec := json.NewDecoder(r)
+	for ; ; k++ {
+		var line recordLine
+		if err := dec.Decode(&line); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("bad solve record: %s", err)
+		}
+
+		if k > 0 && line.Kind == "params" {
+			return nil, errors.New("bad solve record: parameters may only be given on the first line")
+		}
+		switch {
+		case line.Kind == "params" && line.Params != nil:
+			params, err := line.Params.params()
+			if err != nil {
+				return nil, fmt.Errorf("bad solve record: %s", err)
+			}
+			rsm.params = params
+		case line.Kind == "call" && line.Call != nil:
+			// The same call may be made more than once; the SourceManager
+			// gives the same answer each time.
+			if _, has := rsm.calls[line.Call.key()]; !has {
+				rsm.calls[line.Call.key()] = line.Call
+			}
+		case line.Kind == "event" && line.Event != nil:
+			rsm.events = append(rsm.events, line.Event)
+		default:
+			return nil, fmt.Errorf("bad solve record: unknown or empty line of kind %q", line.Kind)
+		}
+	}
+
+	if k == 0 {
+		return nil, errors.New("bad solve record: record is empty")
+	}
+	return rsm, nil
+}
+
+// Params returns the SolveParameters for the recorded solve, as best they can
+// be reconstructed. The Observer is set, so that the ReplaySourceManager can
+// follow the solver's decisions, and prefetching is disabled, as it would
+// gain nothing. If the record contains no parameters, only those two are set.
+//
+// The RootDir is returned as recorded, though its contents are never read.
+// Prepare requires that it exist, so when replaying elsewhere, replace it with
+// any directory that does.
+func (rsm *ReplaySourceManager) Params() SolveParameters {
+	params := rsm.params
+	params.Observer = eventEncoder(rsm.compare)
+	params.MaxPrefetch = -1
+	return params
+}
+
+// Divergence returns an error describing the first point at which the replay
+// departed from the record, or nil if it hasn't. That happens when the solver
+// makes a different decision than the recorded one, or calls the
+// SourceManager in a way it didn't when the record was made.
+func (rsm *ReplaySourceManager) Divergence() error {
+	rsm.mu.Lock()
+	defer rsm.mu.Unlock()
+	return rsm.div
+}
+
+func (rsm *ReplaySourceManager) diverge(err error) {
+	if rsm.div == nil {
+		rsm.div = err
+	}
+}
+
+// compare checks a solver event against the next one in the record.
+func (rsm *ReplaySourceManager) compare(je *jsonEvent) {
+	rsm.mu.Lock()
+	defer rsm.mu.Unlock()
+	if rsm.div != nil || len(rsm.events) == 0 {
+		// With no decisions recorded, there's nothing to compare against.
+		return
+	}
+
+	got, _ := json.Marshal(je)
+	if rsm.next >= len(rsm.events) {
+		rsm.diverge(fmt.Errorf("replay diverged from the record after its last decision, with %s", got))
+		return
+	}
+
+	want, _ := json.Marshal(rsm.events[rsm.next])
+	if string(got) != string(want) {
+		rsm.diverge(fmt.Errorf("replay diverged from the record at decision %d: recorded %s, but got %s", rsm.next+1, want, got))
+	}
+	rsm.next++
+}
+
+// lookup finds the recorded result of a call.
+func (rsm *ReplaySourceManager) lookup(c *jsonCall) (*jsonCall, error) {
+	if rc, has := rsm.calls[c.key()]; has {
+		if rc.Err != "" {
+			return rc, errors.New(rc.Err)
+		}
+		return rc, nil
+	}
+
+	err := fmt.Errorf("no result for %s was recorded", c.key())
+	rsm.mu.Lock()
+	rsm.diverge(fmt.Errorf("replay diverged from the record: %s", err))
+	rsm.mu.Unlock()
+	return nil, err
+}
+
+// SourceExists returns the recorded result.
+func (rsm *ReplaySourceManager) SourceExists(id ProjectIdentifier) (bool, error) {
+	rc, err := rsm.lookup(&jsonCall{Method: "SourceExists", Ident: toJSONIdent(id)})
+	if rc == nil {
+		return false, err
+	}
+	return rc.OK, err
+}
+
+// SyncSourceFor returns the recorded result.
+func (rsm *ReplaySourceManager) SyncSourceFor(id ProjectIdentifier) error {
+	_, err := rsm.lookup(&jsonCall{Method: "SyncSourceFor", Ident: toJSONIdent(id)})
+	return err
+}
+
+// ListVersions returns the recorded result.
+func (rsm *ReplaySourceManager) ListVersions(id ProjectIdentifier) ([]PairedVersion, error) {
+	rc, err := rsm.lookup(&jsonCall{Method: "ListVersions", Ident: toJSONIdent(id)})
+	if err != nil {
+		return nil, err
+	}
+
+	pvl := make([]PairedVersion, 0, len(rc.Versions))
+	for _, jv := range rc.Versions {
+		v, err := jv.version()
+		if err != nil {
+			return nil, err
+		}
+		pv, ok := v.(PairedVersion)
+		if !ok {
+			return nil, fmt.Errorf("recorded version %s of %s has no revision", v, id.errString())
+		}
+		pvl = append(pvl, pv)
+	}
+	return pvl, nil
+}
+
+// RevisionPresentIn returns the recorded result.
+func (rsm *ReplaySourceManager) RevisionPresentIn(id ProjectIdentifier, r Revision) (bool, error) {
+	rc, err := rsm.lookup(&jsonCall{Method: "RevisionPresentIn", Ident: toJSONIdent(id), Version: toJSONVersion(r)})
+	if rc == nil {
+		return false, err
+	}
+	return rc.OK, err
+}
+
+// ListPackages returns the recorded result.
+func (rsm *ReplaySourceManager) ListPackages(id ProjectIdentifier, v Version) (pkgtree.PackageTree, error) {
+	rc, err := rsm.lookup(&jsonCall{Method: "ListPackages", Ident: toJSONIdent(id), Version: toJSONVersion(v)})
+	if err != nil {
+		return pkgtree.PackageTree{}, err
+	}
+	return rc.Tree.packageTree(), nil
+}
+
+// GetManifestAndLock returns the recorded result. The ProjectAnalyzer is not
+// used.
+func (rsm *ReplaySourceManager) GetManifestAndLock(id ProjectIdentifier, v Version, an ProjectAnalyzer) (Manifest, Lock, error) {
+	rc, err := rsm.lookup(&jsonCall{Method: "GetManifestAndLock", Ident: toJSONIdent(id), Version: toJSONVersion(v)})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var m Manifest
+	if rc.Manifest != nil {
+		if m, err = rc.Manifest.manifest(); err != nil {
+			return nil, nil, err
+		}
+	}
+	l, err := rc.Lock.lock()
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, l, nil
+}
+
+// DeduceProjectRoot returns the recorded result.
+func (rsm *ReplaySourceManager) DeduceProjectRoot(ip string) (ProjectRoot, error) {
+	rc, err := rsm.lookup(&jsonCall{Method: "DeduceProjectRoot", Path: ip})
+	if rc == nil {
+		return "", err
+	}
+	return rc.Root, err
+}
+
+// ExportProject always fails; a solve record doesn't include any code.
+func (rsm *ReplaySourceManager) ExportProject(id ProjectIdentifier, v Version, to string) error {
+	return errors.New("projects cannot be exported from a solve record")
+}
+
+// Release does nothing.
+func (rsm *ReplaySourceManager) Release() {}
@@ -0,0 +1,224 @@
+package gps
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+// useRealBridge swaps in the standard bridge for the rest of a test. The
+// depspecBridge expects to talk directly to the depspecSourceManager, which
+// isn't the case while recording or replaying.
+func useRealBridge() {
+	mkBridge = func(s *solver, sm SourceManager, down bool) sourceBridge {
+		return &bridge{
+			sm:     sm,
+			s:      s,
+			down:   down,
+			vlists: make(map[ProjectIdentifier][]Version),
+		}
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	useRealBridge()
+	defer overrideMkBridge()
+
+	// The standard bridge relies on revisions to tell versions apart, so
+	// each version has its own.
+	ds := []depspec{
+		mkDepspec("root 0.0.0", "a *", "b *"),
+		mkDepspec("a 1.0.0 a1", "c 1.0.0"),
+		mkDepspec("a 2.0.0 a2", "c 2.0.0"),
+		mkDepspec("b 1.0.0 b1", "c 1.0.0"),
+		mkDepspec("b 2.0.0 b2", "c 1.0.0"),
+		mkDepspec("c 1.0.0 c1"),
+		mkDepspec("c 2.0.0 c2"),
+	}
+	fix := basicFixture{
+		ds: ds,
+		r: mksolution(
+			"a 1.0.0 a1",
+			"b 2.0.0 b2",
+			"c 1.0.0 c1",
+		),
+	}
+	sm := newdepspecSM(ds, nil)
+
+	var rec bytes.Buffer
+	params := SolveParameters{
+		RootDir:         ".",
+		RootPackageTree: fix.rootTree(),
+		Manifest:        fix.rootmanifest(),
+		ProjectAnalyzer: naiveAnalyzer{},
+		TraceRecord:     &rec,
+	}
+	s, err := Prepare(params, sm)
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+	soln, err := s.Solve()
+	fixtureSolveSimpleChecks(fix, soln, err, t)
+	if err != nil {
+		t.FailNow()
+	}
+
+	rsm, err := NewReplaySourceManager(bytes.NewReader(rec.Bytes()))
+	if err != nil {
+		t.Fatalf("Unexpected error reading solve record: %s", err)
+	}
+	rparams := rsm.Params()
+	rparams.Trace = true
+	rparams.TraceLogger = log.New(testlogger{T: t}, "", 0)
+	s, err = Prepare(rparams, rsm)
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing replay: %s", err)
+	}
+	if !bytes.Equal(s.HashInputs(), soln.InputHash()) {
+		t.Error("Replayed inputs did not hash the same as the recorded ones")
+	}
+	rsoln, err := s.Solve()
+	if err != nil {
+		t.Fatalf("Unexpected error while replaying: %s", err)
+	}
+	if err := rsm.Divergence(); err != nil {
+		t.Errorf("Replay should not have diverged, but: %s", err)
+	}
+	if !LocksAreEq(soln, rsoln, true) {
+		t.Errorf("Replayed solution differs from the recorded one:\n\t(GOT): %v\n\t(WNT): %v", rsoln.Projects(), soln.Projects())
+	}
+	if soln.Attempts() != rsoln.Attempts() {
+		t.Errorf("Replay took %v attempts, but the recorded solve took %v", rsoln.Attempts(), soln.Attempts())
+	}
+
+	// A change in parameters makes the solver take a different path, which
+	// the replay catches.
+	rsm, _ = NewReplaySourceManager(bytes.NewReader(rec.Bytes()))
+	rparams = rsm.Params()
+	rparams.Downgrade = true
+	s, err = Prepare(rparams, rsm)
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing replay: %s", err)
+	}
+	s.Solve()
+	if err := rsm.Divergence(); err == nil {
+		t.Error("Replay with different parameters should have diverged")
+	} else if !strings.Contains(err.Error(), "diverged") {
+		t.Errorf("Unexpected divergence error: %s", err)
+	}
+
+	badrec := `{"kind":"event","event":{"event":"note"}}
+{"kind":"params","params":{"root_dir":"."}}`
+	if _, err = NewReplaySourceManager(strings.NewReader(badrec)); err == nil {
+		t.Error("A solve record with parameters after the first line should have been rejected")
+	}
+}
+
+func TestJSONVersionRoundTrip(t *testing.T) {
+	rev := Revision("abc123")
+	versions := []Version{
+		rev,
+		NewBranch("master"),
+		newDefaultBranch("master").Is(rev),
+		NewVersion("v1.2.0"),
+		NewVersion("1.2.0").Is(rev),
+		NewVersion("foo"),
+		NewVersion("foo").Is(rev),
+	}
+	for _, v := range versions {
+		got, err := toJSONVersion(v).version()
+		if err != nil {
+			t.Errorf("Unexpected error decoding %s: %s", v, err)
+		} else if got.typedString() != v.typedString() || got.String() != v.String() {
+			t.Errorf("%s did not survive a round trip, got %s", v.typedString(), got.typedString())
+		}
+	}
+
+	constraints := []Constraint{
+		Any(),
+		none,
+		mkSVC("^1.2.0"),
+		mkSVC(">=1.0.0, <3.0.0"),
+		NewBranch("master"),
+	}
+	for _, c := range constraints {
+		got, err := toJSONConstraint(c).constraint()
+		if err != nil {
+			t.Errorf("Unexpected error decoding %s: %s", c, err)
+		} else if got.typedString() != c.typedString() {
+			t.Errorf("%s did not survive a round trip, got %s", c.typedString(), got.typedString())
+		}
+	}
+}
+
+func TestRecordingSourceManager(t *testing.T) {
+	useRealBridge()
+	defer overrideMkBridge()
+
+	ds := []depspec{
+		mkDepspec("root 0.0.0", "a *"),
+		mkDepspec("a 1.0.0 a1", "b 1.0.0"),
+		mkDepspec("a 2.0.0 a2", "b 2.0.0"),
+		mkDepspec("b 1.0.0 b1"),
+		mkDepspec("b 2.0.0 b2"),
+	}
+	fix := basicFixture{
+		ds: ds,
+		r: mksolution(
+			"a 2.0.0 a2",
+			"b 2.0.0 b2",
+		),
+	}
+
+	var rec bytes.Buffer
+	sm := NewRecordingSourceManager(newdepspecSM(ds, nil), &rec)
+	params := SolveParameters{
+		RootDir:         ".",
+		RootPackageTree: fix.rootTree(),
+		Manifest:        fix.rootmanifest(),
+		ProjectAnalyzer: naiveAnalyzer{},
+		MaxPrefetch:     -1,
+	}
+
+	solve := func(sm SourceManager) (Solution, error) {
+		s, err := Prepare(params, sm)
+		if err != nil {
+			t.Fatalf("Unexpected error while preparing solver: %s", err)
+		}
+		return s.Solve()
+	}
+
+	soln, err := solve(sm)
+	fixtureSolveSimpleChecks(fix, soln, err, t)
+
+	// Solving again adds nothing to the record.
+	l := rec.Len()
+	solve(sm)
+	if rec.Len() != l {
+		t.Error("Repeating calls should not have added to the record")
+	}
+
+	// The replay answers the same calls with the same results, and can do so
+	// any number of times.
+	rsm, err := NewReplaySourceManager(bytes.NewReader(rec.Bytes()))
+	if err != nil {
+		t.Fatalf("Unexpected error reading record: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		soln, err = solve(rsm)
+		fixtureSolveSimpleChecks(fix, soln, err, t)
+	}
+	if err := rsm.Divergence(); err != nil {
+		t.Errorf("Replay should not have diverged, but: %s", err)
+	}
+
+	// Downgrading needs information about versions that were never fetched.
+	// The solver may find its way around the missing results, but the replay
+	// still reports them.
+	params.Downgrade = true
+	solve(rsm)
+	if err := rsm.Divergence(); err == nil || !strings.Contains(err.Error(), "no result for ListPackages a") {
+		t.Errorf("Expected the replay to have diverged for lack of a recorded result, got %v", err)
+	}
+}
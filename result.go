@@ -11,6 +11,23 @@ import (
 type Solution interface {
 	Lock
 	Attempts() int
+
+	// ForcedChanges reports the projects in the root lock that the solution
+	// could not keep at their locked versions, sorted by project root. It is
+	// only populated when solving with the MinimalLockChurn strategy.
+	ForcedChanges() []ForcedChange
+
+	// Metrics reports on the work the solver did to find the solution.
+	Metrics() SolveMetrics
+
+	// Graph returns the graph of dependencies among the projects in the
+	// solution, as the solver found them.
+	Graph() *DepGraph
+
+	// Why returns the paths by which the root project comes to depend on the
+	// given project, along with the constraints on each dependency in them.
+	// See DepGraph.Why.
+	Why(ProjectRoot) []DepPath
 }
 
 type solution struct {
@@ -22,6 +39,18 @@ type solution struct {
 
 	// The hash digest of the input opts
 	hd []byte
+
+	// The locked projects that had to change, if minimal churn was requested
+	fc []ForcedChange
+
+	// What a later solve run needs to warm-start from this solution
+	ws *warmStart
+
+	// The metrics from the solving run that found this solution
+	mtr SolveMetrics
+
+	// The dependencies among the selected projects
+	g *DepGraph
 }
 
 // WriteDepTree takes a basedir and a Lock, and exports all the projects
@@ -72,3 +101,19 @@ func (r solution) Attempts() int {
 func (r solution) InputHash() []byte {
 	return r.hd
 }
+
+func (r solution) ForcedChanges() []ForcedChange {
+	return r.fc
+}
+
+func (r solution) Metrics() SolveMetrics {
+	return r.mtr
+}
+
+func (r solution) Graph() *DepGraph {
+	return r.g
+}
+
+func (r solution) Why(pr ProjectRoot) []DepPath {
+	return r.g.Why(pr)
+}
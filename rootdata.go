@@ -1,7 +1,9 @@
 package gps
 
 import (
+	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/armon/go-radix"
 	"github.com/sdboyer/gps/internal"
@@ -43,37 +45,82 @@ type rootdata struct {
 	// A defensively copied instance of params.RootPackageTree
 	rpt pkgtree.PackageTree
 
+	// The packages within the root project to which the solve is scoped, as
+	// selected by params.RootPackages. Nil if the solve covers all of them.
+	scope map[string]bool
+
 	// The ProjectAnalyzer to use for all GetManifestAndLock calls.
 	an ProjectAnalyzer
+
+	// The strategy to use when choosing versions.
+	strat SolveStrategy
+
+	// A map of the projects whose versions should be tried in a particular
+	// order, regardless of the general up/downgrade setting.
+	ord map[ProjectRoot]VersionOrder
+
+	// Whether to prefer older versions, where the version order isn't
+	// otherwise specified.
+	down bool
+
+	// The dependencies whose test imports and constraints are incorporated,
+	// and whether that's all of them.
+	tests   map[ProjectRoot]bool
+	testall bool
+
+	// The versions excluded by the root manifests, keyed by project root.
+	excl map[ProjectRoot]VersionExclusion
+
+	// The additional roots in a workspace solve, sorted by import root.
+	ws []wsroot
+
+	// Map of packages to ignore within dependencies. Outside of a workspace
+	// solve, this is the same as ig.
+	dig map[string]bool
 }
 
 // externalImportList returns a list of the unique imports from the root data.
 // Ignores and requires are taken into consideration, stdlib is excluded, and
 // errors within the local set of package are not backpropagated.
 func (rd rootdata) externalImportList() []string {
-	rm, _ := rd.rpt.ToReachMap(true, true, false, rd.ig)
+	return rd.externalImportListOf(rd.main())
+}
+
+// externalImportListOf returns a list of the unique imports from one of the
+// roots. Imports of packages within any of the other roots are excluded.
+func (rd rootdata) externalImportListOf(r wsroot) []string {
+	rm, _ := r.rpt.ToReachMap(true, true, false, r.ig)
+	if r.scope != nil {
+		// Only the packages in scope are starting points for reach; the
+		// reach map already covers anything else they import.
+		for pkg := range rm {
+			if !r.scope[pkg] {
+				delete(rm, pkg)
+			}
+		}
+	}
 	all := rm.Flatten(false)
 	reach := make([]string, 0, len(all))
-	for _, r := range all {
-		if !internal.IsStdLib(r) {
-			reach = append(reach, r)
+	for _, im := range all {
+		if !internal.IsStdLib(im) && !rd.inWorkspace(im) {
+			reach = append(reach, im)
 		}
 	}
 
 	// If there are any requires, slide them into the reach list, as well.
-	if len(rd.req) > 0 {
+	if len(r.req) > 0 {
 		// Make a map of imports that are both in the import path list and the
 		// required list to avoid duplication.
-		skip := make(map[string]bool, len(rd.req))
-		for _, r := range reach {
-			if rd.req[r] {
-				skip[r] = true
+		skip := make(map[string]bool, len(r.req))
+		for _, im := range reach {
+			if r.req[im] {
+				skip[im] = true
 			}
 		}
 
-		for r := range rd.req {
-			if !skip[r] {
-				reach = append(reach, r)
+		for im := range r.req {
+			if !skip[im] {
+				reach = append(reach, im)
 			}
 		}
 	}
@@ -83,8 +130,12 @@ func (rd rootdata) externalImportList() []string {
 }
 
 func (rd rootdata) getApplicableConstraints() []workingConstraint {
+	return rd.applicableConstraintsOf(rd.main())
+}
+
+func (rd rootdata) applicableConstraintsOf(r wsroot) []workingConstraint {
 	// Merge the normal and test constraints together
-	pc := rd.rm.DependencyConstraints().merge(rd.rm.TestDependencyConstraints())
+	pc := r.rm.DependencyConstraints().merge(r.rm.TestDependencyConstraints())
 
 	// Ensure that overrides which aren't in the combined pc map already make it
 	// in. Doing so makes input hashes equal in more useful cases.
@@ -116,7 +167,7 @@ func (rd rootdata) getApplicableConstraints() []workingConstraint {
 
 	// Walk all dep import paths we have to consider and mark the corresponding
 	// wc entry in the trie, if any
-	for _, im := range rd.externalImportList() {
+	for _, im := range rd.externalImportListOf(r) {
 		if internal.IsStdLib(im) {
 			continue
 		}
@@ -142,7 +193,11 @@ func (rd rootdata) getApplicableConstraints() []workingConstraint {
 }
 
 func (rd rootdata) combineConstraints() []workingConstraint {
-	return rd.ovr.overrideAll(rd.rm.DependencyConstraints().merge(rd.rm.TestDependencyConstraints()))
+	return rd.combineConstraintsOf(rd.main())
+}
+
+func (rd rootdata) combineConstraintsOf(r wsroot) []workingConstraint {
+	return rd.ovr.overrideAll(r.rm.DependencyConstraints().merge(r.rm.TestDependencyConstraints()))
 }
 
 // needVersionListFor indicates whether we need a version list for a given
@@ -176,15 +231,34 @@ func (rd rootdata) needVersionsFor(pr ProjectRoot) bool {
 
 }
 
+// wantTests indicates whether the test imports and constraints of the given
+// dependency should be incorporated.
+func (rd rootdata) wantTests(pr ProjectRoot) bool {
+	return rd.testall || rd.tests[pr]
+}
+
 func (rd rootdata) isRoot(pr ProjectRoot) bool {
-	return pr == ProjectRoot(rd.rpt.ImportRoot)
+	if pr == ProjectRoot(rd.rpt.ImportRoot) {
+		return true
+	}
+	for _, r := range rd.ws {
+		if pr == ProjectRoot(r.rpt.ImportRoot) {
+			return true
+		}
+	}
+	return false
 }
 
 // rootAtom creates an atomWithPackages that represents the root project.
 func (rd rootdata) rootAtom() atomWithPackages {
+	return rootAtomOf(rd.main())
+}
+
+// rootAtomOf creates an atomWithPackages that represents one of the roots.
+func rootAtomOf(r wsroot) atomWithPackages {
 	a := atom{
 		id: ProjectIdentifier{
-			ProjectRoot: ProjectRoot(rd.rpt.ImportRoot),
+			ProjectRoot: ProjectRoot(r.rpt.ImportRoot),
 		},
 		// This is a hack so that the root project doesn't have a nil version.
 		// It's sort of OK because the root never makes it out into the results.
@@ -193,9 +267,9 @@ func (rd rootdata) rootAtom() atomWithPackages {
 		v: rootRev,
 	}
 
-	list := make([]string, 0, len(rd.rpt.Packages))
-	for path, pkg := range rd.rpt.Packages {
-		if pkg.Err != nil && !rd.ig[path] {
+	list := make([]string, 0, len(r.rpt.Packages))
+	for path, pkg := range r.rpt.Packages {
+		if pkg.Err != nil && !r.ig[path] && (r.scope == nil || r.scope[path]) {
 			list = append(list, path)
 		}
 	}
@@ -206,3 +280,41 @@ func (rd rootdata) rootAtom() atomWithPackages {
 		pl: list,
 	}
 }
+
+// scopeRootPackages resolves the patterns given in params.RootPackages into
+// the set of packages in the root project that they select. Ignored packages
+// are never selected.
+//
+// Patterns are import paths, or paths relative to the import root if they
+// begin with "./". A trailing "/..." matches the named package, along with all
+// the packages beneath it.
+func scopeRootPackages(ptree pkgtree.PackageTree, patterns []string, ig map[string]bool) (map[string]bool, error) {
+	scope := make(map[string]bool)
+	for _, pat := range patterns {
+		ip := pat
+		switch {
+		case ip == "." || ip == "./...":
+			ip = ptree.ImportRoot + strings.TrimPrefix(ip, ".")
+		case strings.HasPrefix(ip, "./"):
+			ip = ptree.ImportRoot + ip[1:]
+		}
+
+		var matched bool
+		base, wild := strings.TrimSuffix(ip, "/..."), strings.HasSuffix(ip, "/...")
+		for pkg := range ptree.Packages {
+			if ig[pkg] {
+				continue
+			}
+			if pkg == base || (wild && inRoot(base, pkg)) {
+				scope[pkg] = true
+				matched = true
+			}
+		}
+
+		if !matched {
+			return nil, badOptsFailure(fmt.Sprintf("root package pattern %q matches no packages in %s", pat, ptree.ImportRoot))
+		}
+	}
+
+	return scope, nil
+}
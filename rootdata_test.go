@@ -2,6 +2,7 @@ package gps
 
 import (
 	"reflect"
+	"sort"
 	"testing"
 )
 
@@ -214,3 +215,84 @@ func TestGetApplicableConstraints(t *testing.T) {
 		})
 	}
 }
+
+func TestScopeRootPackages(t *testing.T) {
+	fix := bimodalFixture{
+		ds: []depspec{
+			dsp(mkDepspec("root 0.0.0"),
+				pkg("root", "a"),
+				pkg("root/cmd/agent", "root/lib"),
+				pkg("root/cmd/agent/sub"),
+				pkg("root/cmd/agentx"),
+				pkg("root/lib", "b"),
+			),
+		},
+	}
+	ptree := fix.rootTree()
+
+	table := []struct {
+		pats []string
+		ig   map[string]bool
+		want []string
+	}{
+		{
+			pats: []string{"./cmd/agent"},
+			want: []string{"root/cmd/agent"},
+		},
+		{
+			pats: []string{"./cmd/agent/..."},
+			want: []string{"root/cmd/agent", "root/cmd/agent/sub"},
+		},
+		{
+			pats: []string{"root/cmd/agent/...", "."},
+			want: []string{"root", "root/cmd/agent", "root/cmd/agent/sub"},
+		},
+		{
+			pats: []string{"./..."},
+			ig:   map[string]bool{"root/lib": true},
+			want: []string{"root", "root/cmd/agent", "root/cmd/agent/sub", "root/cmd/agentx"},
+		},
+	}
+
+	for _, fix := range table {
+		scope, err := scopeRootPackages(ptree, fix.pats, fix.ig)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", fix.pats, err)
+			continue
+		}
+		var got []string
+		for pkg := range scope {
+			got = append(got, pkg)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(fix.want, got) {
+			t.Errorf("Unexpected packages selected by %q:\n\t(GOT): %s\n\t(WNT): %s", fix.pats, got, fix.want)
+		}
+	}
+
+	// Patterns must match something, and may not reach outside the root.
+	for _, pat := range []string{"./cmd/nope/...", "other/..."} {
+		if _, err := scopeRootPackages(ptree, []string{pat}, nil); err == nil {
+			t.Errorf("Expected an error for pattern %q, which matches no packages", pat)
+		}
+	}
+
+	params := SolveParameters{
+		RootDir:         string(fix.ds[0].n),
+		RootPackageTree: ptree,
+		Manifest:        fix.rootmanifest(),
+		ProjectAnalyzer: naiveAnalyzer{},
+		RootPackages:    []string{"./cmd/agent/..."},
+	}
+	is, err := Prepare(params, newdepspecSM(fix.ds, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error while prepping solver: %s", err)
+	}
+
+	// Only b is reached from cmd/agent, through root/lib.
+	want := []string{"b"}
+	got := is.(*solver).rd.externalImportList()
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Unexpected return from rootdata.externalImportList:\n\t(GOT): %s\n\t(WNT): %s", got, want)
+	}
+}
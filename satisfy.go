@@ -19,14 +19,14 @@ func (s *solver) check(a atomWithPackages, pkgonly bool) error {
 	// so we can skip the checkAtomAllowable step.
 	if !pkgonly {
 		if err := s.checkAtomAllowable(pa); err != nil {
-			s.traceInfo(err)
+			s.traceFailure(pa, err)
 			s.mtr.pop()
 			return err
 		}
 	}
 
 	if err := s.checkRequiredPackagesExist(a); err != nil {
-		s.traceInfo(err)
+		s.traceFailure(pa, err)
 		s.mtr.pop()
 		return err
 	}
@@ -45,26 +45,31 @@ func (s *solver) check(a atomWithPackages, pkgonly bool) error {
 	// analysis.
 	for _, dep := range deps {
 		if err := s.checkIdentMatches(a, dep); err != nil {
-			s.traceInfo(err)
+			s.traceFailure(pa, err)
+			s.mtr.pop()
+			return err
+		}
+		if err := s.checkPolicySource(a.a, dep); err != nil {
+			s.traceFailure(pa, err)
 			s.mtr.pop()
 			return err
 		}
 		if err := s.checkDepsConstraintsAllowable(a, dep); err != nil {
-			s.traceInfo(err)
+			s.traceFailure(pa, err)
 			s.mtr.pop()
 			return err
 		}
 		if err := s.checkDepsDisallowsSelected(a, dep); err != nil {
-			s.traceInfo(err)
+			s.traceFailure(pa, err)
 			s.mtr.pop()
 			return err
 		}
 		if err := s.checkRevisionExists(a, dep); err != nil {
-			s.traceInfo(err)
+			s.traceFailure(pa, err)
 			return err
 		}
 		if err := s.checkPackageImportsFromDepExist(a, dep); err != nil {
-			s.traceInfo(err)
+			s.traceFailure(pa, err)
 			s.mtr.pop()
 			return err
 		}
@@ -81,7 +86,7 @@ func (s *solver) check(a atomWithPackages, pkgonly bool) error {
 func (s *solver) checkAtomAllowable(pa atom) error {
 	constraint := s.sel.getConstraint(pa.id)
 	if s.vUnify.matches(pa.id, constraint, pa.v) {
-		return nil
+		return s.checkPolicyVersion(pa)
 	}
 	// TODO(sdboyer) collect constraint failure reason (wait...aren't we, below?)
 
@@ -94,7 +99,7 @@ func (s *solver) checkAtomAllowable(pa atom) error {
 		}
 	}
 
-	err := &versionNotAllowedFailure{
+	err := &VersionNotAllowedFailure{
 		goal:       pa,
 		failparent: failparent,
 		c:          constraint,
@@ -135,7 +140,7 @@ func (s *solver) checkRequiredPackagesExist(a atomWithPackages) error {
 	}
 
 	if len(fp) > 0 {
-		return &checkeeHasProblemPackagesFailure{
+		return &CheckeeHasProblemPackagesFailure{
 			goal:    a.a,
 			failpkg: fp,
 		}
@@ -167,7 +172,7 @@ func (s *solver) checkDepsConstraintsAllowable(a atomWithPackages, cdep complete
 		}
 	}
 
-	return &disjointConstraintFailure{
+	return &DisjointConstraintFailure{
 		goal:      dependency{depender: a.a, dep: cdep},
 		failsib:   failsib,
 		nofailsib: nofailsib,
@@ -184,7 +189,7 @@ func (s *solver) checkDepsDisallowsSelected(a atomWithPackages, cdep completeDep
 	if exists && !s.vUnify.matches(dep.Ident, dep.Constraint, selected.a.v) {
 		s.fail(dep.Ident)
 
-		return &constraintNotAllowedFailure{
+		return &ConstraintNotAllowedFailure{
 			goal: dependency{depender: a.a, dep: cdep},
 			v:    selected.a.v,
 		}
@@ -209,7 +214,7 @@ func (s *solver) checkIdentMatches(a atomWithPackages, cdep completeDep) error {
 			s.fail(d.depender.id)
 		}
 
-		return &sourceMismatchFailure{
+		return &SourceMismatchFailure{
 			shared:   dep.Ident.ProjectRoot,
 			sel:      deps,
 			current:  curid.normalizedSource(),
@@ -236,7 +241,7 @@ func (s *solver) checkPackageImportsFromDepExist(a atomWithPackages, cdep comple
 		return err
 	}
 
-	e := &depHasProblemPackagesFailure{
+	e := &DepHasProblemPackagesFailure{
 		goal: dependency{
 			depender: a.a,
 			dep:      cdep,
@@ -276,7 +281,7 @@ func (s *solver) checkRevisionExists(a atomWithPackages, cdep completeDep) error
 		return nil
 	}
 
-	return &nonexistentRevisionFailure{
+	return &NonexistentRevisionFailure{
 		goal: dependency{
 			depender: a.a,
 			dep:      cdep,
@@ -0,0 +1,322 @@
+package gps
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A SearchTree is a SolveObserver that builds the tree of selections the
+// solver explored, for debugging solves that take a long time to find an
+// answer, or to fail.
+//
+// Each node in the tree is the selection of a version of a project, or of
+// additional packages from an already-selected project; its children are the
+// selections that were made while it was in place. Versions that failed the
+// solver's checks are included as leaves, marked with the reason they failed.
+// When backtracking undoes a selection, its node is marked with the failure
+// that began the backtracking.
+//
+// To build the tree, pass the SearchTree as SolveParameters.Observer, or as
+// part of one. It must not be read while the solver is running. If it observes
+// more than one solve run, it holds only the most recent.
+type SearchTree struct {
+	root  *SearchNode
+	path  []*SearchNode
+	nodes int
+
+	// Whether the last event was the selection of a root
+	inRoots bool
+	// The number of roots selected
+	nroots int
+	// The failure most recently reported by the solver
+	lastFail string
+	// The failure that began the current backtrack
+	cause string
+}
+
+// SearchNode is a node in a SearchTree.
+type SearchNode struct {
+	// ID identifies the node. IDs are assigned in the order nodes are
+	// created, starting from zero.
+	ID int
+	// Ident identifies the project, and Version the version selected or
+	// tried. Root projects have no Version.
+	Ident   ProjectIdentifier
+	Version Version
+	// Packages lists the packages selected, or that would have been.
+	Packages []string
+	// Root indicates that the node is a root project.
+	Root bool
+	// PkgOnly indicates that the project was already selected, and only the
+	// Packages were added to it.
+	PkgOnly bool
+	// Failure is set if the version failed the solver's checks, and was never
+	// selected. It describes the reason why.
+	Failure string
+	// Backtrack is set if the selection was undone by backtracking. It
+	// describes the failure that began the backtracking; that is empty when
+	// the solver backtracked in search of another solution, rather than
+	// because of a failure.
+	Backtrack string
+	// Solution indicates that the selection is part of a solution that was
+	// found.
+	Solution bool
+	// Children lists the selections made, and the versions tried, while this
+	// one was in place, in the order they happened.
+	Children []*SearchNode
+
+	undone bool
+}
+
+var _ SolveObserver = &SearchTree{}
+
+// NewSearchTree creates an empty SearchTree.
+func NewSearchTree() *SearchTree {
+	return &SearchTree{}
+}
+
+// Root returns the root of the tree, which is the first root project selected.
+// It returns nil if no solve run has been observed.
+func (t *SearchTree) Root() *SearchNode {
+	return t.root
+}
+
+func (t *SearchTree) newNode(n *SearchNode) *SearchNode {
+	n.ID = t.nodes
+	t.nodes++
+	return n
+}
+
+// moveTo truncates the path through the tree to the given depth, marking
+// every selection removed from it as undone.
+func (t *SearchTree) moveTo(depth int) {
+	if depth >= len(t.path) {
+		return
+	}
+	if depth < t.nroots {
+		// Roots are never backtracked across.
+		depth = t.nroots
+	}
+
+	for _, n := range t.path[depth:] {
+		if !n.undone {
+			n.undone = true
+			n.Backtrack = t.cause
+		}
+	}
+	t.path = t.path[:depth]
+}
+
+// push adds a child to the node at the end of the path.
+func (t *SearchTree) push(n *SearchNode) {
+	if len(t.path) > 0 {
+		parent := t.path[len(t.path)-1]
+		parent.Children = append(parent.Children, n)
+	}
+	t.path = append(t.path, n)
+}
+
+// SelectRoot implements SolveObserver.
+func (t *SearchTree) SelectRoot(ev RootEvent) {
+	if !t.inRoots {
+		// This is the start of a new solve run.
+		*t = SearchTree{inRoots: true}
+	}
+
+	n := t.newNode(&SearchNode{
+		Ident: ProjectIdentifier{ProjectRoot: ProjectRoot(ev.ImportRoot)},
+		Root:  true,
+	})
+	if t.root == nil {
+		t.root = n
+	}
+	t.push(n)
+	t.nroots++
+}
+
+// Visit implements SolveObserver.
+func (t *SearchTree) Visit(ev VisitEvent) {
+	t.inRoots = false
+}
+
+// TryVersion implements SolveObserver.
+func (t *SearchTree) TryVersion(ev TryEvent) {
+	t.inRoots = false
+}
+
+// CheckFailed implements SolveObserver.
+func (t *SearchTree) CheckFailed(ev FailEvent) {
+	t.inRoots = false
+	if t.root == nil {
+		return
+	}
+
+	// Failures are reported one level below the selection that would have
+	// been made.
+	t.moveTo(ev.Depth - 1)
+	t.lastFail = strings.TrimSuffix(ev.Summary, "\n")
+	n := t.newNode(&SearchNode{
+		Ident:   ev.Ident,
+		Version: ev.Version,
+		Failure: strings.TrimSuffix(ev.Summary, "\n"),
+		undone:  true,
+	})
+	parent := t.path[len(t.path)-1]
+	parent.Children = append(parent.Children, n)
+}
+
+// Backtrack implements SolveObserver.
+func (t *SearchTree) Backtrack(ev BacktrackEvent) {
+	t.inRoots = false
+	if ev.Start {
+		t.cause = t.lastFail
+	}
+}
+
+// Select implements SolveObserver.
+func (t *SearchTree) Select(ev SelectEvent) {
+	t.inRoots = false
+	if t.root == nil {
+		return
+	}
+
+	t.moveTo(ev.Depth)
+	t.push(t.newNode(&SearchNode{
+		Ident:    ev.Ident,
+		Version:  ev.Version,
+		Packages: ev.Packages,
+		PkgOnly:  ev.PkgOnly,
+	}))
+}
+
+// Note implements SolveObserver.
+func (t *SearchTree) Note(ev NoteEvent) {
+	t.inRoots = false
+}
+
+// Finish implements SolveObserver.
+func (t *SearchTree) Finish(ev FinishEvent) {
+	t.inRoots = false
+	if ev.Err != nil {
+		// Everything that was selected has been undone.
+		t.moveTo(t.nroots)
+		return
+	}
+
+	for _, n := range t.path {
+		n.Solution = true
+	}
+	// If the solver goes on to look for another solution, it will be
+	// backtracking for no particular failure.
+	t.cause, t.lastFail = "", ""
+}
+
+// walk calls fn on each node in the tree, parents before their children.
+func (n *SearchNode) walk(fn func(n *SearchNode)) {
+	fn(n)
+	for _, c := range n.Children {
+		c.walk(fn)
+	}
+}
+
+func (n *SearchNode) label() string {
+	var buf bytes.Buffer
+	if n.Root {
+		fmt.Fprintf(&buf, "%s (root)", n.Ident.errString())
+	} else {
+		buf.WriteString(a2vs(atom{id: n.Ident, v: n.Version}))
+	}
+	if len(n.Packages) > 0 {
+		if n.PkgOnly {
+			fmt.Fprintf(&buf, "\n+%v pkgs", len(n.Packages))
+		} else {
+			fmt.Fprintf(&buf, "\n%v pkgs", len(n.Packages))
+		}
+	}
+	return buf.String()
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteDOT writes the tree as a Graphviz DOT digraph. Failed versions are drawn
+// in red, and selections that were part of a solution in bold. An edge into a
+// node that was backtracked across is labelled with the failure responsible.
+func (t *SearchTree) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph search {")
+	fmt.Fprintln(bw, "\tnode [shape=box];")
+
+	if t.root != nil {
+		t.root.walk(func(n *SearchNode) {
+			var attrs string
+			switch {
+			case n.Failure != "":
+				attrs = ", color=red, style=dashed"
+			case n.Solution:
+				attrs = ", style=bold"
+			}
+			fmt.Fprintf(bw, "\tn%d [label=\"%s\"%s];\n", n.ID, dotEscaper.Replace(n.label()), attrs)
+
+			for _, c := range n.Children {
+				switch {
+				case c.Failure != "":
+					fmt.Fprintf(bw, "\tn%d -> n%d [label=\"%s\", color=red];\n", n.ID, c.ID, dotEscaper.Replace(c.Failure))
+				case c.Backtrack != "":
+					fmt.Fprintf(bw, "\tn%d -> n%d [label=\"%s\"];\n", n.ID, c.ID, dotEscaper.Replace(c.Backtrack))
+				default:
+					fmt.Fprintf(bw, "\tn%d -> n%d;\n", n.ID, c.ID)
+				}
+			}
+		})
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// jsonSearchNode is the JSON form of a SearchNode.
+type jsonSearchNode struct {
+	ID        int               `json:"id"`
+	Ident     *jsonIdent        `json:"ident"`
+	Version   *jsonVersion      `json:"version,omitempty"`
+	Packages  []string          `json:"packages,omitempty"`
+	Root      bool              `json:"root,omitempty"`
+	PkgOnly   bool              `json:"pkg_only,omitempty"`
+	Failure   string            `json:"failure,omitempty"`
+	Backtrack string            `json:"backtrack,omitempty"`
+	Solution  bool              `json:"solution,omitempty"`
+	Children  []*jsonSearchNode `json:"children,omitempty"`
+}
+
+func toJSONSearchNode(n *SearchNode) *jsonSearchNode {
+	jn := &jsonSearchNode{
+		ID:        n.ID,
+		Ident:     toJSONIdent(n.Ident),
+		Version:   toJSONVersion(n.Version),
+		Packages:  n.Packages,
+		Root:      n.Root,
+		PkgOnly:   n.PkgOnly,
+		Failure:   n.Failure,
+		Backtrack: n.Backtrack,
+		Solution:  n.Solution,
+	}
+	for _, c := range n.Children {
+		jn.Children = append(jn.Children, toJSONSearchNode(c))
+	}
+	return jn
+}
+
+// WriteJSON writes the tree as a single JSON object, the root node, with each
+// node's children nested within it. Versions take the same form as in solve
+// records. If no solve run has been observed, null is written.
+func (t *SearchTree) WriteJSON(w io.Writer) error {
+	var jn *jsonSearchNode
+	if t.root != nil {
+		jn = toJSONSearchNode(t.root)
+	}
+	return json.NewEncoder(w).Encode(jn)
+}
@@ -1,14 +1,28 @@
 package gps
 
+import "container/heap"
+
 type selection struct {
 	projects []selected
 	deps     map[ProjectRoot][]dependency
 	vu       versionUnifier
+
+	// Indexes into projects, kept up to date as selections are pushed and
+	// popped, so that lookups don't have to walk the whole stack.
+	//
+	// The position in projects of the earliest selection for each project.
+	idx map[ProjectRoot]int
+	// The packages selected from each project, and the number of times each
+	// has been independently selected.
+	pkgs map[ProjectRoot]map[string]int
 }
 
 type selected struct {
 	a     atomWithPackages
 	first bool
+	// The dependencies introduced by the selection, kept so that it can be
+	// undone without going back to the SourceManager.
+	deps []completeDep
 }
 
 func (s *selection) getDependenciesOn(id ProjectIdentifier) []dependency {
@@ -37,22 +51,53 @@ func (s *selection) getIdentFor(pr ProjectRoot) (ProjectIdentifier, bool) {
 
 // pushSelection pushes a new atomWithPackages onto the selection stack, along
 // with an indicator as to whether this selection indicates a new project *and*
-// packages, or merely some new packages on a project that was already selected.
-func (s *selection) pushSelection(a atomWithPackages, pkgonly bool) {
+// packages, or merely some new packages on a project that was already selected,
+// and the dependencies the selection introduces.
+func (s *selection) pushSelection(a atomWithPackages, pkgonly bool, deps []completeDep) {
+	if s.idx == nil {
+		s.idx = make(map[ProjectRoot]int)
+		s.pkgs = make(map[ProjectRoot]map[string]int)
+	}
+
+	pr := a.a.id.ProjectRoot
+	if _, has := s.idx[pr]; !has {
+		s.idx[pr] = len(s.projects)
+		s.pkgs[pr] = make(map[string]int)
+	}
+	for _, pkg := range a.pl {
+		s.pkgs[pr][pkg]++
+	}
+
 	s.projects = append(s.projects, selected{
 		a:     a,
 		first: !pkgonly,
+		deps:  deps,
 	})
 }
 
 // popSelection removes and returns the last atomWithPackages from the selection
-// stack, along with an indication of whether that element was the first from
-// that project - that is, if it represented an addition of both a project and
-// one or more packages to the overall selection.
-func (s *selection) popSelection() (atomWithPackages, bool) {
+// stack, along with the dependencies it introduced, and an indication of
+// whether that element was the first from that project - that is, if it
+// represented an addition of both a project and one or more packages to the
+// overall selection.
+func (s *selection) popSelection() (atomWithPackages, []completeDep, bool) {
 	var sel selected
 	sel, s.projects = s.projects[len(s.projects)-1], s.projects[:len(s.projects)-1]
-	return sel.a, sel.first
+
+	pr := sel.a.a.id.ProjectRoot
+	if s.idx[pr] == len(s.projects) {
+		delete(s.idx, pr)
+		delete(s.pkgs, pr)
+	} else {
+		pm := s.pkgs[pr]
+		for _, pkg := range sel.a.pl {
+			if pm[pkg]--; pm[pkg] == 0 {
+				delete(pm, pkg)
+			}
+		}
+	}
+
+	return sel.a, sel.deps, sel.first
 }
 
 func (s *selection) pushDep(dep dependency) {
@@ -89,23 +134,16 @@ func (s *selection) getRequiredPackagesIn(id ProjectIdentifier) map[string]int {
 	return uniq
 }
 
-// Compute a list of the unique packages within the given ProjectIdentifier that
-// are currently selected, and the number of times each package has been
+// Returns the unique packages within the given ProjectIdentifier that are
+// currently selected, and the number of times each package has been
 // independently selected.
+//
+// The returned map belongs to the selection, and must not be modified.
 func (s *selection) getSelectedPackagesIn(id ProjectIdentifier) map[string]int {
-	// TODO(sdboyer) this is horribly inefficient to do on the fly; we need a method to
-	// precompute it on pushing a new dep, and preferably with an immut
-	// structure so that we can pop with zero cost.
-	uniq := make(map[string]int)
-	for _, p := range s.projects {
-		if p.a.a.id.eq(id) {
-			for _, pkg := range p.a.pl {
-				uniq[pkg] = uniq[pkg] + 1
-			}
-		}
+	if pm, has := s.pkgs[id.ProjectRoot]; has {
+		return pm
 	}
-
-	return uniq
+	return nil
 }
 
 func (s *selection) getConstraint(id ProjectIdentifier) Constraint {
@@ -133,15 +171,11 @@ func (s *selection) getConstraint(id ProjectIdentifier) Constraint {
 // selected checks to see if the given ProjectIdentifier has been selected, and
 // if so, returns the corresponding atomWithPackages.
 //
-// It walks the projects selection list from front to back and returns the first
-// match it finds, which means it will always and only return the base selection
-// of the project, without any additional package selections that may or may not
-// have happened later.
+// It always and only returns the base selection of the project, without any
+// additional package selections that may or may not have happened later.
 func (s *selection) selected(id ProjectIdentifier) (atomWithPackages, bool) {
-	for _, p := range s.projects {
-		if p.a.a.id.ProjectRoot == id.ProjectRoot {
-			return p.a, true
-		}
+	if k, has := s.idx[id.ProjectRoot]; has {
+		return s.projects[k].a, true
 	}
 
 	return atomWithPackages{a: nilpa}, false
@@ -181,8 +215,9 @@ func (u *unselected) Pop() (v interface{}) {
 // becomes unnecessary because the dependency that induced it was backtracked
 // and popped off.
 //
-// The worst case for both of these is O(n), but in practice the first case is
-// O(1), as we iterate the queue from front to back.
+// The worst case for finding the bmi in both of these is O(n), but in practice
+// the first case is O(1), as we iterate the queue from front to back. Taking
+// it out is O(log n), and leaves the rest of the queue in heap order.
 func (u *unselected) remove(bmi bimodalIdentifier) {
 	plen := len(bmi.pl)
 outer:
@@ -195,13 +230,23 @@ outer:
 				}
 			}
 
-			if i == len(u.sl)-1 {
-				// if we're on the last element, just pop, no splice
-				u.sl = u.sl[:len(u.sl)-1]
-			} else {
-				u.sl = append(u.sl[:i], u.sl[i+1:]...)
-			}
+			heap.Remove(u, i)
 			break
 		}
 	}
 }
+
+// take removes every bimodalIdentifier for the given project from the priority
+// queue, and returns them.
+func (u *unselected) take(id ProjectIdentifier) []bimodalIdentifier {
+	var taken []bimodalIdentifier
+	for _, bmi := range u.sl {
+		if bmi.id.eq(id) {
+			taken = append(taken, bmi)
+		}
+	}
+	for _, bmi := range taken {
+		u.remove(bmi)
+	}
+	return taken
+}
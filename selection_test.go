@@ -1,6 +1,7 @@
 package gps
 
 import (
+	"container/heap"
 	"reflect"
 	"testing"
 )
@@ -57,3 +58,71 @@ func TestUnselectedRemoval(t *testing.T) {
 		t.Fatalf("wrong item removed from slice:\n\t(GOT): %v\n\t(WNT): %v", u.sl, want)
 	}
 }
+
+func TestUnselectedTake(t *testing.T) {
+	u := &unselected{}
+	u.cmp = func(i, j int) bool {
+		return u.sl[i].id.less(u.sl[j].id)
+	}
+	for _, n := range []string{"d", "a", "foo", "c", "b", "e", "foo", "f"} {
+		heap.Push(u, bimodalIdentifier{id: mkPI(n), pl: []string{n}})
+	}
+
+	taken := u.take(mkPI("foo"))
+	if len(taken) != 2 {
+		t.Fatalf("Expected both bmis for foo to be taken, got %v", taken)
+	}
+
+	var got []string
+	for u.Len() > 0 {
+		got = append(got, string(heap.Pop(u).(bimodalIdentifier).id.ProjectRoot))
+	}
+	if want := []string{"a", "b", "c", "d", "e", "f"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Queue was not left in heap order:\n\t(GOT): %v\n\t(WNT): %v", got, want)
+	}
+}
+
+func TestSelectionIndexes(t *testing.T) {
+	s := &selection{
+		deps: make(map[ProjectRoot][]dependency),
+	}
+
+	foo1 := atom{id: mkPI("foo"), v: NewVersion("1.0.0")}
+	bar1 := atom{id: mkPI("bar"), v: NewVersion("1.0.0")}
+
+	s.pushSelection(atomWithPackages{a: foo1, pl: []string{"foo"}}, false, nil)
+	s.pushSelection(atomWithPackages{a: bar1, pl: []string{"bar"}}, false, nil)
+	s.pushSelection(atomWithPackages{a: foo1, pl: []string{"foo", "foo/sub"}}, true, nil)
+
+	awp, has := s.selected(foo1.id)
+	if !has {
+		t.Fatal("foo should be selected")
+	}
+	if !reflect.DeepEqual(awp.pl, []string{"foo"}) {
+		t.Errorf("selected should return the base selection of foo, got packages %v", awp.pl)
+	}
+
+	want := map[string]int{"foo": 2, "foo/sub": 1}
+	if got := s.getSelectedPackagesIn(foo1.id); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected selected packages in foo:\n\t(GOT): %v\n\t(WNT): %v", got, want)
+	}
+
+	s.popSelection()
+	want = map[string]int{"foo": 1}
+	if got := s.getSelectedPackagesIn(foo1.id); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected selected packages in foo after pop:\n\t(GOT): %v\n\t(WNT): %v", got, want)
+	}
+
+	s.popSelection()
+	if _, has := s.selected(bar1.id); has {
+		t.Error("bar should no longer be selected")
+	}
+	if got := s.getSelectedPackagesIn(bar1.id); len(got) != 0 {
+		t.Errorf("bar should have no selected packages, got %v", got)
+	}
+
+	s.popSelection()
+	if _, has := s.selected(foo1.id); has {
+		t.Error("foo should no longer be selected")
+	}
+}
@@ -0,0 +1,215 @@
+package gps
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A SolutionScorer assigns a score to a Solution, for ranking the solutions
+// found by Solver.Solutions. Higher scores rank first.
+type SolutionScorer func(Solution) float64
+
+// A SolutionIterator steps through the solutions found by Solver.Solutions.
+//
+// Its use mirrors that of bufio.Scanner:
+//
+//	it := s.Solutions(ctx, 5, nil)
+//	for it.Next() {
+//		soln := it.Solution()
+//		// ...
+//	}
+//	if err := it.Err(); err != nil {
+//		// ...
+//	}
+type SolutionIterator interface {
+	// Next advances the iterator to the next solution, which will then be
+	// available from Solution. It returns false when there are no more
+	// solutions, or when an error stops the iteration.
+	Next() bool
+
+	// Solution returns the solution most recently reached by Next.
+	Solution() Solution
+
+	// Err returns the error, if any, that stopped the iteration.
+	//
+	// Running out of solutions after at least one has been found is not an
+	// error. If no solution could be found at all, Err returns the same error
+	// that Solve would have. If the context was canceled, or a budget from
+	// SolveParameters was exceeded, it returns a *SolveCanceledError or
+	// *BudgetExceededError, even if some solutions were found first.
+	Err() error
+}
+
+type solutionIterator struct {
+	s     *solver
+	ctx   context.Context
+	n     int
+	score SolutionScorer
+
+	started, done bool
+	// The number of distinct solutions found so far.
+	found int
+	// Keys of the solutions found so far, so that duplicates are dropped.
+	seen map[string]bool
+
+	// Solutions waiting to be yielded, when ranking by score.
+	ranked []Solution
+	cur    Solution
+	err    error
+}
+
+// Solutions attempts to find multiple dependency solutions for the given
+// project, stopping after n distinct solutions have been found.
+func (s *solver) Solutions(ctx context.Context, n int, score SolutionScorer) SolutionIterator {
+	return &solutionIterator{
+		s:     s,
+		ctx:   ctx,
+		n:     n,
+		score: score,
+		seen:  make(map[string]bool),
+	}
+}
+
+func (it *solutionIterator) Next() bool {
+	if it.score == nil {
+		it.cur = it.next()
+		return it.cur != nil
+	}
+
+	if !it.done {
+		var sc []float64
+		for soln := it.next(); soln != nil; soln = it.next() {
+			it.ranked = append(it.ranked, soln)
+			sc = append(sc, it.score(soln))
+		}
+		sort.Stable(scoredSolutions{sl: it.ranked, sc: sc})
+		if it.n > 0 && len(it.ranked) > it.n {
+			it.ranked = it.ranked[:it.n]
+		}
+	}
+
+	if len(it.ranked) == 0 {
+		it.cur = nil
+		return false
+	}
+	it.cur, it.ranked = it.ranked[0], it.ranked[1:]
+	return true
+}
+
+func (it *solutionIterator) Solution() Solution {
+	return it.cur
+}
+
+func (it *solutionIterator) Err() error {
+	return it.err
+}
+
+// next runs the solver until it reaches a solution that hasn't already been
+// yielded, returning nil if there are no more to be found.
+func (it *solutionIterator) next() Solution {
+	if it.done {
+		return nil
+	}
+	// When ranking, every solution has to be found before the best can be
+	// picked out.
+	if it.score == nil && it.n > 0 && it.found >= it.n {
+		it.finish()
+		return nil
+	}
+
+	s := it.s
+	for {
+		var all map[atom]map[string]struct{}
+		var err error
+		if !it.started {
+			it.started = true
+			s.startRun(it.ctx)
+
+			if err = s.selectRoot(); err != nil {
+				if ierr := s.interrupted(it.ctx); ierr != nil {
+					err = ierr
+				}
+				it.err = err
+				it.finish()
+				return nil
+			}
+			all, err = s.solve()
+		} else if s.resume() {
+			all, err = s.solve()
+		} else {
+			// The whole search space has been covered.
+			it.finish()
+			return nil
+		}
+
+		if err != nil {
+			if ierr := s.interrupted(it.ctx); ierr != nil {
+				it.err = ierr
+			} else if it.found == 0 {
+				it.err = err
+			}
+			it.finish()
+			return nil
+		}
+
+		var fc []ForcedChange
+		if s.rd.strat == MinimalLockChurn {
+			fc = s.forcedChanges()
+		}
+		soln := s.mkSolution(all, fc)
+		soln.mtr = s.mtr.export(s.attempts)
+
+		k := solutionKey(soln)
+		if it.seen[k] {
+			continue
+		}
+		it.seen[k] = true
+		it.found++
+
+		s.traceFinish(soln, nil)
+		return soln
+	}
+}
+
+func (it *solutionIterator) finish() {
+	it.done = true
+
+	s := it.s
+	s.endRun()
+	s.mtr.pop()
+	s.attachMetrics(it.err)
+	s.runErr = it.err
+	if it.found == 0 {
+		s.traceFinish(solution{}, it.err)
+	}
+	if s.tl != nil {
+		s.mtr.dump(s.tl)
+	}
+}
+
+// solutionKey returns a string that uniquely identifies the set of projects,
+// versions and packages in a solution.
+func solutionKey(soln solution) string {
+	parts := make([]string, len(soln.p))
+	for k, lp := range soln.p {
+		parts[k] = fmt.Sprintf("%s@%s%v", lp.pi.ProjectRoot, lp.Version().typedString(), lp.pkgs)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+type scoredSolutions struct {
+	sl []Solution
+	sc []float64
+}
+
+func (s scoredSolutions) Len() int { return len(s.sl) }
+
+func (s scoredSolutions) Swap(i, j int) {
+	s.sl[i], s.sl[j] = s.sl[j], s.sl[i]
+	s.sc[i], s.sc[j] = s.sc[j], s.sc[i]
+}
+
+func (s scoredSolutions) Less(i, j int) bool { return s.sc[i] > s.sc[j] }
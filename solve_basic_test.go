@@ -404,6 +404,10 @@ type basicFixture struct {
 	maxAttempts int
 	// Use downgrade instead of default upgrade sorter
 	downgrade bool
+	// solving strategy to use, if not the default
+	strategy SolveStrategy
+	// per-project version orders, if any
+	orders map[ProjectRoot]VersionOrder
 	// lock file simulator, if one's to be used at all
 	l fixLock
 	// solve failure expected, if any
@@ -471,6 +475,26 @@ func (f basicFixture) failure() error {
 	return f.fail
 }
 
+// params returns the SolveParameters with which the fixture is solved.
+func (f basicFixture) params() SolveParameters {
+	params := SolveParameters{
+		RootDir:         string(f.ds[0].n),
+		RootPackageTree: f.rootTree(),
+		Manifest:        f.rootmanifest(),
+		Lock:            dummyLock{},
+		Downgrade:       f.downgrade,
+		ChangeAll:       f.changeall,
+		ToChange:        f.changelist,
+		Strategy:        f.strategy,
+		VersionOrders:   f.orders,
+		ProjectAnalyzer: naiveAnalyzer{},
+	}
+	if f.l != nil {
+		params.Lock = f.l
+	}
+	return params
+}
+
 // A table of basicFixtures, used in the basic solving test set.
 var basicFixtures = map[string]basicFixture{
 	// basic fixtures
@@ -951,12 +975,12 @@ var basicFixtures = map[string]basicFixture{
 			mkDepspec("foo 2.0.0"),
 			mkDepspec("foo 2.1.3"),
 		},
-		fail: &noVersionError{
+		fail: &NoVersionError{
 			pn: mkPI("foo"),
 			fails: []failedVersion{
 				{
 					v: NewVersion("2.1.3"),
-					f: &versionNotAllowedFailure{
+					f: &VersionNotAllowedFailure{
 						goal:       mkAtom("foo 2.1.3"),
 						failparent: []dependency{mkDep("root", "foo ^1.0.0", "foo")},
 						c:          mkSVC("^1.0.0"),
@@ -964,7 +988,7 @@ var basicFixtures = map[string]basicFixture{
 				},
 				{
 					v: NewVersion("2.0.0"),
-					f: &versionNotAllowedFailure{
+					f: &VersionNotAllowedFailure{
 						goal:       mkAtom("foo 2.0.0"),
 						failparent: []dependency{mkDep("root", "foo ^1.0.0", "foo")},
 						c:          mkSVC("^1.0.0"),
@@ -981,12 +1005,12 @@ var basicFixtures = map[string]basicFixture{
 			mkDepspec("shared 2.5.0"),
 			mkDepspec("shared 3.5.0"),
 		},
-		fail: &noVersionError{
+		fail: &NoVersionError{
 			pn: mkPI("shared"),
 			fails: []failedVersion{
 				{
 					v: NewVersion("3.5.0"),
-					f: &versionNotAllowedFailure{
+					f: &VersionNotAllowedFailure{
 						goal:       mkAtom("shared 3.5.0"),
 						failparent: []dependency{mkDep("foo 1.0.0", "shared >=2.0.0, <3.0.0", "shared")},
 						c:          mkSVC(">=2.9.0, <3.0.0"),
@@ -994,7 +1018,7 @@ var basicFixtures = map[string]basicFixture{
 				},
 				{
 					v: NewVersion("2.5.0"),
-					f: &versionNotAllowedFailure{
+					f: &VersionNotAllowedFailure{
 						goal:       mkAtom("shared 2.5.0"),
 						failparent: []dependency{mkDep("bar 1.0.0", "shared >=2.9.0, <4.0.0", "shared")},
 						c:          mkSVC(">=2.9.0, <3.0.0"),
@@ -1011,12 +1035,12 @@ var basicFixtures = map[string]basicFixture{
 			mkDepspec("shared 2.0.0"),
 			mkDepspec("shared 4.0.0"),
 		},
-		fail: &noVersionError{
+		fail: &NoVersionError{
 			pn: mkPI("foo"),
 			fails: []failedVersion{
 				{
 					v: NewVersion("1.0.0"),
-					f: &disjointConstraintFailure{
+					f: &DisjointConstraintFailure{
 						goal:      mkDep("foo 1.0.0", "shared <=2.0.0", "shared"),
 						failsib:   []dependency{mkDep("bar 1.0.0", "shared >3.0.0", "shared")},
 						nofailsib: nil,
@@ -1034,12 +1058,12 @@ var basicFixtures = map[string]basicFixture{
 			mkDepspec("b 1.0.0", "a 2.0.0"),
 			mkDepspec("b 2.0.0", "a 1.0.0"),
 		},
-		fail: &noVersionError{
+		fail: &NoVersionError{
 			pn: mkPI("b"),
 			fails: []failedVersion{
 				{
 					v: NewVersion("2.0.0"),
-					f: &versionNotAllowedFailure{
+					f: &VersionNotAllowedFailure{
 						goal:       mkAtom("b 2.0.0"),
 						failparent: []dependency{mkDep("a 1.0.0", "b 1.0.0", "b")},
 						c:          mkSVC("1.0.0"),
@@ -1047,7 +1071,7 @@ var basicFixtures = map[string]basicFixture{
 				},
 				{
 					v: NewVersion("1.0.0"),
-					f: &constraintNotAllowedFailure{
+					f: &ConstraintNotAllowedFailure{
 						goal: mkDep("b 1.0.0", "a 2.0.0", "a"),
 						v:    NewVersion("1.0.0"),
 					},
@@ -1061,12 +1085,12 @@ var basicFixtures = map[string]basicFixture{
 			mkDepspec("a 1.0.0"),
 			mkDepspec("b 1.0.0"),
 		},
-		fail: &noVersionError{
+		fail: &NoVersionError{
 			pn: mkPI("b"),
 			fails: []failedVersion{
 				{
 					v: NewVersion("1.0.0"),
-					f: &versionNotAllowedFailure{
+					f: &VersionNotAllowedFailure{
 						goal:       mkAtom("b 1.0.0"),
 						failparent: []dependency{mkDep("root", "b >1.0.0", "b")},
 						c:          mkSVC(">1.0.0"),
@@ -1183,12 +1207,12 @@ var basicFixtures = map[string]basicFixture{
 			mkDepspec("bar 3.0.0"),
 			mkDepspec("none 1.0.0"),
 		},
-		fail: &noVersionError{
+		fail: &NoVersionError{
 			pn: mkPI("none"),
 			fails: []failedVersion{
 				{
 					v: NewVersion("1.0.0"),
-					f: &versionNotAllowedFailure{
+					f: &VersionNotAllowedFailure{
 						goal:       mkAtom("none 1.0.0"),
 						failparent: []dependency{mkDep("foo 1.0.0", "none 2.0.0", "none")},
 						c:          mkSVC("2.0.0"),
@@ -1219,6 +1243,254 @@ var basicFixtures = map[string]basicFixture{
 		),
 		maxAttempts: 2,
 	},
+	// A failure that was resolved by moving on to another version shouldn't
+	// leave its culprit marked for backtracking. Here, y's newer versions fail
+	// against x 4.0.0, but y 1.0.0 is fine; when z then runs out of versions,
+	// only a can help, so the solver should jump straight back to it rather
+	// than walking through the versions of x. Once a has moved, the conflict
+	// learned between x 4.0.0 and y 3.0.0 rules out x 4.0.0 without a recheck.
+	"backjump past resolved failure to real culprit": {
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a *", "x *", "y *", "z *"),
+			mkDepspec("a 1.0.0"),
+			mkDepspec("a 2.0.0"),
+			mkDepspec("y 1.0.0"),
+			mkDepspec("y 2.0.0"),
+			mkDepspec("y 3.0.0"),
+			mkDepspec("x 1.0.0"),
+			mkDepspec("x 2.0.0"),
+			mkDepspec("x 3.0.0"),
+			mkDepspec("x 4.0.0", "y 1.0.0"),
+			mkDepspec("z 1.0.0", "a 1.0.0"),
+			mkDepspec("z 2.0.0", "a 1.0.0"),
+			mkDepspec("z 3.0.0", "a 1.0.0"),
+			mkDepspec("z 4.0.0", "a 1.0.0"),
+			mkDepspec("z 5.0.0", "a 1.0.0"),
+		},
+		r: mksolution(
+			"a 1.0.0",
+			"x 3.0.0",
+			"y 3.0.0",
+			"z 5.0.0",
+		),
+		maxAttempts: 1,
+	},
+	// Per-project version orders take precedence over the general
+	// up/downgrade setting.
+	"per-project downgrade": {
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "foo *", "bar *"),
+			mkDepspec("foo 1.0.0"),
+			mkDepspec("foo 2.0.0"),
+			mkDepspec("bar 1.0.0"),
+			mkDepspec("bar 2.0.0"),
+		},
+		orders: map[ProjectRoot]VersionOrder{
+			"bar": DowngradeOrder,
+		},
+		r: mksolution(
+			"foo 2.0.0",
+			"bar 1.0.0",
+		),
+	},
+	"per-project upgrade": {
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "foo *", "bar *"),
+			mkDepspec("foo 1.0.0"),
+			mkDepspec("foo 2.0.0"),
+			mkDepspec("bar 1.0.0"),
+			mkDepspec("bar 2.0.0"),
+		},
+		downgrade: true,
+		orders: map[ProjectRoot]VersionOrder{
+			"foo": UpgradeOrder,
+			"bar": DefaultOrder,
+		},
+		r: mksolution(
+			"foo 2.0.0",
+			"bar 1.0.0",
+		),
+	},
+	// c forces a off its locked version. Trying a's newest version first
+	// drags b along with it.
+	"default strategy can move more locked projects than needed": {
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a *", "b *", "c *"),
+			mkDepspec("a 1.0.0"),
+			mkDepspec("a 2.0.0"),
+			mkDepspec("a 3.0.0", "b 3.0.0"),
+			mkDepspec("b 1.0.0"),
+			mkDepspec("b 3.0.0"),
+			mkDepspec("c 1.0.0", "a >=2.0.0"),
+		},
+		l: mklock(
+			"a 1.0.0",
+			"b 1.0.0",
+		),
+		r: mksolution(
+			"a 3.0.0",
+			"b 3.0.0",
+			"c 1.0.0",
+		),
+	},
+	// Same as the previous fixture, but the search continues past the first
+	// solution until it finds one that leaves b alone.
+	"minimal churn moves fewest locked projects": {
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a *", "b *", "c *"),
+			mkDepspec("a 1.0.0"),
+			mkDepspec("a 2.0.0"),
+			mkDepspec("a 3.0.0", "b 3.0.0"),
+			mkDepspec("b 1.0.0"),
+			mkDepspec("b 3.0.0"),
+			mkDepspec("c 1.0.0", "a >=2.0.0"),
+		},
+		l: mklock(
+			"a 1.0.0",
+			"b 1.0.0",
+		),
+		strategy: MinimalLockChurn,
+		r: mksolution(
+			"a 2.0.0",
+			"b 1.0.0",
+			"c 1.0.0",
+		),
+	},
+	// Under MVS, each project gets the lowest version that satisfies all the
+	// minimums placed on it, regardless of what's in the lock.
+	"mvs selects highest of stated minimums": {
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "foo ^1.0.0", "bar ^1.1.0"),
+			mkDepspec("foo 1.0.0", "bar ^1.2.0"),
+			mkDepspec("foo 1.1.0", "bar ^1.3.0"),
+			mkDepspec("bar 1.0.0"),
+			mkDepspec("bar 1.1.0"),
+			mkDepspec("bar 1.2.0"),
+			mkDepspec("bar 1.3.0"),
+		},
+		l: mklock(
+			"foo 1.1.0",
+			"bar 1.3.0",
+		),
+		strategy: MinimalVersionSelection,
+		r: mksolution(
+			"foo 1.0.0",
+			"bar 1.2.0",
+		),
+	},
+	// Under MVS, semver constraints are only minimums, so a higher minimum
+	// from a dependency wins out over the root's upper bound.
+	"mvs drops upper bounds": {
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "foo ^1.0.0", "bar ~1.0.0"),
+			mkDepspec("foo 1.0.0", "bar ^1.2.0"),
+			mkDepspec("bar 1.0.0"),
+			mkDepspec("bar 1.2.0"),
+			mkDepspec("bar 1.3.0"),
+			mkDepspec("bar 2.0.0"),
+		},
+		strategy: MinimalVersionSelection,
+		r: mksolution(
+			"foo 1.0.0",
+			"bar 1.2.0",
+		),
+	},
+	// A new major version is assumed to break compatibility, though, so MVS
+	// never moves past the major version a constraint allows. The failure
+	// reports the constraints as MVS converted them.
+	"mvs keeps major version bounds": {
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "foo ^1.0.0", "bar ^1.0.0"),
+			mkDepspec("foo 1.0.0", "bar ^2.0.0"),
+			mkDepspec("bar 1.0.0"),
+			mkDepspec("bar 2.0.0"),
+		},
+		strategy: MinimalVersionSelection,
+		fail: &NoVersionError{
+			pn: mkPI("foo"),
+			fails: []failedVersion{
+				{
+					v: NewVersion("1.0.0"),
+					f: &DisjointConstraintFailure{
+						goal:      mkDep("foo 1.0.0", "bar >=2.0.0, <3.0.0", "bar"),
+						failsib:   []dependency{mkDep("root", "bar >=1.0.0, <2.0.0", "bar")},
+						nofailsib: nil,
+						c:         mkSVC(">=1.0.0, <2.0.0"),
+					},
+				},
+			},
+		},
+	},
+	// Before 1.0.0, each minor version is assumed to break compatibility
+	// instead.
+	"mvs keeps minor version bounds before 1.0.0": {
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "foo ^1.0.0", "bar ^0.1.0"),
+			mkDepspec("foo 1.0.0", "bar ^0.2.0"),
+			mkDepspec("bar 0.1.0"),
+			mkDepspec("bar 0.2.0"),
+		},
+		strategy: MinimalVersionSelection,
+		fail: &NoVersionError{
+			pn: mkPI("foo"),
+			fails: []failedVersion{
+				{
+					v: NewVersion("1.0.0"),
+					f: &DisjointConstraintFailure{
+						goal:      mkDep("foo 1.0.0", "bar >=0.2.0, <0.3.0", "bar"),
+						failsib:   []dependency{mkDep("root", "bar >=0.1.0, <0.2.0", "bar")},
+						nofailsib: nil,
+						c:         mkSVC(">=0.1.0, <0.2.0"),
+					},
+				},
+			},
+		},
+	},
+	// Within a minor version, a higher minimum still wins out.
+	"mvs raises minimums within a minor version before 1.0.0": {
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "foo ^1.0.0", "bar ^0.1.0"),
+			mkDepspec("foo 1.0.0", "bar ^0.1.2"),
+			mkDepspec("bar 0.1.0"),
+			mkDepspec("bar 0.1.2"),
+			mkDepspec("bar 0.1.3"),
+			mkDepspec("bar 0.2.0"),
+		},
+		strategy: MinimalVersionSelection,
+		r: mksolution(
+			"foo 1.0.0",
+			"bar 0.1.2",
+		),
+	},
+	// Unless the constraint itself allows the next major version.
+	"mvs follows constraints across major versions": {
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "foo ^1.0.0", "bar >=1.0.0"),
+			mkDepspec("foo 1.0.0", "bar ^2.0.0"),
+			mkDepspec("bar 1.0.0"),
+			mkDepspec("bar 2.0.0"),
+			mkDepspec("bar 2.1.0"),
+		},
+		strategy: MinimalVersionSelection,
+		r: mksolution(
+			"foo 1.0.0",
+			"bar 2.0.0",
+		),
+	},
+	// Non-semver constraints still have to be met exactly under MVS.
+	"mvs respects branch constraints": {
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "foo ^1.0.0"),
+			mkDepspec("foo 1.0.0", "bar bmaster"),
+			mkDepspec("bar 1.0.0"),
+			mkDepspec("bar bmaster"),
+		},
+		strategy: MinimalVersionSelection,
+		r: mksolution(
+			"foo 1.0.0",
+			"bar bmaster",
+		),
+	},
 	// Revision enters vqueue if a dep has a constraint on that revision
 	"revision injected into vqueue": {
 		ds: []depspec{
@@ -1571,7 +1843,7 @@ func (b *depspecBridge) listVersions(id ProjectIdentifier) ([]Version, error) {
 		}
 	}
 
-	if b.down {
+	if b.downgrade(id.ProjectRoot) {
 		SortForDowngrade(vl)
 	} else {
 		SortForUpgrade(vl)
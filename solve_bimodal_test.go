@@ -426,12 +426,12 @@ var bimodalFixtures = map[string]bimodalFixture{
 				pkg("a"),
 			),
 		},
-		fail: &noVersionError{
+		fail: &NoVersionError{
 			pn: mkPI("a"),
 			fails: []failedVersion{
 				{
 					v: NewVersion("1.0.0"),
-					f: &checkeeHasProblemPackagesFailure{
+					f: &CheckeeHasProblemPackagesFailure{
 						goal: mkAtom("a 1.0.0"),
 						failpkg: map[string]errDeppers{
 							"a/foo": errDeppers{
@@ -469,12 +469,12 @@ var bimodalFixtures = map[string]bimodalFixture{
 				pkg("d", "a/nonexistent"),
 			),
 		},
-		fail: &noVersionError{
+		fail: &NoVersionError{
 			pn: mkPI("d"),
 			fails: []failedVersion{
 				{
 					v: NewVersion("1.0.0"),
-					f: &depHasProblemPackagesFailure{
+					f: &DepHasProblemPackagesFailure{
 						goal: mkADep("d 1.0.0", "a", Any(), "a/nonexistent"),
 						v:    NewVersion("1.0.0"),
 						prob: map[string]error{
@@ -745,12 +745,12 @@ var bimodalFixtures = map[string]bimodalFixture{
 			dsp(mkDepspec("quux 1.0.0"),
 				pkg("baz")),
 		},
-		fail: &noVersionError{
+		fail: &NoVersionError{
 			pn: mkPI("bar"),
 			fails: []failedVersion{
 				{
 					v: NewVersion("2.0.0"),
-					f: &sourceMismatchFailure{
+					f: &SourceMismatchFailure{
 						shared:   ProjectRoot("baz"),
 						current:  "baz",
 						mismatch: "quux",
@@ -772,12 +772,12 @@ var bimodalFixtures = map[string]bimodalFixture{
 			dsp(mkDepspec("baz 1.0.0"),
 				pkg("bar")),
 		},
-		fail: &noVersionError{
+		fail: &NoVersionError{
 			pn: mkPI("foo"),
 			fails: []failedVersion{
 				{
 					v: NewVersion("1.0.0"),
-					f: &sourceMismatchFailure{
+					f: &SourceMismatchFailure{
 						shared:   ProjectRoot("bar"),
 						current:  "bar",
 						mismatch: "baz",
@@ -880,12 +880,12 @@ var bimodalFixtures = map[string]bimodalFixture{
 				pkg("baz/qux")),
 		},
 		require: []string{"baz/qux"},
-		fail: &noVersionError{
+		fail: &NoVersionError{
 			pn: mkPI("baz"),
 			fails: []failedVersion{
 				{
 					v: NewVersion("2.0.0"),
-					f: &versionNotAllowedFailure{
+					f: &VersionNotAllowedFailure{
 						goal:       mkAtom("baz 2.0.0"),
 						failparent: []dependency{mkDep("root", "baz 1.0.0", "baz/qux")},
 						c:          NewVersion("1.0.0"),
@@ -893,7 +893,7 @@ var bimodalFixtures = map[string]bimodalFixture{
 				},
 				{
 					v: NewVersion("1.0.0"),
-					f: &checkeeHasProblemPackagesFailure{
+					f: &CheckeeHasProblemPackagesFailure{
 						goal: mkAtom("baz 1.0.0"),
 						failpkg: map[string]errDeppers{
 							"baz/qux": errDeppers{
@@ -921,12 +921,12 @@ var bimodalFixtures = map[string]bimodalFixture{
 				pkg("baz/qux")),
 		},
 		require: []string{"baz/qux"},
-		fail: &noVersionError{
+		fail: &NoVersionError{
 			pn: mkPI("baz"),
 			fails: []failedVersion{
 				{
 					v: NewVersion("2.0.0"),
-					f: &versionNotAllowedFailure{
+					f: &VersionNotAllowedFailure{
 						goal:       mkAtom("baz 2.0.0"),
 						failparent: []dependency{mkDep("foo 1.0.0", "baz 1.0.0", "baz")},
 						c:          NewVersion("1.0.0"),
@@ -934,7 +934,7 @@ var bimodalFixtures = map[string]bimodalFixture{
 				},
 				{
 					v: NewVersion("1.0.0"),
-					f: &checkeeHasProblemPackagesFailure{
+					f: &CheckeeHasProblemPackagesFailure{
 						goal: mkAtom("baz 1.0.0"),
 						failpkg: map[string]errDeppers{
 							"baz/qux": errDeppers{
@@ -962,12 +962,12 @@ var bimodalFixtures = map[string]bimodalFixture{
 				pkg("baz/qux")),
 		},
 		require: []string{"baz/qux"},
-		fail: &noVersionError{
+		fail: &NoVersionError{
 			pn: mkPI("baz"),
 			fails: []failedVersion{
 				{
 					v: NewVersion("2.0.0"),
-					f: &versionNotAllowedFailure{
+					f: &VersionNotAllowedFailure{
 						goal:       mkAtom("baz 2.0.0"),
 						failparent: []dependency{mkDep("foo 1.0.0", "baz 1.0.0", "baz")},
 						c:          NewVersion("1.0.0"),
@@ -975,7 +975,7 @@ var bimodalFixtures = map[string]bimodalFixture{
 				},
 				{
 					v: NewVersion("1.0.0"),
-					f: &checkeeHasProblemPackagesFailure{
+					f: &CheckeeHasProblemPackagesFailure{
 						goal: mkAtom("baz 1.0.0"),
 						failpkg: map[string]errDeppers{
 							"baz/qux": errDeppers{
@@ -1089,6 +1089,23 @@ func (f bimodalFixture) failure() error {
 	return f.fail
 }
 
+// params returns the SolveParameters with which the fixture is solved.
+func (f bimodalFixture) params() SolveParameters {
+	params := SolveParameters{
+		RootDir:         string(f.ds[0].n),
+		RootPackageTree: f.rootTree(),
+		Manifest:        f.rootmanifest(),
+		Lock:            dummyLock{},
+		Downgrade:       f.downgrade,
+		ChangeAll:       f.changeall,
+		ProjectAnalyzer: naiveAnalyzer{},
+	}
+	if f.l != nil {
+		params.Lock = f.l
+	}
+	return params
+}
+
 // bmSourceManager is an SM specifically for the bimodal fixtures. It composes
 // the general depspec SM, and differs from it in how it answers static analysis
 // calls, and its support for package ignores and dep lock data.
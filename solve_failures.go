@@ -2,6 +2,7 @@ package gps
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
@@ -30,12 +31,150 @@ type traceError interface {
 	traceString() string
 }
 
-type noVersionError struct {
+// SolveFailure is implemented by each of the failures the solver reports when
+// it can't select a project, or a version of one. Its methods give the details
+// common to all failures; each type of failure has further methods for its own.
+//
+// Failures encode to JSON in a stable form, with a "kind" field naming the type
+// of failure, and a "message" field holding its Error() text.
+type SolveFailure interface {
+	error
+	json.Marshaler
+
+	// Goal returns the atom the solver was unable to select. If there was no
+	// particular version, as when none could be found, its Version is nil.
+	Goal() Atom
+
+	// Constraint returns the constraint at fault, or nil if the failure was
+	// not the result of a constraint.
+	Constraint() Constraint
+
+	// Dependers returns the selected projects whose dependencies took part in
+	// the failure, each with its constraint.
+	Dependers() []Depender
+}
+
+var (
+	_ SolveFailure = &NoVersionError{}
+	_ SolveFailure = &DisjointConstraintFailure{}
+	_ SolveFailure = &ConstraintNotAllowedFailure{}
+	_ SolveFailure = &VersionNotAllowedFailure{}
+	_ SolveFailure = &MissingSourceFailure{}
+	_ SolveFailure = &SourceMismatchFailure{}
+	_ SolveFailure = &CheckeeHasProblemPackagesFailure{}
+	_ SolveFailure = &DepHasProblemPackagesFailure{}
+	_ SolveFailure = &NonexistentRevisionFailure{}
+	_ SolveFailure = &LearnedConflictFailure{}
+	_ SolveFailure = &LockChurnFailure{}
+	_ SolveFailure = &VersionExcludedFailure{}
+	_ SolveFailure = &PolicyVetoFailure{}
+)
+
+// An Atom is a project at a particular version, as selected or considered by
+// the solver. The Version of a root project is nil.
+type Atom struct {
+	Ident   ProjectIdentifier
+	Version Version
+}
+
+func toAtom(a atom) Atom {
+	if a.v == rootRev {
+		return Atom{Ident: a.id}
+	}
+	return Atom{Ident: a.id, Version: a.v}
+}
+
+func toAtoms(al []atom) []Atom {
+	if len(al) == 0 {
+		return nil
+	}
+
+	atoms := make([]Atom, len(al))
+	for k, a := range al {
+		atoms[k] = toAtom(a)
+	}
+	return atoms
+}
+
+func (a Atom) String() string {
+	return a2vs(atom{id: a.Ident, v: a.Version})
+}
+
+// A Depender is a selected atom's dependency on the project involved in a
+// failure.
+type Depender struct {
+	Atom
+	// Constraint is the depender's constraint on the project.
+	Constraint Constraint
+	// Conflicts indicates that the constraint conflicts with the failure's
+	// goal by itself. When it is false, the constraint is at fault only in
+	// combination with those of the other dependers.
+	Conflicts bool
+}
+
+func toDependers(deps []dependency, conflicts bool) []Depender {
+	var dl []Depender
+	for _, d := range deps {
+		dl = append(dl, Depender{
+			Atom:       toAtom(d.depender),
+			Constraint: d.dep.Constraint,
+			Conflicts:  conflicts,
+		})
+	}
+	return dl
+}
+
+// A VersionFailure is the reason a particular version was rejected.
+type VersionFailure struct {
+	Version Version
+	Err     error
+}
+
+// A ProblemPackage is a package that the solver needed, but that was missing
+// or unusable.
+type ProblemPackage struct {
+	// Path is the package's import path.
+	Path string
+	// Err is the problem with the package, or nil if it is missing.
+	Err error
+	// Dependers lists the selected atoms that import the package, if known.
+	Dependers []Atom
+}
+
+// NoVersionError indicates that no version of a project could be selected,
+// either because there were none, or because each one failed.
+type NoVersionError struct {
 	pn    ProjectIdentifier
 	fails []failedVersion
 }
 
-func (e *noVersionError) Error() string {
+// Goal returns the project, with no Version.
+func (e *NoVersionError) Goal() Atom {
+	return Atom{Ident: e.pn}
+}
+
+// Constraint returns nil; the constraints at fault are given by each of the
+// Failures.
+func (e *NoVersionError) Constraint() Constraint {
+	return nil
+}
+
+// Dependers returns nil.
+func (e *NoVersionError) Dependers() []Depender {
+	return nil
+}
+
+// Failures returns each version that was tried, along with the reason it was
+// rejected.
+func (e *NoVersionError) Failures() []VersionFailure {
+	var fl []VersionFailure
+	for _, f := range e.fails {
+		fl = append(fl, VersionFailure{Version: f.v, Err: f.f})
+	}
+	return fl
+}
+
+func (e *NoVersionError) Error() string {
 	if len(e.fails) == 0 {
 		return fmt.Sprintf("No versions found for project %q.", e.pn.ProjectRoot)
 	}
@@ -49,7 +188,7 @@ func (e *noVersionError) Error() string {
 	return buf.String()
 }
 
-func (e *noVersionError) traceString() string {
+func (e *NoVersionError) traceString() string {
 	if len(e.fails) == 0 {
 		return fmt.Sprintf("No versions found")
 	}
@@ -67,11 +206,11 @@ func (e *noVersionError) traceString() string {
 	return buf.String()
 }
 
-// disjointConstraintFailure occurs when attempting to introduce an atom that
+// DisjointConstraintFailure occurs when attempting to introduce an atom that
 // itself has an acceptable version, but one of its dependency constraints is
 // disjoint with one or more dependency constraints already active for that
 // identifier.
-type disjointConstraintFailure struct {
+type DisjointConstraintFailure struct {
 	// goal is the dependency with the problematic constraint, forcing us to
 	// reject the atom that introduces it.
 	goal dependency
@@ -88,7 +227,33 @@ type disjointConstraintFailure struct {
 	c Constraint
 }
 
-func (e *disjointConstraintFailure) Error() string {
+// Goal returns the atom with the disjoint constraint.
+func (e *DisjointConstraintFailure) Goal() Atom {
+	return toAtom(e.goal.depender)
+}
+
+// Constraint returns the goal's constraint on the Target.
+func (e *DisjointConstraintFailure) Constraint() Constraint {
+	return e.goal.dep.Constraint
+}
+
+// Dependers returns every active dependency on the Target. Those whose
+// constraints have no overlap with the goal's are marked as Conflicts.
+func (e *DisjointConstraintFailure) Dependers() []Depender {
+	return append(toDependers(e.failsib, true), toDependers(e.nofailsib, false)...)
+}
+
+// Target returns the project on which the constraints disagree.
+func (e *DisjointConstraintFailure) Target() ProjectIdentifier {
+	return e.goal.dep.Ident
+}
+
+// Current returns the intersection of the Dependers' constraints.
+func (e *DisjointConstraintFailure) Current() Constraint {
+	return e.c
+}
+
+func (e *DisjointConstraintFailure) Error() string {
 	if len(e.failsib) == 1 {
 		str := "Could not introduce %s, as it has a dependency on %s with constraint %s, which has no overlap with existing constraint %s from %s"
 		return fmt.Sprintf(str, a2vs(e.goal.depender), e.goal.dep.Ident.errString(), e.goal.dep.Constraint.String(), e.failsib[0].dep.Constraint.String(), a2vs(e.failsib[0].depender))
@@ -116,7 +281,7 @@ func (e *disjointConstraintFailure) Error() string {
 	return buf.String()
 }
 
-func (e *disjointConstraintFailure) traceString() string {
+func (e *DisjointConstraintFailure) traceString() string {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "constraint %s on %s disjoint with other dependers:\n", e.goal.dep.Constraint.String(), e.goal.dep.Ident.errString())
 	for _, f := range e.failsib {
@@ -139,10 +304,10 @@ func (e *disjointConstraintFailure) traceString() string {
 	return buf.String()
 }
 
-// Indicates that an atom could not be introduced because one of its dep
-// constraints does not admit the currently-selected version of the target
-// project.
-type constraintNotAllowedFailure struct {
+// ConstraintNotAllowedFailure indicates that an atom could not be introduced
+// because one of its dep constraints does not admit the currently-selected
+// version of the target project.
+type ConstraintNotAllowedFailure struct {
 	// The dependency with the problematic constraint that could not be
 	// introduced.
 	goal dependency
@@ -151,7 +316,32 @@ type constraintNotAllowedFailure struct {
 	v Version
 }
 
-func (e *constraintNotAllowedFailure) Error() string {
+// Goal returns the atom with the constraint that could not be introduced.
+func (e *ConstraintNotAllowedFailure) Goal() Atom {
+	return toAtom(e.goal.depender)
+}
+
+// Constraint returns the goal's constraint on the Target.
+func (e *ConstraintNotAllowedFailure) Constraint() Constraint {
+	return e.goal.dep.Constraint
+}
+
+// Dependers returns nil.
+func (e *ConstraintNotAllowedFailure) Dependers() []Depender {
+	return nil
+}
+
+// Target returns the project on which the goal has the constraint.
+func (e *ConstraintNotAllowedFailure) Target() ProjectIdentifier {
+	return e.goal.dep.Ident
+}
+
+// Selected returns the version of the Target that is already selected.
+func (e *ConstraintNotAllowedFailure) Selected() Version {
+	return e.v
+}
+
+func (e *ConstraintNotAllowedFailure) Error() string {
 	return fmt.Sprintf(
 		"Could not introduce %s, as it has a dependency on %s with constraint %s, which does not allow the currently selected version of %s",
 		a2vs(e.goal.depender),
@@ -161,7 +351,7 @@ func (e *constraintNotAllowedFailure) Error() string {
 	)
 }
 
-func (e *constraintNotAllowedFailure) traceString() string {
+func (e *ConstraintNotAllowedFailure) traceString() string {
 	return fmt.Sprintf(
 		"%s depends on %s with %s, but that's already selected at %s",
 		a2vs(e.goal.depender),
@@ -171,11 +361,11 @@ func (e *constraintNotAllowedFailure) traceString() string {
 	)
 }
 
-// versionNotAllowedFailure describes a failure where an atom is rejected
+// VersionNotAllowedFailure describes a failure where an atom is rejected
 // because its version is not allowed by current constraints.
 //
 // (This is one of the more straightforward types of failures)
-type versionNotAllowedFailure struct {
+type VersionNotAllowedFailure struct {
 	// goal is the atom that was rejected by current constraints.
 	goal atom
 	// failparent is the list of active dependencies that caused the atom to be
@@ -188,7 +378,24 @@ type versionNotAllowedFailure struct {
 	c Constraint
 }
 
-func (e *versionNotAllowedFailure) Error() string {
+// Goal returns the rejected atom.
+func (e *VersionNotAllowedFailure) Goal() Atom {
+	return toAtom(e.goal)
+}
+
+// Constraint returns the intersection of all the active constraints on the
+// goal's project.
+func (e *VersionNotAllowedFailure) Constraint() Constraint {
+	return e.c
+}
+
+// Dependers returns the active dependencies whose constraints rejected the
+// goal.
+func (e *VersionNotAllowedFailure) Dependers() []Depender {
+	return toDependers(e.failparent, true)
+}
+
+func (e *VersionNotAllowedFailure) Error() string {
 	if len(e.failparent) == 1 {
 		return fmt.Sprintf(
 			"Could not introduce %s, as it is not allowed by constraint %s from project %s.",
@@ -209,7 +416,7 @@ func (e *versionNotAllowedFailure) Error() string {
 	return buf.String()
 }
 
-func (e *versionNotAllowedFailure) traceString() string {
+func (e *VersionNotAllowedFailure) traceString() string {
 	var buf bytes.Buffer
 
 	fmt.Fprintf(&buf, "%s not allowed by constraint %s:\n", a2vs(e.goal), e.c.String())
@@ -220,22 +427,60 @@ func (e *versionNotAllowedFailure) traceString() string {
 	return buf.String()
 }
 
-type missingSourceFailure struct {
+// MissingSourceFailure indicates that a change was requested for a project
+// that has no source from which to get its versions.
+type MissingSourceFailure struct {
 	goal ProjectIdentifier
 	prob string
 }
 
-func (e *missingSourceFailure) Error() string {
+// Goal returns the project, with no Version.
+func (e *MissingSourceFailure) Goal() Atom {
+	return Atom{Ident: e.goal}
+}
+
+// Constraint returns nil.
+func (e *MissingSourceFailure) Constraint() Constraint {
+	return nil
+}
+
+// Dependers returns nil.
+func (e *MissingSourceFailure) Dependers() []Depender {
+	return nil
+}
+
+func (e *MissingSourceFailure) Error() string {
 	return fmt.Sprintf(e.prob, e.goal)
 }
 
+// SolveCanceledError indicates that a solving run was abandoned because the
+// context passed to Solver.SolveContext() was canceled, or its deadline
+// expired, before a solution was found.
+type SolveCanceledError struct {
+	// Attempts is the number of attempts the solver had made at the time it
+	// was canceled.
+	Attempts int
+	// Err is the error reported by the context; either context.Canceled or
+	// context.DeadlineExceeded.
+	Err error
+	// Metrics reports on the work the solver did before it was canceled.
+	Metrics SolveMetrics
+}
+
+func (e *SolveCanceledError) Error() string {
+	return fmt.Sprintf("solving canceled after %v attempts: %s", e.Attempts, e.Err)
+}
+
 type badOptsFailure string
 
 func (e badOptsFailure) Error() string {
 	return string(e)
 }
 
-type sourceMismatchFailure struct {
+// SourceMismatchFailure indicates that an atom could not be introduced because
+// it wants one of its dependencies from a different source than the one
+// already established by the selected atoms.
+type SourceMismatchFailure struct {
 	// The ProjectRoot over which there is disagreement about where it should be
 	// sourced from
 	shared ProjectRoot
@@ -250,7 +495,38 @@ type sourceMismatchFailure struct {
 	prob atom
 }
 
-func (e *sourceMismatchFailure) Error() string {
+// Goal returns the atom with the dependency on the mismatched source.
+func (e *SourceMismatchFailure) Goal() Atom {
+	return toAtom(e.prob)
+}
+
+// Constraint returns nil.
+func (e *SourceMismatchFailure) Constraint() Constraint {
+	return nil
+}
+
+// Dependers returns the active dependencies that established the Current
+// source.
+func (e *SourceMismatchFailure) Dependers() []Depender {
+	return toDependers(e.sel, true)
+}
+
+// Target returns the project whose source is in dispute.
+func (e *SourceMismatchFailure) Target() ProjectRoot {
+	return e.shared
+}
+
+// Current returns the source already established for the Target.
+func (e *SourceMismatchFailure) Current() string {
+	return e.current
+}
+
+// Mismatch returns the source the goal wants for the Target.
+func (e *SourceMismatchFailure) Mismatch() string {
+	return e.mismatch
+}
+
+func (e *SourceMismatchFailure) Error() string {
 	var cur []string
 	for _, c := range e.sel {
 		cur = append(cur, string(c.depender.id.ProjectRoot))
@@ -260,7 +536,7 @@ func (e *sourceMismatchFailure) Error() string {
 	return fmt.Sprintf(str, a2vs(e.prob), e.shared, e.mismatch, e.shared, e.current, strings.Join(cur, ", "))
 }
 
-func (e *sourceMismatchFailure) traceString() string {
+func (e *SourceMismatchFailure) traceString() string {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "disagreement on network addr for %s:\n", e.shared)
 
@@ -277,16 +553,16 @@ type errDeppers struct {
 	deppers []atom
 }
 
-// checkeeHasProblemPackagesFailure indicates that the goal atom was rejected
+// CheckeeHasProblemPackagesFailure indicates that the goal atom was rejected
 // because one or more of the packages required by its deppers had errors.
 //
 // "errors" includes package nonexistence, which is indicated by a nil err in
 // the corresponding errDeppers failpkg map value.
 //
-// checkeeHasProblemPackagesFailure complements depHasProblemPackagesFailure;
+// CheckeeHasProblemPackagesFailure complements DepHasProblemPackagesFailure;
 // one or the other could appear to describe the same fundamental issue,
 // depending on the order in which dependencies were visited.
-type checkeeHasProblemPackagesFailure struct {
+type CheckeeHasProblemPackagesFailure struct {
 	// goal is the atom that was rejected due to problematic packages.
 	goal atom
 	// failpkg is a map of package names to the error describing the problem
@@ -294,7 +570,42 @@ type checkeeHasProblemPackagesFailure struct {
 	failpkg map[string]errDeppers
 }
 
-func (e *checkeeHasProblemPackagesFailure) Error() string {
+// Goal returns the atom with the problem packages.
+func (e *CheckeeHasProblemPackagesFailure) Goal() Atom {
+	return toAtom(e.goal)
+}
+
+// Constraint returns nil.
+func (e *CheckeeHasProblemPackagesFailure) Constraint() Constraint {
+	return nil
+}
+
+// Dependers returns nil; the atoms requiring each package are given by
+// Packages.
+func (e *CheckeeHasProblemPackagesFailure) Dependers() []Depender {
+	return nil
+}
+
+// Packages returns the problem packages, sorted by import path.
+func (e *CheckeeHasProblemPackagesFailure) Packages() []ProblemPackage {
+	pkgs := make([]string, 0, len(e.failpkg))
+	for pkg := range e.failpkg {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+
+	pl := make([]ProblemPackage, len(pkgs))
+	for k, pkg := range pkgs {
+		pl[k] = ProblemPackage{
+			Path:      pkg,
+			Err:       e.failpkg[pkg].err,
+			Dependers: toAtoms(e.failpkg[pkg].deppers),
+		}
+	}
+	return pl
+}
+
+func (e *CheckeeHasProblemPackagesFailure) Error() string {
 	var buf bytes.Buffer
 	indent := ""
 
@@ -341,7 +652,7 @@ func (e *checkeeHasProblemPackagesFailure) Error() string {
 	return buf.String()
 }
 
-func (e *checkeeHasProblemPackagesFailure) traceString() string {
+func (e *CheckeeHasProblemPackagesFailure) traceString() string {
 	var buf bytes.Buffer
 
 	fmt.Fprintf(&buf, "%s at %s has problem subpkg(s):\n", e.goal.id.ProjectRoot, e.goal.v)
@@ -365,7 +676,7 @@ func (e *checkeeHasProblemPackagesFailure) traceString() string {
 	return buf.String()
 }
 
-// depHasProblemPackagesFailure indicates that the goal dependency was rejected
+// DepHasProblemPackagesFailure indicates that the goal dependency was rejected
 // because there were problems with one or more of the packages the dependency
 // requires in the atom currently selected for that dependency. (This failure
 // can only occur if the target dependency is already selected.)
@@ -373,10 +684,10 @@ func (e *checkeeHasProblemPackagesFailure) traceString() string {
 // "errors" includes package nonexistence, which is indicated by a nil err as
 // the corresponding prob map value.
 //
-// depHasProblemPackagesFailure complements checkeeHasProblemPackagesFailure;
+// DepHasProblemPackagesFailure complements CheckeeHasProblemPackagesFailure;
 // one or the other could appear to describe the same fundamental issue,
 // depending on the order in which dependencies were visited.
-type depHasProblemPackagesFailure struct {
+type DepHasProblemPackagesFailure struct {
 	// goal is the dependency that was rejected due to the atom currently
 	// selected for the dependency's target id having errors (including, and
 	// probably most commonly,
@@ -390,7 +701,50 @@ type depHasProblemPackagesFailure struct {
 	prob map[string]error
 }
 
-func (e *depHasProblemPackagesFailure) Error() string {
+// Goal returns the atom requiring the problem packages.
+func (e *DepHasProblemPackagesFailure) Goal() Atom {
+	return toAtom(e.goal.depender)
+}
+
+// Constraint returns the goal's constraint on the Target.
+func (e *DepHasProblemPackagesFailure) Constraint() Constraint {
+	return e.goal.dep.Constraint
+}
+
+// Dependers returns nil.
+func (e *DepHasProblemPackagesFailure) Dependers() []Depender {
+	return nil
+}
+
+// Target returns the project containing the problem packages.
+func (e *DepHasProblemPackagesFailure) Target() ProjectIdentifier {
+	return e.goal.dep.Ident
+}
+
+// Selected returns the version of the Target that is already selected.
+func (e *DepHasProblemPackagesFailure) Selected() Version {
+	return e.v
+}
+
+// Packages returns the problem packages, sorted by import path.
+func (e *DepHasProblemPackagesFailure) Packages() []ProblemPackage {
+	pkgs := make([]string, 0, len(e.prob))
+	for pkg := range e.prob {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+
+	pl := make([]ProblemPackage, len(pkgs))
+	for k, pkg := range pkgs {
+		pl[k] = ProblemPackage{
+			Path: pkg,
+			Err:  e.prob[pkg],
+		}
+	}
+	return pl
+}
+
+func (e *DepHasProblemPackagesFailure) Error() string {
 	fcause := func(pkg string) string {
 		if err := e.prob[pkg]; err != nil {
 			return fmt.Sprintf("does not contain usable Go code (%T).", err)
@@ -435,7 +789,7 @@ func (e *depHasProblemPackagesFailure) Error() string {
 	return buf.String()
 }
 
-func (e *depHasProblemPackagesFailure) traceString() string {
+func (e *DepHasProblemPackagesFailure) traceString() string {
 	var buf bytes.Buffer
 	fcause := func(pkg string) string {
 		if err := e.prob[pkg]; err != nil {
@@ -465,15 +819,40 @@ func (e *depHasProblemPackagesFailure) traceString() string {
 	return buf.String()
 }
 
-// nonexistentRevisionFailure indicates that a revision constraint was specified
+// NonexistentRevisionFailure indicates that a revision constraint was specified
 // for a given project, but that that revision does not exist in the source
 // repository.
-type nonexistentRevisionFailure struct {
+type NonexistentRevisionFailure struct {
 	goal dependency
 	r    Revision
 }
 
-func (e *nonexistentRevisionFailure) Error() string {
+// Goal returns the atom requiring the missing revision.
+func (e *NonexistentRevisionFailure) Goal() Atom {
+	return toAtom(e.goal.depender)
+}
+
+// Constraint returns the goal's constraint on the Target.
+func (e *NonexistentRevisionFailure) Constraint() Constraint {
+	return e.goal.dep.Constraint
+}
+
+// Dependers returns nil.
+func (e *NonexistentRevisionFailure) Dependers() []Depender {
+	return nil
+}
+
+// Target returns the project in which the revision is missing.
+func (e *NonexistentRevisionFailure) Target() ProjectIdentifier {
+	return e.goal.dep.Ident
+}
+
+// Revision returns the missing revision.
+func (e *NonexistentRevisionFailure) Revision() Revision {
+	return e.r
+}
+
+func (e *NonexistentRevisionFailure) Error() string {
 	return fmt.Sprintf(
 		"Could not introduce %s, as it requires %s at revision %s, but that revision does not exist",
 		a2vs(e.goal.depender),
@@ -482,7 +861,7 @@ func (e *nonexistentRevisionFailure) Error() string {
 	)
 }
 
-func (e *nonexistentRevisionFailure) traceString() string {
+func (e *NonexistentRevisionFailure) traceString() string {
 	return fmt.Sprintf(
 		"%s wants missing rev %s of %s",
 		a2vs(e.goal.depender),
@@ -490,3 +869,229 @@ func (e *nonexistentRevisionFailure) traceString() string {
 		e.goal.dep.Ident.errString(),
 	)
 }
+
+// LearnedConflictFailure indicates that an atom was rejected without being
+// checked, because selecting it would complete a set of atoms that the solver
+// had already learned cannot be selected together.
+type LearnedConflictFailure struct {
+	// goal is the atom that was rejected.
+	goal atom
+	// with is the list of currently selected atoms that, together with the
+	// goal, make up the learned conflict.
+	with []atom
+	// cause is the failure from which the conflict was originally learned.
+	cause error
+}
+
+// Goal returns the rejected atom.
+func (e *LearnedConflictFailure) Goal() Atom {
+	return toAtom(e.goal)
+}
+
+// Constraint returns nil.
+func (e *LearnedConflictFailure) Constraint() Constraint {
+	return nil
+}
+
+// Dependers returns nil; the atoms in the conflict are given by With.
+func (e *LearnedConflictFailure) Dependers() []Depender {
+	return nil
+}
+
+// With returns the selected atoms that, together with the goal, make up the
+// learned conflict.
+func (e *LearnedConflictFailure) With() []Atom {
+	return toAtoms(e.with)
+}
+
+// Cause returns the failure from which the conflict was learned.
+func (e *LearnedConflictFailure) Cause() error {
+	return e.cause
+}
+
+func (e *LearnedConflictFailure) Error() string {
+	if len(e.with) == 0 {
+		return fmt.Sprintf("Could not introduce %s, as it was already found to be unusable", a2vs(e.goal))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Could not introduce %s, as it was already found to conflict with the following selected projects:\n", a2vs(e.goal))
+	for _, a := range e.with {
+		fmt.Fprintf(&buf, "\t%s\n", a2vs(a))
+	}
+
+	return buf.String()
+}
+
+func (e *LearnedConflictFailure) traceString() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s matches a learned conflict", a2vs(e.goal))
+	for k, a := range e.with {
+		if k == 0 {
+			fmt.Fprintf(&buf, " with %s", a2vs(a))
+		} else {
+			fmt.Fprintf(&buf, ", %s", a2vs(a))
+		}
+	}
+
+	return buf.String()
+}
+
+// LockChurnFailure indicates that, while searching for the solution that
+// changes the fewest locked projects, an atom was rejected because selecting it
+// could not lead to an improvement on the best solution found so far.
+type LockChurnFailure struct {
+	// goal is the atom that was rejected.
+	goal atom
+	// with is the list of currently selected atoms that have already been
+	// moved away from their locked versions.
+	with []atom
+	// best is the number of locked projects changed by the best solution
+	// found so far.
+	best int
+}
+
+// Goal returns the rejected atom.
+func (e *LockChurnFailure) Goal() Atom {
+	return toAtom(e.goal)
+}
+
+// Constraint returns nil.
+func (e *LockChurnFailure) Constraint() Constraint {
+	return nil
+}
+
+// Dependers returns nil.
+func (e *LockChurnFailure) Dependers() []Depender {
+	return nil
+}
+
+// With returns the selected atoms that have already been moved away from
+// their locked versions.
+func (e *LockChurnFailure) With() []Atom {
+	return toAtoms(e.with)
+}
+
+// Best returns the number of locked projects changed by the best solution
+// found so far.
+func (e *LockChurnFailure) Best() int {
+	return e.best
+}
+
+func (e *LockChurnFailure) Error() string {
+	return fmt.Sprintf("Could not introduce %s, as it would change at least as many locked projects as a solution that was already found (%v)", a2vs(e.goal), e.best)
+}
+
+func (e *LockChurnFailure) traceString() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s would move at least %v locked projects", a2vs(e.goal), e.best)
+	for k, a := range e.with {
+		if k == 0 {
+			fmt.Fprintf(&buf, ", with %s", a2vs(a))
+		} else {
+			fmt.Fprintf(&buf, ", %s", a2vs(a))
+		}
+	}
+
+	return buf.String()
+}
+
+// VersionExcludedFailure indicates that an atom was rejected because the root
+// manifest excludes its version, regardless of any constraints.
+type VersionExcludedFailure struct {
+	// goal is the atom that was rejected.
+	goal atom
+	// rule describes the exclusion that matched the atom's version.
+	rule string
+	// reason is the explanation given for the exclusion, if any.
+	reason string
+}
+
+// Goal returns the rejected atom.
+func (e *VersionExcludedFailure) Goal() Atom {
+	return toAtom(e.goal)
+}
+
+// Constraint returns nil.
+func (e *VersionExcludedFailure) Constraint() Constraint {
+	return nil
+}
+
+// Dependers returns nil.
+func (e *VersionExcludedFailure) Dependers() []Depender {
+	return nil
+}
+
+// Rule describes the exclusion that matched the goal's version.
+func (e *VersionExcludedFailure) Rule() string {
+	return e.rule
+}
+
+// Reason returns the explanation given for the exclusion, if any.
+func (e *VersionExcludedFailure) Reason() string {
+	return e.reason
+}
+
+func (e *VersionExcludedFailure) Error() string {
+	str := fmt.Sprintf("Could not introduce %s, as its version is excluded by policy in the root manifest (%s)", a2vs(e.goal), e.rule)
+	if e.reason != "" {
+		str += ": " + e.reason
+	}
+	return str
+}
+
+func (e *VersionExcludedFailure) traceString() string {
+	return fmt.Sprintf("%s excluded by %s", a2vs(e.goal), e.rule)
+}
+
+// PolicyVetoFailure indicates that the Policy given in SolveParameters vetoed
+// an atom, either for itself, or for the source of one of its dependencies.
+type PolicyVetoFailure struct {
+	// goal is the atom that was rejected.
+	goal atom
+	// dep is the dependency whose source was vetoed. It is the zero value if
+	// the atom itself was vetoed.
+	dep ProjectIdentifier
+	// reason is the error returned from the Policy.
+	reason error
+}
+
+// Goal returns the rejected atom.
+func (e *PolicyVetoFailure) Goal() Atom {
+	return toAtom(e.goal)
+}
+
+// Constraint returns nil.
+func (e *PolicyVetoFailure) Constraint() Constraint {
+	return nil
+}
+
+// Dependers returns nil.
+func (e *PolicyVetoFailure) Dependers() []Depender {
+	return nil
+}
+
+// Target returns the dependency whose source was vetoed. It is the zero value
+// if the goal itself was vetoed.
+func (e *PolicyVetoFailure) Target() ProjectIdentifier {
+	return e.dep
+}
+
+// Reason returns the error given by the Policy.
+func (e *PolicyVetoFailure) Reason() error {
+	return e.reason
+}
+
+func (e *PolicyVetoFailure) Error() string {
+	if e.dep.ProjectRoot == "" {
+		return fmt.Sprintf("Could not introduce %s, as it is disallowed by policy: %s", a2vs(e.goal), e.reason)
+	}
+	return fmt.Sprintf("Could not introduce %s, as policy disallows its dependency on %s: %s", a2vs(e.goal), e.dep.errString(), e.reason)
+}
+
+func (e *PolicyVetoFailure) traceString() string {
+	if e.dep.ProjectRoot == "" {
+		return fmt.Sprintf("%s vetoed by policy: %s", a2vs(e.goal), e.reason)
+	}
+	return fmt.Sprintf("source %s for dep of %s vetoed by policy: %s", e.dep.errString(), a2vs(e.goal), e.reason)
+}
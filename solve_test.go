@@ -2,6 +2,9 @@ package gps
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -11,7 +14,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 	"unicode"
 
 	"github.com/sdboyer/gps/internal"
@@ -114,22 +119,7 @@ func TestBasicSolves(t *testing.T) {
 func solveBasicsAndCheck(fix basicFixture, t *testing.T) (res Solution, err error) {
 	sm := newdepspecSM(fix.ds, nil)
 
-	params := SolveParameters{
-		RootDir:         string(fix.ds[0].n),
-		RootPackageTree: fix.rootTree(),
-		Manifest:        fix.rootmanifest(),
-		Lock:            dummyLock{},
-		Downgrade:       fix.downgrade,
-		ChangeAll:       fix.changeall,
-		ToChange:        fix.changelist,
-		ProjectAnalyzer: naiveAnalyzer{},
-	}
-
-	if fix.l != nil {
-		params.Lock = fix.l
-	}
-
-	res, err = fixSolve(params, sm, t)
+	res, err = fixSolve(fix.params(), sm, t)
 
 	return fixtureSolveSimpleChecks(fix, res, err, t)
 }
@@ -160,23 +150,7 @@ func TestBimodalSolves(t *testing.T) {
 }
 
 func solveBimodalAndCheck(fix bimodalFixture, t *testing.T) (res Solution, err error) {
-	sm := newbmSM(fix)
-
-	params := SolveParameters{
-		RootDir:         string(fix.ds[0].n),
-		RootPackageTree: fix.rootTree(),
-		Manifest:        fix.rootmanifest(),
-		Lock:            dummyLock{},
-		Downgrade:       fix.downgrade,
-		ChangeAll:       fix.changeall,
-		ProjectAnalyzer: naiveAnalyzer{},
-	}
-
-	if fix.l != nil {
-		params.Lock = fix.l
-	}
-
-	res, err = fixSolve(params, sm, t)
+	res, err = fixSolve(fix.params(), newbmSM(fix), t)
 
 	return fixtureSolveSimpleChecks(fix, res, err, t)
 }
@@ -434,7 +408,25 @@ func TestBadSolveOpts(t *testing.T) {
 		t.Error("Prepare should have given error on ToChange with item not present in Lock, but gave:", err)
 	}
 
+	params.MaxAttempts = -1
+	_, err = Prepare(params, sm)
+	if err == nil {
+		t.Errorf("Should have errored on negative MaxAttempts")
+	} else if !strings.Contains(err.Error(), "may not be negative") {
+		t.Error("Prepare should have given error on negative MaxAttempts, but gave:", err)
+	}
+	params.MaxAttempts = 0
+
+	params.ToChange, params.ChangeAll, params.Strategy = nil, true, MinimalLockChurn
+	_, err = Prepare(params, sm)
+	if err == nil {
+		t.Errorf("Should have errored on ChangeAll with minimal lock churn")
+	} else if !strings.Contains(err.Error(), "ChangeAll discards the whole lock") {
+		t.Error("Prepare should have given error on ChangeAll with minimal lock churn, but gave:", err)
+	}
+
 	params.Lock, params.ToChange = nil, nil
+	params.ChangeAll, params.Strategy = false, DefaultSolveStrategy
 	_, err = Prepare(params, sm)
 	if err != nil {
 		t.Error("Basic conditions satisfied, prepare should have completed successfully, err as:", err)
@@ -470,3 +462,1715 @@ func TestBadSolveOpts(t *testing.T) {
 	// swap them back...not sure if this matters, but just in case
 	overrideMkBridge()
 }
+
+// cancelingSM cancels a context once a set number of GetManifestAndLock calls
+// have been made against it.
+type cancelingSM struct {
+	*depspecSourceManager
+	after  int
+	calls  int
+	cancel context.CancelFunc
+}
+
+func (sm *cancelingSM) GetManifestAndLock(id ProjectIdentifier, v Version, an ProjectAnalyzer) (Manifest, Lock, error) {
+	sm.calls++
+	if sm.calls == sm.after {
+		sm.cancel()
+	}
+	return sm.depspecSourceManager.GetManifestAndLock(id, v, an)
+}
+
+func TestSolveContextCancel(t *testing.T) {
+	fix := basicFixtures["complex backtrack"]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sm := &cancelingSM{
+		depspecSourceManager: newdepspecSM(fix.ds, nil),
+		after:                20,
+		cancel:               cancel,
+	}
+
+	params := fix.params()
+
+	s, err := Prepare(params, sm)
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+
+	_, err = s.SolveContext(ctx)
+	if err == nil {
+		t.Fatal("Solve should have failed after context was canceled")
+	}
+
+	cerr, ok := err.(*SolveCanceledError)
+	if !ok {
+		t.Fatalf("Expected a *SolveCanceledError, got %T: %s", err, err)
+	}
+	if cerr.Err != context.Canceled {
+		t.Errorf("Expected context.Canceled as the underlying error, got %s", cerr.Err)
+	}
+	if cerr.Attempts == 0 {
+		t.Error("Expected at least one attempt to have been made before cancellation")
+	}
+
+	// The SourceManager must remain usable by a new solver after cancellation.
+	s, err = Prepare(params, sm.depspecSourceManager)
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing second solver: %s", err)
+	}
+	if _, err = s.Solve(); err != nil {
+		t.Errorf("Second solve failed unexpectedly: %s", err)
+	}
+}
+
+// ctxCancelingSM honors the context the solver passes with each call, and
+// cancels it once a set number of calls to getManifestAndLock have been made.
+type ctxCancelingSM struct {
+	*depspecSourceManager
+	after  int
+	calls  int
+	cancel context.CancelFunc
+}
+
+var _ ctxSourceManager = &ctxCancelingSM{}
+
+func (sm *ctxCancelingSM) getManifestAndLock(ctx context.Context, id ProjectIdentifier, v Version, an ProjectAnalyzer) (Manifest, Lock, error) {
+	sm.calls++
+	if sm.calls == sm.after {
+		sm.cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return sm.GetManifestAndLock(id, v, an)
+}
+
+func (sm *ctxCancelingSM) listPackages(ctx context.Context, id ProjectIdentifier, v Version) (pkgtree.PackageTree, error) {
+	if err := ctx.Err(); err != nil {
+		return pkgtree.PackageTree{}, err
+	}
+	return sm.ListPackages(id, v)
+}
+
+func (sm *ctxCancelingSM) listVersions(ctx context.Context, id ProjectIdentifier) ([]PairedVersion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return sm.ListVersions(id)
+}
+
+func (sm *ctxCancelingSM) revisionPresentIn(ctx context.Context, id ProjectIdentifier, r Revision) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return sm.RevisionPresentIn(id, r)
+}
+
+func (sm *ctxCancelingSM) sourceExists(ctx context.Context, id ProjectIdentifier) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return sm.SourceExists(id)
+}
+
+func (sm *ctxCancelingSM) syncSourceFor(ctx context.Context, id ProjectIdentifier) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return sm.SyncSourceFor(id)
+}
+
+func (sm *ctxCancelingSM) deduceProjectRoot(ctx context.Context, ip string) (ProjectRoot, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return sm.DeduceProjectRoot(ip)
+}
+
+// Canceling can land at any point in a run, including partway through
+// backtracking, and must always end the run cleanly.
+func TestSolveContextCancelAnywhere(t *testing.T) {
+	var names []string
+	for n := range basicFixtures {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		fix := basicFixtures[n]
+		for after := 1; after <= 20; after++ {
+			ctx, cancel := context.WithCancel(context.Background())
+			sm := &ctxCancelingSM{
+				depspecSourceManager: newdepspecSM(fix.ds, nil),
+				after:                after,
+				cancel:               cancel,
+			}
+
+			s, err := Prepare(fix.params(), sm)
+			if err != nil {
+				cancel()
+				continue
+			}
+			_, err = s.SolveContext(ctx)
+			if _, ok := err.(*SolveCanceledError); sm.calls >= after && err != nil && !ok {
+				t.Errorf("(fixture: %q, canceled after %v calls) Expected a *SolveCanceledError, got %T: %s", n, after, err, err)
+			}
+			cancel()
+		}
+	}
+}
+
+func TestMinimalLockChurnForcedChanges(t *testing.T) {
+	fix := basicFixtures["minimal churn moves fewest locked projects"]
+	res, err := solveBasicsAndCheck(fix, t)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	want := []ForcedChange{
+		{
+			Ident: mkPI("a"),
+			Old:   NewVersion("1.0.0"),
+			New:   NewVersion("2.0.0"),
+			Constraints: map[ProjectRoot]Constraint{
+				"c": mkSVC(">=2.0.0"),
+			},
+		},
+	}
+	if got := res.ForcedChanges(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected forced changes:\n\t(GOT): %#v\n\t(WNT): %#v", got, want)
+	}
+
+	// The default strategy settles for the first solution it finds, and
+	// doesn't report anything.
+	fix = basicFixtures["default strategy can move more locked projects than needed"]
+	res, err = solveBasicsAndCheck(fix, t)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if fcl := res.ForcedChanges(); fcl != nil {
+		t.Errorf("Expected no forced changes outside of minimal churn solving, got %v", fcl)
+	}
+}
+
+func TestSolutions(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a *", "b *"),
+			mkDepspec("a 1.0.0"),
+			mkDepspec("a 2.0.0"),
+			mkDepspec("b 1.0.0"),
+			mkDepspec("b 2.0.0", "a 2.0.0"),
+		},
+	}
+
+	params := fix.params()
+
+	collect := func(n int, score SolutionScorer) ([]string, error) {
+		s, err := Prepare(params, newdepspecSM(fix.ds, nil))
+		if err != nil {
+			t.Fatalf("Unexpected error while preparing solver: %s", err)
+		}
+
+		var got []string
+		it := s.Solutions(context.Background(), n, score)
+		for it.Next() {
+			var vl []string
+			for _, lp := range it.Solution().Projects() {
+				vl = append(vl, fmt.Sprintf("%s %s", lp.Ident().ProjectRoot, lp.Version()))
+			}
+			sort.Strings(vl)
+			got = append(got, strings.Join(vl, ", "))
+		}
+		return got, it.Err()
+	}
+
+	all := []string{
+		"a 2.0.0, b 2.0.0",
+		"a 2.0.0, b 1.0.0",
+		"a 1.0.0, b 1.0.0",
+	}
+
+	got, err := collect(0, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(got, all) {
+		t.Errorf("Unexpected solutions:\n\t(GOT): %v\n\t(WNT): %v", got, all)
+	}
+
+	got, err = collect(2, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(got, all[:2]) {
+		t.Errorf("Unexpected solutions with limit:\n\t(GOT): %v\n\t(WNT): %v", got, all[:2])
+	}
+
+	// Prefer solutions that keep a at 1.x; ties stay in the order found.
+	score := func(soln Solution) float64 {
+		for _, lp := range soln.Projects() {
+			if lp.Ident().ProjectRoot == "a" && lp.Version().Matches(NewVersion("1.0.0")) {
+				return 1
+			}
+		}
+		return 0
+	}
+	got, err = collect(0, score)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	want := []string{all[2], all[0], all[1]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected ranked solutions:\n\t(GOT): %v\n\t(WNT): %v", got, want)
+	}
+
+	// A limit keeps the best solutions of all those found, not the first ones.
+	got, err = collect(1, score)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("Unexpected ranked solutions with limit:\n\t(GOT): %v\n\t(WNT): %v", got, want[:1])
+	}
+
+	// A run canceled while the root's imports are being worked out still
+	// finishes properly.
+	bfix := bimodalFixture{
+		ds: []depspec{
+			dsp(mkDepspec("root 0.0.0"),
+				pkg("root", "a")),
+			dsp(mkDepspec("a 1.0.0"),
+				pkg("a")),
+		},
+	}
+	o := &recordingObserver{}
+	cparams := bfix.params()
+	cparams.Observer = o
+	sm := &ctxCancelingSM{depspecSourceManager: newdepspecSM(bfix.ds, nil), cancel: func() {}}
+	s, err := Prepare(cparams, sm)
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	it := s.Solutions(ctx, 0, nil)
+	if it.Next() {
+		t.Error("Expected no solutions from a canceled run")
+	}
+	if _, ok := it.Err().(*SolveCanceledError); !ok {
+		t.Errorf("Expected a *SolveCanceledError, got %T: %v", it.Err(), it.Err())
+	}
+	if len(o.finishs) != 1 {
+		t.Errorf("Expected the canceled run to finish once, got %v", len(o.finishs))
+	}
+
+	// With no solution at all, the iterator reports the solve failure.
+	fix.ds[0] = mkDepspec("root 0.0.0", "a 3.0.0")
+	params.Manifest = fix.rootmanifest()
+	got, err = collect(0, nil)
+	if len(got) != 0 {
+		t.Errorf("Expected no solutions, got %v", got)
+	}
+	if err == nil {
+		t.Error("Expected an error when no solution exists")
+	}
+}
+
+func TestSolveBudgets(t *testing.T) {
+	fix := basicFixtures["complex backtrack"]
+	params := fix.params()
+	params.MaxAttempts = 3
+
+	s, err := Prepare(params, newdepspecSM(fix.ds, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+
+	_, err = s.Solve()
+	berr, ok := err.(*BudgetExceededError)
+	if !ok {
+		t.Fatalf("Expected a *BudgetExceededError, got %T: %v", err, err)
+	}
+	if berr.Budget != "attempts" {
+		t.Errorf("Expected the attempts budget to be exceeded, got %q", berr.Budget)
+	}
+	if berr.Attempts != 3 {
+		t.Errorf("Expected solving to stop after 3 attempts, got %v", berr.Attempts)
+	}
+	if len(berr.Deepest) == 0 {
+		t.Error("Expected a partial solution to be reported")
+	}
+	if len(berr.Failures) == 0 {
+		t.Error("Expected failure reasons to be reported")
+	}
+	if berr.Metrics.Attempts != 3 {
+		t.Errorf("Expected metrics to report 3 attempts, got %v", berr.Metrics.Attempts)
+	}
+	for k := 1; k < len(berr.Failures); k++ {
+		if berr.Failures[k].Count > berr.Failures[k-1].Count {
+			t.Errorf("Failures not sorted by frequency: %v", berr.Failures)
+			break
+		}
+	}
+
+	params.MaxAttempts, params.MaxDuration = 0, time.Nanosecond
+	s, err = Prepare(params, newdepspecSM(fix.ds, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+
+	_, err = s.Solve()
+	berr, ok = err.(*BudgetExceededError)
+	if !ok {
+		t.Fatalf("Expected a *BudgetExceededError, got %T: %v", err, err)
+	}
+	if berr.Budget != "duration" {
+		t.Errorf("Expected the duration budget to be exceeded, got %q", berr.Budget)
+	}
+
+	// With room to finish, the budgets have no effect.
+	params.MaxAttempts, params.MaxDuration = 100, time.Minute
+	res, err := fixSolve(params, newdepspecSM(fix.ds, nil), t)
+	fixtureSolveSimpleChecks(fix, res, err, t)
+}
+
+func TestSolveMetrics(t *testing.T) {
+	fix := basicFixtures["complex backtrack"]
+	params := fix.params()
+
+	s, err := Prepare(params, newdepspecSM(fix.ds, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+	if m := s.Metrics(); m.Attempts != 0 || m.Phases != nil {
+		t.Errorf("Expected no metrics before solving, got %+v", m)
+	}
+
+	soln, err := s.Solve()
+	if err != nil {
+		t.Fatalf("Unexpected error while solving: %s", err)
+	}
+
+	m := soln.Metrics()
+	if m.Attempts != soln.Attempts() {
+		t.Errorf("Metrics report %v attempts, but the solution %v", m.Attempts, soln.Attempts())
+	}
+	if m.Attempts == 0 {
+		t.Error("Expected the fixture to require backtracking")
+	}
+	if m.QueueAdvances == 0 {
+		t.Error("Expected version queue advances to be counted")
+	}
+	if len(m.Backtracks) == 0 {
+		t.Error("Expected backtracks to be counted by project")
+	}
+	if m.Phases["select-atom"] == 0 || m.Phases["satisfy"] == 0 {
+		t.Errorf("Expected time to be recorded for solving phases, got %v", m.Phases)
+	}
+	var total time.Duration
+	for _, d := range m.Phases {
+		total += d
+	}
+	if total != m.Total {
+		t.Errorf("Total %s is not the sum of the phases, %s", m.Total, total)
+	}
+	if !reflect.DeepEqual(s.Metrics(), m) {
+		t.Errorf("Solver metrics differ from the solution's:\n\t(SLV): %+v\n\t(SOL): %+v", s.Metrics(), m)
+	}
+
+	// A failed run reports its metrics through the solver.
+	fix = basicFixtures["no version that matches requirement"]
+	params.RootPackageTree, params.Manifest = fix.rootTree(), fix.rootmanifest()
+	s, err = Prepare(params, newdepspecSM(fix.ds, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+	if _, err = s.Solve(); err == nil {
+		t.Fatal("Expected solving to fail")
+	}
+	if m := s.Metrics(); m.Phases["select-root"] == 0 {
+		t.Errorf("Expected metrics for the failed run, got %+v", m)
+	}
+
+	// As does the error, if it's an UnsolvableError.
+	params.SuggestFixes = true
+	s, err = Prepare(params, newdepspecSM(fix.ds, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+	_, err = s.Solve()
+	ue, ok := err.(*UnsolvableError)
+	if !ok {
+		t.Fatalf("Expected an *UnsolvableError, got %T: %s", err, err)
+	}
+	if !reflect.DeepEqual(ue.Metrics, s.Metrics()) {
+		t.Errorf("Error metrics differ from the solver's:\n\t(ERR): %+v\n\t(SLV): %+v", ue.Metrics, s.Metrics())
+	}
+}
+
+// slowSM adds a delay to the calls the solver makes against a
+// depspecSourceManager, and records the most calls that were ever in flight at
+// once.
+type slowSM struct {
+	*depspecSourceManager
+	delay time.Duration
+
+	mu       sync.Mutex
+	cur, max int
+}
+
+func (sm *slowSM) enter() {
+	sm.mu.Lock()
+	sm.cur++
+	if sm.cur > sm.max {
+		sm.max = sm.cur
+	}
+	sm.mu.Unlock()
+
+	time.Sleep(sm.delay)
+
+	sm.mu.Lock()
+	sm.cur--
+	sm.mu.Unlock()
+}
+
+func (sm *slowSM) maxInFlight() int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.max
+}
+
+func (sm *slowSM) ListVersions(id ProjectIdentifier) ([]PairedVersion, error) {
+	sm.enter()
+	return sm.depspecSourceManager.ListVersions(id)
+}
+
+func (sm *slowSM) GetManifestAndLock(id ProjectIdentifier, v Version, an ProjectAnalyzer) (Manifest, Lock, error) {
+	sm.enter()
+	return sm.depspecSourceManager.GetManifestAndLock(id, v, an)
+}
+
+func (sm *slowSM) ListPackages(id ProjectIdentifier, v Version) (pkgtree.PackageTree, error) {
+	sm.enter()
+	return sm.depspecSourceManager.ListPackages(id, v)
+}
+
+func TestPrefetch(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a *", "b *", "c *", "d *", "e *"),
+			mkDepspec("a 1.0.0"),
+			mkDepspec("a 2.0.0"),
+			mkDepspec("b 1.0.0"),
+			mkDepspec("c 1.0.0"),
+			mkDepspec("d 1.0.0", "a 1.0.0"),
+			mkDepspec("e 1.0.0"),
+		},
+		r: mksolution(
+			"a 1.0.0",
+			"b 1.0.0",
+			"c 1.0.0",
+			"d 1.0.0",
+			"e 1.0.0",
+		),
+	}
+
+	for _, max := range []int{-1, 2} {
+		sm := &slowSM{
+			depspecSourceManager: newdepspecSM(fix.ds, nil),
+			delay:                2 * time.Millisecond,
+		}
+		params := fix.params()
+		params.MaxPrefetch = max
+
+		res, err := fixSolve(params, sm, t)
+		fixtureSolveSimpleChecks(fix, res, err, t)
+
+		// Prefetching is bounded, but the solver's own calls aren't counted
+		// against that bound.
+		got := sm.maxInFlight()
+		switch {
+		case max < 0 && got != 1:
+			t.Errorf("Expected no concurrent calls with prefetching disabled, saw %v", got)
+		case max > 0 && got > max+1:
+			t.Errorf("Expected at most %v concurrent calls, saw %v", max+1, got)
+		case max > 0 && got < 2:
+			t.Errorf("Expected prefetching to run calls concurrently, saw only %v at once", got)
+		}
+	}
+}
+
+// mostDependersFirst is an example SelectionHeuristic that selects the
+// projects with the most dependers first, falling back on the default order.
+type mostDependersFirst struct {
+	calls int
+}
+
+func (h *mostDependersFirst) Less(st SelectionState, i, j SelectionCandidate) bool {
+	h.calls++
+	if i.Selected == j.Selected && !i.Ident.eq(j.Ident) {
+		if id, jd := st.Dependers(i.Ident), st.Dependers(j.Ident); id != jd {
+			return id > jd
+		}
+	}
+	return DefaultSelectionHeuristic{}.Less(st, i, j)
+}
+
+// reverseDefaultOrder is a SelectionHeuristic that selects candidates in the
+// opposite of the default order.
+type reverseDefaultOrder struct{}
+
+func (reverseDefaultOrder) Less(st SelectionState, i, j SelectionCandidate) bool {
+	return DefaultSelectionHeuristic{}.Less(st, j, i)
+}
+
+func TestSelectionHeuristic(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a *", "b *", "c *"),
+			mkDepspec("a 1.0.0", "c 1.0.0"),
+			mkDepspec("b 1.0.0", "c 1.0.0"),
+			mkDepspec("c 1.0.0"),
+			mkDepspec("c 2.0.0"),
+		},
+		r: mksolution(
+			"a 1.0.0",
+			"b 1.0.0",
+			"c 1.0.0",
+		),
+	}
+
+	table := []struct {
+		name string
+		h    SelectionHeuristic
+		// The projects selected, in order, including any selections that
+		// were later undone by backtracking.
+		order []string
+	}{
+		{
+			name:  "default",
+			h:     DefaultSelectionHeuristic{},
+			order: []string{"a", "b", "c"},
+		},
+		{
+			// Taking c first, and so its newest version, runs into the
+			// constraints from a and b, and has to backtrack.
+			name:  "reversed",
+			h:     reverseDefaultOrder{},
+			order: []string{"c", "c", "b", "a"},
+		},
+	}
+
+	for _, tc := range table {
+		o := &recordingObserver{}
+		params := fix.params()
+		params.SelectionHeuristic = tc.h
+		params.Observer = o
+
+		res, err := fixSolve(params, newdepspecSM(fix.ds, nil), t)
+		fixtureSolveSimpleChecks(fix, res, err, t)
+
+		var order []string
+		for _, ev := range o.sels {
+			if !ev.PkgOnly {
+				order = append(order, string(ev.Ident.ProjectRoot))
+			}
+		}
+		if !reflect.DeepEqual(order, tc.order) {
+			t.Errorf("(heuristic: %s) Unexpected selection order:\n\t(GOT): %v\n\t(WNT): %v", tc.name, order, tc.order)
+		}
+	}
+
+	h := &mostDependersFirst{}
+	params := fix.params()
+	params.SelectionHeuristic = h
+
+	res, err := fixSolve(params, newdepspecSM(fix.ds, nil), t)
+	fixtureSolveSimpleChecks(fix, res, err, t)
+	if h.calls == 0 {
+		t.Error("Custom selection heuristic was never consulted")
+	}
+}
+
+// BenchmarkSelectionHeuristics runs all the basic fixtures under each of a set
+// of selection heuristics, for comparison.
+func BenchmarkSelectionHeuristics(b *testing.B) {
+	heuristics := map[string]func() SelectionHeuristic{
+		"default":        func() SelectionHeuristic { return DefaultSelectionHeuristic{} },
+		"most-dependers": func() SelectionHeuristic { return &mostDependersFirst{} },
+	}
+
+	var names []string
+	for n := range basicFixtures {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for hn, mk := range heuristics {
+		b.Run(hn, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, n := range names {
+					fix := basicFixtures[n]
+					params := fix.params()
+					params.SelectionHeuristic = mk()
+
+					s, err := Prepare(params, newdepspecSM(fix.ds, nil))
+					if err != nil {
+						continue
+					}
+					s.Solve()
+				}
+			}
+		})
+	}
+}
+
+func TestSolveFrom(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a *", "b *", "c *"),
+			mkDepspec("a 1.0.0", "d *"),
+			mkDepspec("b 1.0.0"),
+			mkDepspec("c 1.0.0"),
+			mkDepspec("c 2.0.0"),
+			mkDepspec("d 1.0.0"),
+		},
+	}
+	sm := newdepspecSM(fix.ds, nil)
+
+	var obs *recordingObserver
+	solve := func(prev Solution) (Solution, *solver) {
+		obs = &recordingObserver{}
+		params := fix.params()
+		params.Observer = obs
+		s, err := Prepare(params, sm)
+		if err != nil {
+			t.Fatalf("Unexpected error while preparing solver: %s", err)
+		}
+
+		var soln Solution
+		if prev == nil {
+			soln, err = s.Solve()
+		} else {
+			soln, err = s.SolveFrom(context.Background(), prev)
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error while solving: %s", err)
+		}
+		return soln, s.(*solver)
+	}
+
+	prev, _ := solve(nil)
+
+	// Tighten the constraint on c, which should leave everything selected
+	// before c untouched.
+	fix.ds[0] = mkDepspec("root 0.0.0", "a *", "b *", "c 1.0.0")
+	fix.r = mksolution(
+		"a 1.0.0",
+		"b 1.0.0",
+		"c 1.0.0",
+		"d 1.0.0",
+	)
+
+	cold, _ := solve(nil)
+	warm, s := solve(prev)
+	fixtureSolveSimpleChecks(fix, cold, nil, t)
+	fixtureSolveSimpleChecks(fix, warm, nil, t)
+
+	if s.replayed == 0 {
+		t.Error("Expected the warm-started solve to replay some selections")
+	}
+	for _, ts := range s.trail[:s.replayed] {
+		if ts.a.id.ProjectRoot == "c" {
+			t.Error("The selection of c should not have been replayed, as its constraint changed")
+		}
+	}
+
+	// Unchanged inputs allow the whole run to be replayed.
+	again, s := solve(warm)
+	fixtureSolveSimpleChecks(fix, again, nil, t)
+	if s.replayed != len(s.trail) {
+		t.Errorf("Expected all %v selections to be replayed, but %v were", len(s.trail), s.replayed)
+	}
+	for _, ev := range obs.tries {
+		if ev.Ident.ProjectRoot != "c" {
+			t.Errorf("Expected the warm-started solve to resume at c, but it tried %s", ev.Ident)
+		}
+	}
+
+	// A change to the imports can't be narrowed down, so nothing is replayed.
+	fix.ds[0] = mkDepspec("root 0.0.0", "a *", "b *")
+	fix.r = mksolution(
+		"a 1.0.0",
+		"b 1.0.0",
+		"d 1.0.0",
+	)
+	fewer, s := solve(again)
+	fixtureSolveSimpleChecks(fix, fewer, nil, t)
+	if s.replayed != 0 {
+		t.Errorf("Expected no selections to be replayed after imports changed, but %v were", s.replayed)
+	}
+}
+
+// A Policy isn't part of the inputs, so selections can't be replayed when one
+// is in effect; they might have been vetoed.
+func TestSolveFromPolicy(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a *"),
+			mkDepspec("a 1.0.0"),
+			mkDepspec("a 2.0.0"),
+		},
+	}
+	sm := newdepspecSM(fix.ds, nil)
+	params := fix.params()
+	pol := denyPolicy{
+		vers: map[string]bool{"a 2.0.0": true},
+	}
+
+	solve := func(pol Policy, prev Solution) (Solution, *solver) {
+		params.Policy = pol
+		s, err := Prepare(params, sm)
+		if err != nil {
+			t.Fatalf("Unexpected error while preparing solver: %s", err)
+		}
+		soln, err := s.SolveFrom(context.Background(), prev)
+		if err != nil {
+			t.Fatalf("Unexpected error while solving: %s", err)
+		}
+		return soln, s.(*solver)
+	}
+
+	prev, _ := solve(nil, nil)
+	fix.r = mksolution("a 1.0.0")
+	warm, s := solve(pol, prev)
+	fixtureSolveSimpleChecks(fix, warm, nil, t)
+	if s.replayed != 0 {
+		t.Errorf("Expected no selections to be replayed under a policy, but %v were", s.replayed)
+	}
+
+	fix.r = mksolution("a 2.0.0")
+	warm, s = solve(nil, warm)
+	fixtureSolveSimpleChecks(fix, warm, nil, t)
+	if s.replayed != 0 {
+		t.Errorf("Expected no selections to be replayed from a solve under a policy, but %v were", s.replayed)
+	}
+}
+
+// Looking for less churn carries on searching after a solution is found, but
+// the trail kept with the best solution must still be one that a later run
+// can follow.
+func TestSolveFromChurn(t *testing.T) {
+	fix := basicFixtures["minimal churn moves fewest locked projects"]
+	params := fix.params()
+
+	s, err := Prepare(params, newdepspecSM(fix.ds, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+	prev, err := s.Solve()
+	fixtureSolveSimpleChecks(fix, prev, err, t)
+	trail := prev.(solution).ws.trail
+	if len(trail) == 0 {
+		t.Fatal("Expected the solution to carry the trail of its run")
+	}
+
+	s, _ = Prepare(params, newdepspecSM(fix.ds, nil))
+	warm, err := s.SolveFrom(context.Background(), prev)
+	fixtureSolveSimpleChecks(fix, warm, err, t)
+	if ss := s.(*solver); ss.replayed != len(trail) {
+		t.Errorf("Expected all %v selections on the trail to be replayed, but %v were", len(trail), ss.replayed)
+	}
+}
+
+// Warm-starting from a solution to the same inputs must always give the same
+// result as a cold solve.
+func TestSolveFromBasicFixtures(t *testing.T) {
+	var names []string
+	for n := range basicFixtures {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		fix := basicFixtures[n]
+		params := fix.params()
+
+		s, err := Prepare(params, newdepspecSM(fix.ds, nil))
+		if err != nil {
+			continue
+		}
+		prev, err := s.Solve()
+		if err != nil {
+			continue
+		}
+
+		s, _ = Prepare(params, newdepspecSM(fix.ds, nil))
+		warm, err := s.SolveFrom(context.Background(), prev)
+		if err != nil {
+			t.Errorf("(fixture: %q) Warm-started solve failed: %s", n, err)
+			continue
+		}
+		if solutionKey(prev.(solution)) != solutionKey(warm.(solution)) {
+			t.Errorf("(fixture: %q) Warm-started solve differs from cold:\n\t(GOT): %s\n\t(WNT): %s", n, solutionKey(warm.(solution)), solutionKey(prev.(solution)))
+		}
+	}
+}
+
+// The same goes for the bimodal fixtures, which also replay selections of
+// additional packages from projects that are already selected.
+func TestSolveFromBimodalFixtures(t *testing.T) {
+	var names []string
+	for n := range bimodalFixtures {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		fix := bimodalFixtures[n]
+		s, err := Prepare(fix.params(), newbmSM(fix))
+		if err != nil {
+			continue
+		}
+		prev, err := s.Solve()
+		if err != nil {
+			continue
+		}
+
+		s, _ = Prepare(fix.params(), newbmSM(fix))
+		warm, err := s.SolveFrom(context.Background(), prev)
+		if err != nil {
+			t.Errorf("(fixture: %q) Warm-started solve failed: %s", n, err)
+			continue
+		}
+		if solutionKey(prev.(solution)) != solutionKey(warm.(solution)) {
+			t.Errorf("(fixture: %q) Warm-started solve differs from cold:\n\t(GOT): %s\n\t(WNT): %s", n, solutionKey(warm.(solution)), solutionKey(prev.(solution)))
+		}
+		if ss := s.(*solver); ss.replayed != len(prev.(solution).ws.trail) {
+			t.Errorf("(fixture: %q) Expected all %v selections on the trail to be replayed, but %v were", n, len(prev.(solution).ws.trail), ss.replayed)
+		}
+	}
+}
+
+func TestWorkspaceSolve(t *testing.T) {
+	main := bimodalFixture{
+		ds: []depspec{
+			dsp(mkDepspec("root 0.0.0", "a *"),
+				pkg("root", "a", "ws")),
+		},
+	}
+	ws := bimodalFixture{
+		ds: []depspec{
+			dsp(mkDepspec("ws 0.0.0", "a <2.0.0"),
+				pkg("ws", "a", "root"),
+				pkg("ws/ign", "b")),
+		},
+		ignore:  []string{"ws/ign"},
+		require: []string{"c"},
+	}
+	fix := bimodalFixture{
+		ds: []depspec{
+			main.ds[0],
+			ws.ds[0],
+			dsp(mkDepspec("a 1.0.0"),
+				pkg("a")),
+			dsp(mkDepspec("a 2.0.0"),
+				pkg("a")),
+			dsp(mkDepspec("b 1.0.0"),
+				pkg("b")),
+			dsp(mkDepspec("c 1.0.0"),
+				pkg("c")),
+		},
+		r: mksolution(
+			"a 1.0.0",
+			"c 1.0.0",
+		),
+	}
+	sm := newbmSM(fix)
+
+	params := main.params()
+	params.Workspace = []WorkspaceRoot{
+		{
+			RootPackageTree: ws.rootTree(),
+			Manifest:        ws.rootmanifest(),
+		},
+	}
+
+	// Both roots' constraints on a apply, neither root is fetched, ws's ignore
+	// keeps b out, and its require brings c in.
+	soln, err := fixSolve(params, sm, t)
+	fixtureSolveSimpleChecks(fix, soln, err, t)
+
+	s, err := Prepare(params, sm)
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+	if soln != nil && !bytes.Equal(soln.InputHash(), s.HashInputs()) {
+		t.Error("Solution hash should match the hash of the workspace inputs")
+	}
+
+	// The workspace roots are part of the hash.
+	single := params
+	single.Workspace = nil
+	s, err = Prepare(single, sm)
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+	if soln != nil && bytes.Equal(soln.InputHash(), s.HashInputs()) {
+		t.Error("Hash should differ when the workspace roots are removed")
+	}
+
+	// Conflicting constraints from different roots leave no solution.
+	conflict := params
+	conflict.Manifest = bimodalFixture{
+		ds: []depspec{
+			dsp(mkDepspec("root 0.0.0", "a >=2.0.0"),
+				pkg("root", "a", "ws")),
+		},
+	}.rootmanifest()
+	if _, err = fixSolve(conflict, sm, t); err == nil {
+		t.Error("Expected the roots' disjoint constraints on a to cause a failure")
+	} else if _, ok := err.(*DisjointConstraintFailure); !ok {
+		t.Errorf("Expected a DisjointConstraintFailure, got %T: %s", err, err)
+	}
+
+	// Roots may not overlap.
+	overlap := params
+	overlap.Workspace = []WorkspaceRoot{
+		{
+			RootPackageTree: pkgtree.PackageTree{
+				ImportRoot: "root/sub",
+				Packages:   main.rootTree().Packages,
+			},
+		},
+	}
+	if _, err = Prepare(overlap, sm); err == nil {
+		t.Error("Prepare should have errored on overlapping workspace roots")
+	} else if !strings.Contains(err.Error(), "overlaps with root root") {
+		t.Error("Prepare should have given error on overlapping workspace roots, but gave:", err)
+	}
+}
+
+// testImportsSM reports each dependency's test-only constraints as test
+// imports of its root package.
+type testImportsSM struct {
+	*depspecSourceManager
+}
+
+func (sm testImportsSM) ListPackages(id ProjectIdentifier, v Version) (pkgtree.PackageTree, error) {
+	ptree, err := sm.depspecSourceManager.ListPackages(id, v)
+	if err != nil {
+		return ptree, err
+	}
+
+	for _, ds := range sm.specs[1:] {
+		if id.normalizedSource() == string(ds.n) && v.Matches(ds.v) {
+			poe := ptree.Packages[string(ds.n)]
+			for _, dep := range ds.devdeps {
+				poe.P.TestImports = append(poe.P.TestImports, string(dep.Ident.ProjectRoot))
+			}
+			ptree.Packages[string(ds.n)] = poe
+		}
+	}
+	return ptree, nil
+}
+
+func TestDependencyTests(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a *", "b *"),
+			mkDepspec("a 1.0.0", "(dev) t <2.0.0"),
+			mkDepspec("b 1.0.0", "(dev) u *"),
+			mkDepspec("t 1.0.0"),
+			mkDepspec("t 2.0.0"),
+			mkDepspec("u 1.0.0"),
+		},
+		r: mksolution(
+			"a 1.0.0",
+			"b 1.0.0",
+		),
+	}
+	sm := testImportsSM{newdepspecSM(fix.ds, nil)}
+
+	params := fix.params()
+
+	// By default, dependencies' tests are left out entirely.
+	soln, err := fixSolve(params, sm, t)
+	fixtureSolveSimpleChecks(fix, soln, err, t)
+	var hashes [][]byte
+	if soln != nil {
+		hashes = append(hashes, soln.InputHash())
+	}
+
+	// Tests of just a bring in t, respecting a's test constraint on it.
+	params.DependencyTests = []ProjectRoot{"a"}
+	fix.r = mksolution(
+		"a 1.0.0",
+		"b 1.0.0",
+		"t 1.0.0",
+	)
+	soln, err = fixSolve(params, sm, t)
+	fixtureSolveSimpleChecks(fix, soln, err, t)
+	if soln != nil {
+		hashes = append(hashes, soln.InputHash())
+	}
+
+	params.AllDependencyTests = true
+	fix.r = mksolution(
+		"a 1.0.0",
+		"b 1.0.0",
+		"t 1.0.0",
+		"u 1.0.0",
+	)
+	soln, err = fixSolve(params, sm, t)
+	fixtureSolveSimpleChecks(fix, soln, err, t)
+	if soln != nil {
+		hashes = append(hashes, soln.InputHash())
+	}
+
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			if bytes.Equal(hashes[i], hashes[j]) {
+				t.Errorf("Expected input hashes %v and %v to differ", i, j)
+			}
+		}
+	}
+}
+
+type excludingManifest struct {
+	simpleRootManifest
+	ex map[ProjectRoot]VersionExclusion
+}
+
+func (m excludingManifest) ExcludedVersions() map[ProjectRoot]VersionExclusion {
+	return m.ex
+}
+
+func TestVersionExclusions(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a *"),
+			mkDepspec("a 1.0.0"),
+			mkDepspec("a 1.1.0"),
+			mkDepspec("a 1.2.0"),
+		},
+		r: mksolution(
+			"a 1.1.0",
+		),
+	}
+	sm := newdepspecSM(fix.ds, nil)
+
+	m := excludingManifest{
+		simpleRootManifest: fix.rootmanifest().(simpleRootManifest),
+		ex: map[ProjectRoot]VersionExclusion{
+			"a": {
+				Versions: []Version{NewVersion("1.2.0")},
+				Reason:   "corrupts data",
+			},
+		},
+	}
+	params := fix.params()
+	params.Manifest = m
+
+	soln, err := fixSolve(params, sm, t)
+	fixtureSolveSimpleChecks(fix, soln, err, t)
+
+	s, err := Prepare(params, sm)
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+	unexcluded := params
+	unexcluded.Manifest = m.simpleRootManifest
+	us, err := Prepare(unexcluded, sm)
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+	if bytes.Equal(s.HashInputs(), us.HashInputs()) {
+		t.Error("Exclusions should be incorporated in the input hash")
+	}
+
+	// With every version excluded, the failure says why.
+	m.ex["a"] = VersionExclusion{
+		Patterns: []string{"1.*"},
+		Reason:   "corrupts data",
+	}
+	_, err = fixSolve(params, sm, t)
+	if err == nil {
+		t.Fatal("Expected solving to fail with all versions of a excluded")
+	}
+	if !strings.Contains(err.Error(), "excluded by policy") || !strings.Contains(err.Error(), "corrupts data") {
+		t.Errorf("Expected failure to explain the exclusion, got: %s", err)
+	}
+
+	m.ex["a"] = VersionExclusion{
+		Patterns: []string{"[1.*"},
+	}
+	if _, err = Prepare(params, sm); err == nil {
+		t.Error("Prepare should have errored on an invalid exclusion pattern")
+	}
+}
+
+func TestVersionExclusionMatching(t *testing.T) {
+	rev := Revision("abc123")
+	v := NewVersion("1.4.3")
+	ve := VersionExclusion{
+		Versions: []Version{rev, NewVersion("2.0.0").Is("def456")},
+		Patterns: []string{"*-rc*"},
+	}
+
+	table := []struct {
+		v    Version
+		rule string
+	}{
+		{v: v},
+		{v: v.Is(rev), rule: "revision abc123"},
+		{v: rev, rule: "revision abc123"},
+		{v: NewVersion("2.0.0"), rule: "version 2.0.0"},
+		{v: NewVersion("2.0.0").Is("fff"), rule: "version 2.0.0"},
+		{v: NewVersion("1.5.0-rc1"), rule: `pattern "*-rc*"`},
+		{v: NewBranch("master-rc").Is("fff"), rule: `pattern "*-rc*"`},
+		{v: Revision("def456")},
+	}
+
+	for _, fix := range table {
+		rule, excluded := ve.excludes(fix.v)
+		if excluded != (fix.rule != "") || rule != fix.rule {
+			t.Errorf("Unexpected exclusion of %s: got %q, wanted %q", fix.v, rule, fix.rule)
+		}
+	}
+}
+
+// denyPolicy vetoes the listed projects, and the listed versions of projects.
+type denyPolicy struct {
+	srcs map[ProjectRoot]bool
+	vers map[string]bool
+}
+
+func (p denyPolicy) CheckSource(id ProjectIdentifier) error {
+	if p.srcs[id.ProjectRoot] {
+		return fmt.Errorf("%s is blocked", id.ProjectRoot)
+	}
+	return nil
+}
+
+func (p denyPolicy) CheckVersion(id ProjectIdentifier, v Version) error {
+	if p.vers[string(id.ProjectRoot)+" "+v.String()] {
+		return fmt.Errorf("%s %s has a known vulnerability", id.ProjectRoot, v)
+	}
+	return nil
+}
+
+func TestPolicy(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a *"),
+			mkDepspec("a 1.0.0", "b *"),
+			mkDepspec("a 2.0.0", "bad *"),
+			mkDepspec("b 1.0.0"),
+			mkDepspec("b 1.1.0"),
+			mkDepspec("bad 1.0.0"),
+		},
+		r: mksolution(
+			"a 1.0.0",
+			"b 1.0.0",
+		),
+	}
+	sm := newdepspecSM(fix.ds, nil)
+
+	params := fix.params()
+	params.Policy = denyPolicy{
+		srcs: map[ProjectRoot]bool{"bad": true},
+		vers: map[string]bool{"b 1.1.0": true},
+	}
+
+	// The solver works its way around both kinds of veto.
+	soln, err := fixSolve(params, sm, t)
+	fixtureSolveSimpleChecks(fix, soln, err, t)
+
+	// There's no way around a veto on one of the root's own deps.
+	params.Policy = denyPolicy{
+		srcs: map[ProjectRoot]bool{"a": true},
+	}
+	_, err = fixSolve(params, sm, t)
+	if _, ok := err.(*PolicyVetoFailure); !ok {
+		t.Errorf("Expected a PolicyVetoFailure, got %T: %v", err, err)
+	}
+
+	// With every version vetoed, the failure gives the policy's reasons.
+	params.Policy = denyPolicy{
+		vers: map[string]bool{"a 1.0.0": true, "a 2.0.0": true},
+	}
+	_, err = fixSolve(params, sm, t)
+	if err == nil {
+		t.Fatal("Expected solving to fail with all versions of a vetoed")
+	}
+	if !strings.Contains(err.Error(), "a 1.0.0 has a known vulnerability") {
+		t.Errorf("Expected failure to give the policy's reason, got: %s", err)
+	}
+}
+
+func TestSearchTree(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a *", "b *"),
+			mkDepspec("a 1.0.0", "c 1.0.0"),
+			mkDepspec("a 2.0.0", "c 2.0.0"),
+			mkDepspec("b 1.0.0", "c 1.0.0"),
+			mkDepspec("b 1.1.0", "c 1.0.0"),
+			mkDepspec("c 1.0.0"),
+			mkDepspec("c 2.0.0"),
+		},
+		r: mksolution(
+			"a 1.0.0",
+			"b 1.1.0",
+			"c 1.0.0",
+		),
+	}
+
+	tree := NewSearchTree()
+	params := fix.params()
+	params.Observer = tree
+	soln, err := fixSolve(params, newdepspecSM(fix.ds, nil), t)
+	fixtureSolveSimpleChecks(fix, soln, err, t)
+
+	root := tree.Root()
+	if root == nil || !root.Root || root.Ident.ProjectRoot != "root" {
+		t.Fatalf("Expected the tree to start at the root project, got %+v", root)
+	}
+
+	var fails, backs int
+	insoln := make(map[string]bool)
+	root.walk(func(n *SearchNode) {
+		if n.Failure != "" {
+			fails++
+		}
+		if n.Backtrack != "" {
+			backs++
+		}
+		if n.Solution && !n.Root {
+			insoln[fmt.Sprintf("%s %s", n.Ident.ProjectRoot, n.Version)] = true
+		}
+	})
+	if fails == 0 {
+		t.Error("Expected failed versions in the tree")
+	}
+	if backs == 0 {
+		t.Error("Expected a backtracked selection in the tree")
+	}
+	want := map[string]bool{"a 1.0.0": true, "b 1.1.0": true, "c 1.0.0": true}
+	if !reflect.DeepEqual(insoln, want) {
+		t.Errorf("Unexpected solution path in tree:\n\t(GOT): %v\n\t(WNT): %v", insoln, want)
+	}
+
+	var buf bytes.Buffer
+	if err = tree.WriteDOT(&buf); err != nil {
+		t.Fatalf("Unexpected error writing DOT: %s", err)
+	}
+	dot := buf.String()
+	if !strings.HasPrefix(dot, "digraph search {") || !strings.Contains(dot, "color=red") || !strings.Contains(dot, "style=bold") {
+		t.Errorf("Unexpected DOT output:\n%s", dot)
+	}
+
+	buf.Reset()
+	if err = tree.WriteJSON(&buf); err != nil {
+		t.Fatalf("Unexpected error writing JSON: %s", err)
+	}
+	var jn jsonSearchNode
+	if err = json.Unmarshal(buf.Bytes(), &jn); err != nil {
+		t.Fatalf("Unexpected error decoding JSON: %s", err)
+	}
+	if jn.Ident.Root != "root" || len(jn.Children) != len(root.Children) {
+		t.Errorf("JSON tree does not match:\n%s", buf.String())
+	}
+}
+
+func TestSolveFailureJSON(t *testing.T) {
+	fix := basicFixtures["no version that matches requirement"]
+	params := fix.params()
+	_, err := fixSolve(params, newdepspecSM(fix.ds, nil), t)
+
+	nverr, ok := err.(*NoVersionError)
+	if !ok {
+		t.Fatalf("Expected a *NoVersionError, got %T: %v", err, err)
+	}
+	if goal := nverr.Goal(); goal.Ident != mkPI("foo") || goal.Version != nil {
+		t.Errorf("Unexpected goal %s", goal)
+	}
+
+	fails := nverr.Failures()
+	if len(fails) != 2 {
+		t.Fatalf("Expected two failed versions, got %v", fails)
+	}
+	vnaf, ok := fails[0].Err.(*VersionNotAllowedFailure)
+	if !ok {
+		t.Fatalf("Expected a *VersionNotAllowedFailure, got %T: %v", fails[0].Err, fails[0].Err)
+	}
+	if goal := vnaf.Goal(); goal.String() != "foo@2.1.3" {
+		t.Errorf("Unexpected goal %s", goal)
+	}
+	deps := vnaf.Dependers()
+	if len(deps) != 1 || deps[0].Ident.ProjectRoot != "root" || deps[0].Version != nil || !deps[0].Conflicts {
+		t.Errorf("Unexpected dependers %+v", deps)
+	}
+	if deps[0].Constraint.String() != "^1.0.0" {
+		t.Errorf("Expected the root's constraint, got %s", deps[0].Constraint)
+	}
+
+	b, err := json.Marshal(err)
+	if err != nil {
+		t.Fatalf("Unexpected error encoding failure: %s", err)
+	}
+	var jf jsonFailure
+	if err = json.Unmarshal(b, &jf); err != nil {
+		t.Fatalf("Unexpected error decoding failure: %s", err)
+	}
+	if jf.Kind != "no-versions" || jf.Goal.Root != "foo" || jf.Message != nverr.Error() {
+		t.Errorf("Unexpected JSON for failure: %s", b)
+	}
+	if len(jf.Failures) != 2 {
+		t.Fatalf("Expected two failed versions in JSON, got: %s", b)
+	}
+	jvf := jf.Failures[0]
+	if jvf.Version.Value != "2.1.3" || jvf.Failure.Kind != "version-not-allowed" {
+		t.Errorf("Unexpected JSON for failed version: %s", b)
+	}
+	if len(jvf.Failure.Dependers) != 1 || jvf.Failure.Dependers[0].Constraint.Type != "range" {
+		t.Errorf("Unexpected JSON for dependers: %s", b)
+	}
+
+	// Errors that aren't SolveFailures are kept as their message.
+	if jf := toJSONFailure(errors.New("oops")); jf.Kind != "error" || jf.Message != "oops" {
+		t.Errorf("Unexpected JSON for plain error: %+v", jf)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a ^1.0.0", "c *"),
+			mkDepspec("a 1.0.0", "b ^2.0.0"),
+			mkDepspec("a 1.1.0", "b ^2.0.0"),
+			mkDepspec("b 1.0.0"),
+			mkDepspec("b 2.0.0"),
+			mkDepspec("c 1.0.0", "b ^1.0.0"),
+		},
+	}
+	params := fix.params()
+	_, err := fixSolve(params, newdepspecSM(fix.ds, nil), t)
+	if err == nil {
+		t.Fatal("Expected solving to fail")
+	}
+
+	// The returned failure is only about a, which goes no further back than
+	// the selection of c.
+	d := Explain(err)
+	if d == nil {
+		t.Fatalf("Expected an explanation for %T: %s", err, err)
+	}
+	if d.Failure != err {
+		t.Errorf("Expected the final step to explain the returned failure")
+	}
+	if want := "no version of a can be selected together with c 1.0.0"; d.Conclusion != want {
+		t.Errorf("Unexpected conclusion:\n\t(GOT): %s\n\t(WNT): %s", d.Conclusion, want)
+	}
+
+	// The solver's explanation goes back to the root.
+	s, err := Prepare(params, newdepspecSM(fix.ds, nil))
+	if err != nil {
+		t.Fatalf("Unexpected error while preparing solver: %s", err)
+	}
+	if d := s.Explain(); d != nil {
+		t.Errorf("Expected no explanation before solving, got:\n%s", d)
+	}
+	if _, err = s.Solve(); err == nil {
+		t.Fatal("Expected solving to fail")
+	}
+	d = s.Explain()
+	if d == nil {
+		t.Fatal("Expected an explanation from the solver")
+	}
+
+	str := d.String()
+	for _, want := range []string{
+		"Because a 1.1.0 depends on b ^2.0.0 and c 1.0.0 depends on b ^1.0.0, a 1.1.0 is incompatible with c 1.0.0.",
+		"no version of a can be selected together with c 1.0.0",
+		"Because root project root depends on c * and c 1.0.0 cannot be selected, no version of c can be selected.",
+		"So, solving failed.",
+	} {
+		if !strings.Contains(str, want) {
+			t.Errorf("Expected explanation to contain %q, got:\n%s", want, str)
+		}
+	}
+
+	if d := Explain(&SolveCanceledError{Err: context.Canceled}); d != nil {
+		t.Errorf("Expected no explanation for a canceled solve, got:\n%s", d)
+	}
+}
+
+func TestSuggestFixes(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a ^1.0.0", "c *"),
+			mkDepspec("a 1.0.0", "b ^2.0.0"),
+			mkDepspec("b 1.0.0"),
+			mkDepspec("b 2.0.0"),
+			mkDepspec("c 1.0.0", "b ^1.0.0"),
+		},
+	}
+	params := fix.params()
+	params.SuggestFixes = true
+	_, err := fixSolve(params, newdepspecSM(fix.ds, nil), t)
+
+	uerr, ok := err.(*UnsolvableError)
+	if !ok {
+		t.Fatalf("Expected an *UnsolvableError, got %T: %v", err, err)
+	}
+	if _, ok := uerr.Err.(*NoVersionError); !ok {
+		t.Errorf("Expected the original failure to be kept, got %T", uerr.Err)
+	}
+	if Explain(err) == nil {
+		t.Error("Expected the failure to still be explainable")
+	}
+
+	var got []string
+	for _, f := range uerr.Fixes {
+		got = append(got, f.String())
+		if f.Solution == nil || len(f.Solution.Projects()) == 0 {
+			t.Errorf("Expected a solution for fix %q", f)
+		}
+	}
+	want := []string{
+		"ignore package a",
+		"override the constraint on b with ^2.0.0",
+		"ignore package c",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected fixes:\n\t(GOT): %q\n\t(WNT): %q", got, want)
+	}
+	if !strings.Contains(err.Error(), "\n\toverride the constraint on b with ^2.0.0") {
+		t.Errorf("Expected the error to list the fixes, got:\n%s", err)
+	}
+
+	// Without the option, the original failure is returned.
+	params.SuggestFixes = false
+	_, err = fixSolve(params, newdepspecSM(fix.ds, nil), t)
+	if _, ok := err.(*NoVersionError); !ok {
+		t.Errorf("Expected a *NoVersionError, got %T: %v", err, err)
+	}
+}
+
+func TestSolutionWhy(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a ^1.0.0", "b ^1.0.0"),
+			mkDepspec("a 1.0.0", "c ^1.0.0"),
+			mkDepspec("b 1.0.0", "a ^1.0.0", "c ^1.1.0"),
+			mkDepspec("c 1.0.0"),
+			mkDepspec("c 1.1.0"),
+		},
+	}
+	params := fix.params()
+	soln, err := fixSolve(params, newdepspecSM(fix.ds, nil), t)
+	if err != nil {
+		t.Fatalf("Unexpected error while solving: %s", err)
+	}
+
+	g := soln.Graph()
+	if want := []ProjectRoot{"root"}; !reflect.DeepEqual(g.Roots(), want) {
+		t.Errorf("Unexpected roots:\n\t(GOT): %v\n\t(WNT): %v", g.Roots(), want)
+	}
+	var got []string
+	for _, e := range g.Edges() {
+		got = append(got, e.String())
+	}
+	want := []string{
+		"a 1.0.0 -> c 1.1.0 (^1.0.0)",
+		"b 1.0.0 -> a 1.0.0 (^1.0.0)",
+		"b 1.0.0 -> c 1.1.0 (^1.1.0)",
+		"root project root -> a 1.0.0 (^1.0.0)",
+		"root project root -> b 1.0.0 (^1.0.0)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected edges:\n\t(GOT): %q\n\t(WNT): %q", got, want)
+	}
+	if deps := g.Dependers("c"); len(deps) != 2 || !reflect.DeepEqual(deps[0].Packages, []string{"c"}) {
+		t.Errorf("Expected two dependers on package c, got %v", deps)
+	}
+
+	got = nil
+	for _, p := range soln.Why("c") {
+		got = append(got, p.String())
+	}
+	want = []string{
+		"root project root -> a 1.0.0 (^1.0.0) -> c 1.1.0 (^1.0.0)",
+		"root project root -> b 1.0.0 (^1.0.0) -> c 1.1.0 (^1.1.0)",
+		"root project root -> b 1.0.0 (^1.0.0) -> a 1.0.0 (^1.0.0) -> c 1.1.0 (^1.0.0)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected paths to c:\n\t(GOT): %q\n\t(WNT): %q", got, want)
+	}
+
+	if paths := soln.Why("root"); paths != nil {
+		t.Errorf("Expected no paths to the root project, got %v", paths)
+	}
+	if paths := soln.Why("d"); paths != nil {
+		t.Errorf("Expected no paths to a project not in the solution, got %v", paths)
+	}
+
+	// Looking for a solution with less churn carries on searching after the
+	// best one has been found, so the graph has to be the best solution's.
+	soln, err = solveBasicsAndCheck(basicFixtures["minimal churn moves fewest locked projects"], t)
+	if err != nil {
+		t.Fatalf("Unexpected error while solving: %s", err)
+	}
+	got = nil
+	for _, e := range soln.Graph().Edges() {
+		got = append(got, e.String())
+	}
+	want = []string{
+		"c 1.0.0 -> a 2.0.0 (>=2.0.0)",
+		"root project root -> a 2.0.0 (*)",
+		"root project root -> b 1.0.0 (*)",
+		"root project root -> c 1.0.0 (*)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected edges in minimal churn solution:\n\t(GOT): %q\n\t(WNT): %q", got, want)
+	}
+	got = nil
+	for _, p := range soln.Why("a") {
+		got = append(got, p.String())
+	}
+	want = []string{
+		"root project root -> a 2.0.0 (*)",
+		"root project root -> c 1.0.0 (*) -> a 2.0.0 (>=2.0.0)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected paths to a in minimal churn solution:\n\t(GOT): %q\n\t(WNT): %q", got, want)
+	}
+}
+
+// The paths through a graph can't all be enumerated, so Why must stop at the
+// shortest few.
+func TestSolutionWhyLimit(t *testing.T) {
+	// Each of the 16 layers doubles the number of paths to z, and root also
+	// depends on it directly.
+	const layers = 16
+	root := []string{"l0a *", "l0b *", "z *"}
+	ds := []depspec{mkDepspec("root 0.0.0", root...)}
+	for k := 0; k < layers; k++ {
+		next := []string{"z *"}
+		if k < layers-1 {
+			next = []string{fmt.Sprintf("l%va *", k+1), fmt.Sprintf("l%vb *", k+1)}
+		}
+		ds = append(ds,
+			mkDepspec(fmt.Sprintf("l%va 1.0.0", k), next...),
+			mkDepspec(fmt.Sprintf("l%vb 1.0.0", k), next...),
+		)
+	}
+	ds = append(ds, mkDepspec("z 1.0.0"))
+	fix := basicFixture{ds: ds}
+
+	params := fix.params()
+	soln, err := fixSolve(params, newdepspecSM(fix.ds, nil), t)
+	if err != nil {
+		t.Fatalf("Unexpected error while solving: %s", err)
+	}
+
+	paths := soln.Why("z")
+	if len(paths) != maxWhyPaths {
+		t.Fatalf("Expected %v paths to z, got %v", maxWhyPaths, len(paths))
+	}
+	if got, want := paths[0].String(), "root project root -> z 1.0.0 (*)"; got != want {
+		t.Errorf("Expected the direct dependency first:\n\t(GOT): %s\n\t(WNT): %s", got, want)
+	}
+	for k, p := range paths[1:] {
+		if len(p) != layers+1 {
+			t.Errorf("Expected path %v to pass through every layer, got %s", k+1, p)
+		}
+	}
+}
+
+// recordingObserver keeps every event it is given.
+type recordingObserver struct {
+	roots   []RootEvent
+	tries   []TryEvent
+	fails   []FailEvent
+	backs   []BacktrackEvent
+	sels    []SelectEvent
+	finishs []FinishEvent
+}
+
+func (o *recordingObserver) SelectRoot(ev RootEvent)     { o.roots = append(o.roots, ev) }
+func (o *recordingObserver) Visit(ev VisitEvent)         {}
+func (o *recordingObserver) TryVersion(ev TryEvent)      { o.tries = append(o.tries, ev) }
+func (o *recordingObserver) CheckFailed(ev FailEvent)    { o.fails = append(o.fails, ev) }
+func (o *recordingObserver) Backtrack(ev BacktrackEvent) { o.backs = append(o.backs, ev) }
+func (o *recordingObserver) Select(ev SelectEvent)       { o.sels = append(o.sels, ev) }
+func (o *recordingObserver) Note(ev NoteEvent)           {}
+func (o *recordingObserver) Finish(ev FinishEvent)       { o.finishs = append(o.finishs, ev) }
+
+func TestSolveObserver(t *testing.T) {
+	fix := basicFixture{
+		ds: []depspec{
+			mkDepspec("root 0.0.0", "a *"),
+			mkDepspec("a 1.0.0", "b *"),
+			mkDepspec("b 1.0.0"),
+			mkDepspec("b 1.1.0"),
+		},
+		r: mksolution(
+			"a 1.0.0",
+			"b 1.0.0",
+		),
+	}
+	sm := newdepspecSM(fix.ds, nil)
+
+	obs := &recordingObserver{}
+	params := fix.params()
+	params.Policy = denyPolicy{
+		vers: map[string]bool{"b 1.1.0": true},
+	}
+	params.Observer = obs
+
+	// fixSolve also enables tracing, so this exercises the text tracer and
+	// the observer together.
+	soln, err := fixSolve(params, sm, t)
+	fixtureSolveSimpleChecks(fix, soln, err, t)
+
+	if len(obs.roots) != 1 || obs.roots[0].ImportRoot != "root" || obs.roots[0].Projects != 1 {
+		t.Errorf("Expected one root event for root with one dependency, got %v", obs.roots)
+	}
+
+	var tried []string
+	for _, ev := range obs.tries {
+		tried = append(tried, fmt.Sprintf("%s %s", ev.Ident.ProjectRoot, ev.Version))
+	}
+	if want := []string{"a 1.0.0", "b 1.1.0", "b 1.0.0"}; !reflect.DeepEqual(tried, want) {
+		t.Errorf("Expected versions to be tried in order %v, got %v", want, tried)
+	}
+
+	if len(obs.fails) != 1 {
+		t.Fatalf("Expected one failed check, got %v", len(obs.fails))
+	}
+	if f := obs.fails[0]; f.Ident.ProjectRoot != "b" || f.Version.String() != "1.1.0" {
+		t.Errorf("Expected the failed check to be on b 1.1.0, got %s %s", f.Ident.ProjectRoot, f.Version)
+	} else if _, ok := f.Err.(*PolicyVetoFailure); !ok || f.Summary == "" {
+		t.Errorf("Expected a PolicyVetoFailure with a summary, got %T: %q", f.Err, f.Summary)
+	}
+
+	if len(obs.backs) != 0 {
+		t.Errorf("Expected no backtracking, got %v", obs.backs)
+	}
+
+	var selected []string
+	for _, ev := range obs.sels {
+		selected = append(selected, fmt.Sprintf("%s %s", ev.Ident.ProjectRoot, ev.Version))
+	}
+	if want := []string{"a 1.0.0", "b 1.0.0"}; !reflect.DeepEqual(selected, want) {
+		t.Errorf("Expected selections %v, got %v", want, selected)
+	}
+
+	if len(obs.finishs) != 1 || obs.finishs[0].Err != nil || obs.finishs[0].Solution == nil {
+		t.Errorf("Expected a single successful finish event, got %v", obs.finishs)
+	}
+}
@@ -2,10 +2,13 @@ package gps
 
 import (
 	"container/heap"
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/armon/go-radix"
 	"github.com/sdboyer/gps/internal"
@@ -36,8 +39,10 @@ var rootRev = Revision("")
 // Only RootDir and RootPackageTree are absolutely required. A nil Manifest is
 // allowed, though it usually makes little sense.
 //
-// Of these properties, only the Manifest and RootPackageTree are (directly)
-// incorporated in memoization hashing.
+// The Manifest and RootPackageTree, and the name and version of the
+// ProjectAnalyzer, are always incorporated in memoization hashing. Whether any
+// of the other properties are is noted in their documentation; those that say
+// nothing are not.
 type SolveParameters struct {
 	// The path to the root of the project on which the solver should operate.
 	// This should point to the directory that should contain the vendor/
@@ -71,13 +76,54 @@ type SolveParameters struct {
 	// afforded only to the root project.
 	//
 	// May be nil, but for most cases, that would be unwise.
+	//
+	// If the manifest is also an ExcludingManifest, the versions it excludes
+	// are never selected. Exclusions are incorporated in memoization hashing.
 	Manifest RootManifest
 
+	// RootPackages scopes the solve to a subset of the packages in
+	// RootPackageTree: only those packages, and the packages they import, are
+	// considered, so the Solution contains only the projects they need. If
+	// empty, all packages are considered.
+	//
+	// Each entry is a pattern that must match at least one package. Patterns
+	// are import paths, or paths relative to the ImportRoot if they begin
+	// with "./"; a trailing "/..." matches the named package and all those
+	// beneath it. For example, "./cmd/agent/..." selects cmd/agent and its
+	// subpackages.
+	//
+	// The selected packages are incorporated in memoization hashing.
+	RootPackages []string
+
+	// DependencyTests lists the dependencies whose own test imports, and test
+	// constraints, should be incorporated into the solve, as they always are
+	// for the root project. This is useful where the test suites of
+	// dependencies are to be run from the vendor tree.
+	//
+	// AllDependencyTests does the same for every dependency.
+	//
+	// Both are incorporated in memoization hashing.
+	DependencyTests    []ProjectRoot
+	AllDependencyTests bool
+
+	// Workspace lists additional root projects to solve alongside the main
+	// one, producing a single Solution that satisfies all of them. Optional.
+	//
+	// The packages each root ignores are left out of that root's own imports,
+	// but within dependencies, only packages ignored by every root are
+	// ignored.
+	//
+	// Each root's imports and constraints are incorporated in memoization
+	// hashing.
+	Workspace []WorkspaceRoot
+
 	// The root lock. Optional. Generally, this lock is the output of a previous
 	// solve run.
 	//
 	// If provided, the solver will attempt to preserve the versions specified
 	// in the lock, unless ToChange or ChangeAll settings indicate otherwise.
+	// By default, this is done on a best-effort basis; use the
+	// MinimalLockChurn strategy to find the solution that preserves the most.
 	Lock Lock
 
 	// ToChange is a list of project names that should be changed - that is, any
@@ -99,8 +145,66 @@ type SolveParameters struct {
 	// Upgrading is, by far, the most typical case. The field is named
 	// 'Downgrade' so that the bool's zero value corresponds to that most
 	// typical case.
+	//
+	// Downgrade has no effect when Strategy is MinimalVersionSelection, which
+	// always prefers older versions.
 	Downgrade bool
 
+	// VersionOrders overrides Downgrade for individual projects. Projects
+	// that are absent from the map, or mapped to DefaultOrder, follow
+	// Downgrade.
+	//
+	// Like Downgrade, VersionOrders has no effect when Strategy is
+	// MinimalVersionSelection. Otherwise, it is incorporated in memoization
+	// hashing.
+	VersionOrders map[ProjectRoot]VersionOrder
+
+	// Strategy is the approach the solver takes to choosing versions. The
+	// zero value is DefaultSolveStrategy.
+	//
+	// Strategy is incorporated in memoization hashing, as solutions produced
+	// by different strategies are not interchangeable.
+	Strategy SolveStrategy
+
+	// MaxAttempts limits the number of attempts - that is, completed
+	// backtracks - the solver may make before giving up. Zero means no limit.
+	//
+	// When solving gives up because this or MaxDuration is exceeded, the
+	// returned error is a *BudgetExceededError. However, if a solution has
+	// already been found when solving with the MinimalLockChurn strategy, the
+	// search for better solutions simply ends, and the best solution found so
+	// far is returned.
+	MaxAttempts int
+
+	// MaxDuration limits the wall-clock time a solving run may take. Zero
+	// means no limit.
+	MaxDuration time.Duration
+
+	// MaxPrefetch limits the number of operations the solver may run against
+	// the SourceManager in the background, fetching information about
+	// projects it expects to need soon. Zero means a default of 8; a negative
+	// value disables prefetching entirely.
+	//
+	// Prefetching only affects how quickly a solution is found, never what the
+	// solution is.
+	MaxPrefetch int
+
+	// SelectionHeuristic decides the order in which the solver selects
+	// projects. If nil, DefaultSelectionHeuristic is used.
+	//
+	// Different heuristics may find different solutions, where more than one
+	// exists. Any solution found is valid for the other inputs, however, so
+	// the heuristic is not incorporated in memoization hashing.
+	SelectionHeuristic SelectionHeuristic
+
+	// Policy, if provided, is consulted about every dependency source and
+	// version the solver considers, and may veto any of them.
+	//
+	// A Policy can rule out solutions, but it can't be incorporated in
+	// memoization hashing. Tools should re-solve, rather than rely on the
+	// input hash, when the policy has changed.
+	Policy Policy
+
 	// Trace controls whether the solver will generate informative trace output
 	// as it moves through the solving process.
 	Trace bool
@@ -108,6 +212,34 @@ type SolveParameters struct {
 	// TraceLogger is the logger to use for generating trace output. If Trace is
 	// true but no logger is provided, solving will result in an error.
 	TraceLogger *log.Logger
+
+	// Observer, if provided, is notified of each step the solver takes. It
+	// receives the same information as trace output, in structured form, and
+	// may be used with or without Trace.
+	Observer SolveObserver
+
+	// TraceRecord, if provided, receives a machine-readable record of the
+	// solve, as JSON lines: the parameters, each response from the
+	// SourceManager that the solver consumed, and each decision the solver
+	// made. A ReplaySourceManager can later repeat the solve from the record
+	// alone.
+	//
+	// Parameters that aren't plain data, like Policy and SelectionHeuristic,
+	// are not recorded. Errors writing the record are not reported; the
+	// record simply ends.
+	TraceRecord io.Writer
+
+	// SuggestFixes, if true, makes Solve and SolveContext look for changes to
+	// the root project's inputs that would allow a solution, when there is
+	// none: new constraints and overrides for the projects involved in the
+	// failure, unlocking them, or ignoring packages the root imports from
+	// them. The returned error is then an *UnsolvableError carrying the
+	// changes found.
+	//
+	// Each change is checked by solving again with it made, against the same
+	// SourceManager and within the same budgets, so a failed solve can take
+	// many times longer.
+	SuggestFixes bool
 }
 
 // solver is a CDCL-style constraint solver with satisfiability conditions
@@ -121,6 +253,10 @@ type solver struct {
 	// Logger used exclusively for trace output, if the trace option is set.
 	tl *log.Logger
 
+	// Receives notice of the solver's progress: the text tracer, the observer
+	// from SolveParameters, or both. Nil if there's neither.
+	obs SolveObserver
+
 	// A bridge to the standard SourceManager. The adapter does some local
 	// caching of pre-sorted version lists, as well as translation between the
 	// full-on ProjectIdentifiers that the solver deals with and the simplified
@@ -158,11 +294,70 @@ type solver struct {
 	// added to an existing project.
 	vqs []*versionQueue
 
+	// The nogoods learned from failures encountered during the current solve
+	// run. Candidate atoms are checked against these before any of the
+	// (considerably more expensive) satisfiability checks.
+	ngs *nogoodStore
+
+	// The failure of the version queue most recently exhausted, and the error
+	// with which the most recent solve run ended. Together, they are used to
+	// explain a failed run.
+	exhausted error
+	runErr    error
+
+	// When searching for a solution with minimal lock churn, the number of
+	// locked projects changed by the best solution found so far. Zero if no
+	// such search is in progress.
+	maxChurn int
+
+	// Limits on the current solve run, and the progress made within them.
+	bgt budget
+
+	// Searches for fixes if solving fails, when SuggestFixes is set.
+	fix *fixer
+
+	// The maximum number of concurrent prefetch operations, from
+	// SolveParameters.
+	maxPrefetch int
+
+	// Decides the priority of items in the unselected queue.
+	heur SelectionHeuristic
+
+	// The Policy from SolveParameters, and the answers it has given about
+	// dependency sources so far.
+	pol    Policy
+	polsrc map[ProjectIdentifier]error
+
+	// The selections made in the current run before the first failure, and
+	// whether that failure has happened yet.
+	trail     []trailStep
+	trailDone bool
+
+	// The steps of a trail from a previous run that this run is known to
+	// follow, which are made before searching, and the number of them made.
+	replay   []trailStep
+	replayed int
+
+	// The atom most recently passed to getImportsAndConstraintsOf, and what
+	// came back. The atom last checked is almost always the next one
+	// selected, and selecting it must not depend on the SourceManager, which
+	// refuses calls once the run is canceled. Following a trail sets it
+	// directly, from what the trail recorded.
+	imps struct {
+		a    atomWithPackages
+		pl   []string
+		deps []completeDep
+	}
+
 	// Contains data and constraining information from the root project
 	rd rootdata
 
 	// metrics for the current solve run.
 	mtr *metrics
+
+	// The cancellation context for the current solve run. The bridge threads
+	// it into the calls it makes against the SourceManager.
+	ctx context.Context
 }
 
 func (params SolveParameters) toRootdata() (rootdata, error) {
@@ -193,9 +388,19 @@ func (params SolveParameters) toRootdata() (rootdata, error) {
 		rpt:     params.RootPackageTree.Copy(),
 		chng:    make(map[ProjectRoot]struct{}),
 		rlm:     make(map[ProjectRoot]LockedProject),
+		ord:     make(map[ProjectRoot]VersionOrder),
 		chngall: params.ChangeAll,
 		dir:     params.RootDir,
 		an:      params.ProjectAnalyzer,
+		strat:   params.Strategy,
+		down:    params.Downgrade || params.Strategy == MinimalVersionSelection,
+		tests:   make(map[ProjectRoot]bool),
+		testall: params.AllDependencyTests,
+	}
+	if !rd.testall {
+		for _, pr := range params.DependencyTests {
+			rd.tests[pr] = true
+		}
 	}
 
 	// Ensure the required, ignore and overrides maps are at least initialized
@@ -226,6 +431,21 @@ func (params SolveParameters) toRootdata() (rootdata, error) {
 		}
 	}
 
+	if len(params.RootPackages) > 0 {
+		var err error
+		rd.scope, err = scopeRootPackages(rd.rpt, params.RootPackages, rd.ig)
+		if err != nil {
+			return rootdata{}, err
+		}
+	}
+
+	if err := rd.addExclusions(params.Manifest); err != nil {
+		return rootdata{}, err
+	}
+	if err := rd.addWorkspace(params.Workspace); err != nil {
+		return rootdata{}, err
+	}
+
 	// Validate no empties in the overrides map
 	var eovr []string
 	for pr, pp := range rd.ovr {
@@ -265,6 +485,26 @@ func (params SolveParameters) toRootdata() (rootdata, error) {
 		rd.chng[p] = struct{}{}
 	}
 
+	if rd.strat == MinimalLockChurn && rd.chngall {
+		return rootdata{}, badOptsFailure("minimal lock churn was requested, but ChangeAll discards the whole lock")
+	}
+
+	if rd.strat == MinimalVersionSelection {
+		// MVS arrives at the same versions with or without a lock, so there's
+		// no point in trying to preserve what's in it.
+		rd.rlm = make(map[ProjectRoot]LockedProject)
+	} else {
+		for pr, o := range params.VersionOrders {
+			switch o {
+			case DefaultOrder:
+			case UpgradeOrder, DowngradeOrder:
+				rd.ord[pr] = o
+			default:
+				return rootdata{}, badOptsFailure(fmt.Sprintf("unknown version order %v given for %s", uint8(o), pr))
+			}
+		}
+	}
+
 	return rd, nil
 }
 
@@ -280,6 +520,9 @@ func Prepare(params SolveParameters, sm SourceManager) (Solver, error) {
 	if params.Trace && params.TraceLogger == nil {
 		return nil, badOptsFailure("trace requested, but no logger provided")
 	}
+	if params.MaxAttempts < 0 || params.MaxDuration < 0 {
+		return nil, badOptsFailure("MaxAttempts and MaxDuration may not be negative")
+	}
 
 	rd, err := params.toRootdata()
 	if err != nil {
@@ -289,12 +532,46 @@ func Prepare(params SolveParameters, sm SourceManager) (Solver, error) {
 	s := &solver{
 		tl: params.TraceLogger,
 		rd: rd,
+		bgt: budget{
+			maxAttempts: params.MaxAttempts,
+			maxDuration: params.MaxDuration,
+		},
+		maxPrefetch: params.MaxPrefetch,
+		heur:        params.SelectionHeuristic,
+		pol:         params.Policy,
+	}
+	if s.heur == nil {
+		s.heur = DefaultSelectionHeuristic{}
+	}
+
+	var obs multiObserver
+	if s.tl != nil {
+		obs = append(obs, textTracer{tl: s.tl})
+	}
+	if params.Observer != nil {
+		obs = append(obs, params.Observer)
+	}
+	if params.SuggestFixes {
+		s.fix = newFixer(params, sm)
+	}
+	if params.TraceRecord != nil {
+		rw := newRecordWriter(params.TraceRecord)
+		rw.write(recordLine{Kind: "params", Params: toJSONParams(params)})
+		obs = append(obs, rw.observer())
+		sm = recordingSM{sm: sm, rw: rw}
+	}
+	switch len(obs) {
+	case 0:
+	case 1:
+		s.obs = obs[0]
+	default:
+		s.obs = obs
 	}
 
 	// Set up the bridge and ensure the root dir is in good, working order
 	// before doing anything else. (This call is stubbed out in tests, via
 	// overriding mkBridge(), so we can run with virtual RootDir.)
-	s.b = mkBridge(s, sm, params.Downgrade)
+	s.b = mkBridge(s, sm, rd.down)
 	err = s.b.verifyRootDir(params.RootDir)
 	if err != nil {
 		return nil, err
@@ -312,6 +589,7 @@ func Prepare(params SolveParameters, sm SourceManager) (Solver, error) {
 		sl:  make([]bimodalIdentifier, 0),
 		cmp: s.unselectedComparator,
 	}
+	s.ngs = newNogoodStore()
 
 	return s, nil
 }
@@ -335,6 +613,67 @@ type Solver interface {
 	// Solve initiates a solving run. It will either complete successfully with
 	// a Solution, or fail with an informative error.
 	Solve() (Solution, error)
+
+	// SolveContext is the same as Solve, but the solving run is abandoned if
+	// the provided context is canceled or its deadline expires. In that case,
+	// the returned error is a *SolveCanceledError.
+	//
+	// Cancellation affects only this solving run; the SourceManager remains
+	// usable afterwards.
+	SolveContext(context.Context) (Solution, error)
+
+	// Solutions initiates a solving run that continues past the first
+	// solution, returning an iterator over up to n distinct solutions; if n
+	// is zero or less, all solutions are enumerated.
+	//
+	// If a SolutionScorer is provided, all the solutions are found before the
+	// first is returned, whatever n is, and they are yielded from highest to
+	// lowest score; n then limits how many of the best are yielded.
+	// Otherwise, each solution is yielded as soon as it is found, in the order
+	// the solver found them; the first is the same one Solve would return.
+	//
+	// Solving runs are not repeatable: each Solver should be used for only one
+	// call to Solve, SolveContext or Solutions.
+	Solutions(ctx context.Context, n int, score SolutionScorer) SolutionIterator
+
+	// SolveFrom is the same as SolveContext, but it warm-starts from a
+	// Solution previously returned by a Solver with similar inputs - for
+	// example, the solution from before a small change to the root manifest.
+	//
+	// The selections that the change in inputs could not have affected, up
+	// to the first one that could have been, are restored as they were,
+	// without searching for or checking any of them again, and the search
+	// resumes from there.
+	// The result is always the same as from a cold SolveContext; if prev is
+	// nil, or not from this package's Solver, that's all that happens. Nor is
+	// anything replayed if either solve has a Policy or a custom
+	// SelectionHeuristic.
+	SolveFrom(ctx context.Context, prev Solution) (Solution, error)
+
+	// Metrics reports on the work done in the most recent solving run,
+	// whether or not it found a solution. It must not be called while a
+	// solving run is in progress.
+	//
+	// This is the only way to get the metrics for a run that ended with a
+	// SolveFailure, as those don't carry them.
+	Metrics() SolveMetrics
+
+	// Explain derives an explanation for the failure of the most recent
+	// solving run. Nil is returned if the run found a solution, or was cut
+	// short before it could establish that there is none. It must not be
+	// called while a solving run is in progress.
+	//
+	// The failure returned from solving describes only the last project the
+	// solver could not select, which is often far from the root of the
+	// problem. The explanation follows the failures from the root project on.
+	Explain() *Derivation
+}
+
+func (s *solver) Metrics() SolveMetrics {
+	if s.mtr == nil {
+		return SolveMetrics{}
+	}
+	return s.mtr.export(s.attempts)
 }
 
 // Solve attempts to find a dependency solution for the given project, as
@@ -342,47 +681,111 @@ type Solver interface {
 //
 // This is the entry point to the main gps workhorse.
 func (s *solver) Solve() (Solution, error) {
-	// Set up a metrics object
-	s.mtr = newMetrics()
-	s.vUnify.mtr = s.mtr
+	return s.SolveContext(context.Background())
+}
+
+// SolveContext attempts to find a dependency solution for the given project,
+// giving up if the provided context is canceled first.
+func (s *solver) SolveContext(ctx context.Context) (Solution, error) {
+	// Set up the context, budget and metrics objects
+	s.startRun(ctx)
+	defer s.endRun()
 
 	// Prime the queues with the root project
 	err := s.selectRoot()
 	if err != nil {
-		return nil, err
+		if ierr := s.interrupted(ctx); ierr != nil {
+			err = ierr
+			s.attachMetrics(err)
+		}
+		s.runErr = err
+		return nil, s.withFixes(ctx, err)
 	}
 
-	all, err := s.solve()
+	// Pick up where a previous run left off, if SolveFrom gave one.
+	s.followTrail()
 
-	s.mtr.pop()
+	all, err := s.solve()
 	var soln solution
 	if err == nil {
-		soln = solution{
-			att: s.attempts,
+		var fc []ForcedChange
+		if s.rd.strat == MinimalLockChurn {
+			fc = s.forcedChanges()
 		}
-
-		soln.hd = s.HashInputs()
-
-		// Convert ProjectAtoms into LockedProjects
-		soln.p = make([]LockedProject, len(all))
-		k := 0
-		for pa, pl := range all {
-			soln.p[k] = pa2lp(pa, pl)
-			k++
+		soln = s.mkSolution(all, fc)
+		if s.rd.strat == MinimalLockChurn {
+			soln = s.minimizeChurn(soln)
 		}
+	} else if ierr := s.interrupted(ctx); ierr != nil {
+		// Whatever the proximate failure, if the context was canceled or the
+		// budget ran out, then that's what actually ended the run.
+		err = ierr
+	}
+
+	s.mtr.pop()
+	if err == nil {
+		soln.att = s.attempts
+		soln.mtr = s.mtr.export(s.attempts)
+	} else {
+		s.attachMetrics(err)
 	}
 
+	s.runErr = err
 	s.traceFinish(soln, err)
 	if s.tl != nil {
 		s.mtr.dump(s.tl)
 	}
-	return soln, err
+	if err != nil {
+		return soln, s.withFixes(ctx, err)
+	}
+	return soln, nil
+}
+
+// mkSolution converts the final set of selected atoms and packages into a
+// solution. It must be called while the selection that makes up the solution
+// is still in place, as the solution's dependency graph and warm start trail
+// are taken from it.
+func (s *solver) mkSolution(all map[atom]map[string]struct{}, fc []ForcedChange) solution {
+	soln := solution{
+		att: s.attempts,
+		fc:  fc,
+		ws:  s.warmStart(),
+		g:   s.mkDepGraph(),
+	}
+
+	soln.hd = s.HashInputs()
+
+	// Convert ProjectAtoms into LockedProjects
+	soln.p = make([]LockedProject, len(all))
+	k := 0
+	for pa, pl := range all {
+		soln.p[k] = pa2lp(pa, pl)
+		k++
+	}
+
+	return soln
+}
+
+// resume picks the search back up after a solution has been found, by
+// backtracking out of it. False is returned if there's nowhere left to go.
+func (s *solver) resume() bool {
+	// Marking every queue as failed makes the backtracker walk back through
+	// them in order, as there's no single culprit to jump to.
+	for _, vq := range s.vqs {
+		vq.failed = true
+	}
+	return s.backtrack()
 }
 
 // solve is the top-level loop for the solving process.
 func (s *solver) solve() (map[atom]map[string]struct{}, error) {
 	// Main solving loop
 	for {
+		if err := s.ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		s.noteSelection()
 		bmi, has := s.nextUnselected()
 
 		if !has {
@@ -451,6 +854,7 @@ func (s *solver) solve() (map[atom]map[string]struct{}, error) {
 			s.traceCheckPkgs(bmi)
 			err := s.check(nawp, true)
 			if err != nil {
+				s.learn(nawp, err)
 				// Err means a failure somewhere down the line; try backtracking.
 				s.traceStartBacktrack(bmi, err, true)
 				if s.backtrack() {
@@ -472,9 +876,9 @@ func (s *solver) solve() (map[atom]map[string]struct{}, error) {
 	// selected projects and packages.
 	projs := make(map[atom]map[string]struct{})
 
-	// Skip the first project. It's always the root, and that shouldn't be
-	// included in results.
-	for _, sel := range s.sel.projects[1:] {
+	// Skip the first projects. They're always the roots, and those shouldn't
+	// be included in results.
+	for _, sel := range s.sel.projects[s.rd.nroots():] {
 		pm, exists := projs[sel.a.a]
 		if !exists {
 			pm = make(map[string]struct{})
@@ -492,32 +896,69 @@ func (s *solver) solve() (map[atom]map[string]struct{}, error) {
 // populate the queues at the beginning of a solve run.
 func (s *solver) selectRoot() error {
 	s.mtr.push("select-root")
-	// Push the root project onto the queue.
-	awp := s.rd.rootAtom()
-	s.sel.pushSelection(awp, true)
+	// Push the root projects onto the queue. In a workspace solve, all of them
+	// go on before any of their deps, as they may import one another.
+	roots := s.rd.roots()
+	awps := make([]atomWithPackages, len(roots))
+	for k, r := range roots {
+		awps[k] = rootAtomOf(r)
+		s.sel.pushSelection(awps[k], true, nil)
+	}
+
+	for k, r := range roots {
+		// If we're looking for root's deps, get it from opts and local root
+		// analysis, rather than having the sm do it
+		deps, err := s.intersectConstraintsWithImports(s.rd.combineConstraintsOf(r), s.rd.externalImportListOf(r))
+		if err != nil {
+			if s.ctx.Err() != nil {
+				// The run was canceled while the imports were being deduced.
+				s.mtr.pop()
+				return err
+			}
+			// TODO(sdboyer) this could well happen; handle it with a more graceful error
+			panic(fmt.Sprintf("shouldn't be possible %s", err))
+		}
 
-	// If we're looking for root's deps, get it from opts and local root
-	// analysis, rather than having the sm do it
-	deps, err := s.intersectConstraintsWithImports(s.rd.combineConstraints(), s.rd.externalImportList())
-	if err != nil {
-		// TODO(sdboyer) this could well happen; handle it with a more graceful error
-		panic(fmt.Sprintf("shouldn't be possible %s", err))
-	}
+		for _, dep := range deps {
+			// Roots can't be backtracked across, so if the policy vetoes one
+			// of their deps, or two of them disagree about a dep, there's
+			// nothing to be done.
+			if err := s.checkPolicySource(awps[k].a, dep); err != nil {
+				s.mtr.pop()
+				return err
+			}
+			if k > 0 {
+				if err := s.checkIdentMatches(awps[k], dep); err != nil {
+					s.mtr.pop()
+					return err
+				}
+				if err := s.checkDepsConstraintsAllowable(awps[k], dep); err != nil {
+					s.mtr.pop()
+					return err
+				}
+			}
 
-	for _, dep := range deps {
-		// If we have no lock, or if this dep isn't in the lock, then prefetch
-		// it. See longer explanation in selectAtom() for how we benefit from
-		// parallelism here.
-		if s.rd.needVersionsFor(dep.Ident.ProjectRoot) {
-			go s.b.SyncSourceFor(dep.Ident)
+			// Prefetch the dep. See longer explanation in selectAtom() for how we
+			// benefit from parallelism here.
+			s.b.prefetch(dep.Ident, nil)
+
+			s.sel.pushDep(dependency{depender: awps[k].a, dep: dep})
+			// Add all to unselected queue, except for packages an earlier root
+			// already required.
+			rpm := s.sel.getRequiredPackagesIn(dep.Ident)
+			var newp []string
+			for _, pkg := range dep.pl {
+				if rpm[pkg] == 1 {
+					newp = append(newp, pkg)
+				}
+			}
+			if len(newp) > 0 {
+				heap.Push(s.unsel, bimodalIdentifier{id: dep.Ident, pl: newp, fromRoot: true})
+			}
 		}
 
-		s.sel.pushDep(dependency{depender: awp.a, dep: dep})
-		// Add all to unselected queue
-		heap.Push(s.unsel, bimodalIdentifier{id: dep.Ident, pl: dep.pl, fromRoot: true})
+		s.traceSelectRoot(r, deps)
 	}
-
-	s.traceSelectRoot(s.rd.rpt, deps)
 	s.mtr.pop()
 	return nil
 }
@@ -528,6 +969,9 @@ func (s *solver) getImportsAndConstraintsOf(a atomWithPackages) ([]string, []com
 	if s.rd.isRoot(a.a.id.ProjectRoot) {
 		panic("Should never need to recheck imports/constraints from root during solve")
 	}
+	if c := s.imps; c.a.a.id.eq(a.a.id) && c.a.a.v == a.a.v && samePackages(c.a.pl, a.pl) {
+		return c.pl, c.deps, nil
+	}
 
 	// Work through the source manager to get project info and static analysis
 	// information.
@@ -541,7 +985,8 @@ func (s *solver) getImportsAndConstraintsOf(a atomWithPackages) ([]string, []com
 		return nil, nil, err
 	}
 
-	rm, em := ptree.ToReachMap(true, false, true, s.rd.ig)
+	tests := s.rd.wantTests(a.a.id.ProjectRoot)
+	rm, em := ptree.ToReachMap(true, tests, true, s.rd.dig)
 	// Use maps to dedupe the unique internal and external packages.
 	exmap, inmap := make(map[string]struct{}), make(map[string]struct{})
 
@@ -569,7 +1014,7 @@ func (s *solver) getImportsAndConstraintsOf(a atomWithPackages) ([]string, []com
 	// explicitly listed in the atom
 	for _, pkg := range a.pl {
 		// Skip ignored packages
-		if s.rd.ig[pkg] {
+		if s.rd.dig[pkg] {
 			continue
 		}
 
@@ -596,9 +1041,33 @@ func (s *solver) getImportsAndConstraintsOf(a atomWithPackages) ([]string, []com
 	}
 	sort.Strings(reach)
 
-	deps := s.rd.ovr.overrideAll(m.DependencyConstraints())
+	pc := m.DependencyConstraints()
+	if tests {
+		pm := prepManifest(m)
+		pc = pm.Deps.merge(pm.TestDeps)
+	}
+	deps := s.rd.ovr.overrideAll(pc)
 	cd, err := s.intersectConstraintsWithImports(deps, reach)
-	return pl, cd, err
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.imps.a, s.imps.pl, s.imps.deps = a, pl, cd
+	return pl, cd, nil
+}
+
+// samePackages reports whether two package lists are the same, in the same
+// order.
+func samePackages(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+	return true
 }
 
 // intersectConstraintsWithImports takes a list of constraints and a list of
@@ -664,6 +1133,9 @@ func (s *solver) intersectConstraintsWithImports(deps []workingConstraint, reach
 	cdeps := make([]completeDep, len(dmap))
 	k := 0
 	for _, cdep := range dmap {
+		if s.rd.strat == MinimalVersionSelection {
+			cdep.Constraint = s.asMinimum(cdep.workingConstraint)
+		}
 		cdeps[k] = cdep
 		k++
 	}
@@ -672,12 +1144,25 @@ func (s *solver) intersectConstraintsWithImports(deps []workingConstraint, reach
 }
 
 func (s *solver) createVersionQueue(bmi bimodalIdentifier) (*versionQueue, error) {
-	id := bmi.id
 	// If on the root package, there's no queue to make
-	if s.rd.isRoot(id.ProjectRoot) {
-		return newVersionQueue(id, nil, nil, s.b)
+	if s.rd.isRoot(bmi.id.ProjectRoot) {
+		return newVersionQueue(bmi.id, nil, nil, s.b)
+	}
+
+	q, err := s.newVersionQueueFor(bmi)
+	if err != nil {
+		return nil, err
 	}
 
+	// Having assembled the queue, search it for a valid version.
+	s.traceCheckQueue(q, bmi, false, 1)
+	return q, s.findValidVersion(q, bmi.pl)
+}
+
+// newVersionQueueFor assembles the version queue for the non-root project in a
+// bmi, without searching it.
+func (s *solver) newVersionQueueFor(bmi bimodalIdentifier) (*versionQueue, error) {
+	id := bmi.id
 	exists, err := s.b.SourceExists(id)
 	if err != nil {
 		return nil, err
@@ -706,7 +1191,10 @@ func (s *solver) createVersionQueue(bmi bimodalIdentifier) (*versionQueue, error
 	}
 
 	var prefv Version
-	if bmi.fromRoot {
+	switch {
+	case s.rd.strat == MinimalVersionSelection:
+		// MVS takes no hints from dependencies' locks.
+	case bmi.fromRoot:
 		// If this bmi came from the root, then we want to search through things
 		// with a dependency on it in order to see if any have a lock that might
 		// express a prefv
@@ -746,7 +1234,7 @@ func (s *solver) createVersionQueue(bmi bimodalIdentifier) (*versionQueue, error
 		//}
 		//}
 
-	} else {
+	default:
 		// Otherwise, just use the preferred version expressed in the bmi
 		prefv = bmi.prefv
 	}
@@ -782,9 +1270,7 @@ func (s *solver) createVersionQueue(bmi bimodalIdentifier) (*versionQueue, error
 		}
 	}
 
-	// Having assembled the queue, search it for a valid version.
-	s.traceCheckQueue(q, bmi, false, 1)
-	return q, s.findValidVersion(q, bmi.pl)
+	return q, nil
 }
 
 // findValidVersion walks through a versionQueue until it finds a version that
@@ -803,20 +1289,45 @@ func (s *solver) findValidVersion(q *versionQueue, pl []string) error {
 	faillen := len(q.fails)
 
 	for {
+		if s.ctx.Err() != nil {
+			// Solving has been canceled; don't bother checking any more
+			// versions.
+			break
+		}
+
 		cur := q.current()
-		s.traceInfo("try %s@%s", q.id.errString(), cur)
-		err := s.check(atomWithPackages{
+		awp := atomWithPackages{
 			a: atom{
 				id: q.id,
 				v:  cur,
 			},
 			pl: pl,
-		}, false)
+		}
+		s.traceTry(awp.a)
+
+		// Don't bother checking an atom that the root excludes, or that would
+		// complete a known conflict.
+		err := s.checkExcluded(awp)
 		if err == nil {
-			// we have a good version, can return safely
-			return nil
+			err = s.checkNogoods(awp)
 		}
+		if err == nil {
+			err = s.checkLockChurn(awp.a)
+		}
+		if err != nil {
+			s.traceFailure(awp.a, err)
+		} else {
+			err = s.check(awp, false)
+			if err == nil {
+				// we have a good version, can return safely
+				return nil
+			}
+			s.learn(awp, err)
+		}
+		s.noteFailure(q.id, err)
+		s.endTrail()
 
+		s.mtr.advances++
 		if q.advance(err) != nil {
 			// Error on advance, have to bail out
 			break
@@ -828,13 +1339,18 @@ func (s *solver) findValidVersion(q *versionQueue, pl []string) error {
 	}
 
 	s.fail(s.sel.getDependenciesOn(q.id)[0].depender.id)
+	if q.isExhausted() && q.adverr == nil && s.ctx.Err() == nil {
+		s.backjump(q)
+	}
 
 	// Return a compound error of all the new errors encountered during this
 	// attempt to find a new, valid version
-	return &noVersionError{
+	err := &NoVersionError{
 		pn:    q.id,
 		fails: q.fails[faillen:],
 	}
+	s.exhausted = err
+	return err
 }
 
 // getLockVersionIfValid finds an atom for the given ProjectIdentifier from the
@@ -865,7 +1381,7 @@ func (s *solver) getLockVersionIfValid(id ProjectIdentifier) (Version, error) {
 		// exists only in vendor, then that guarantees we don't have enough
 		// information to complete a solution. In that case, error out.
 		if explicit {
-			return nil, &missingSourceFailure{
+			return nil, &MissingSourceFailure{
 				goal: id,
 				prob: "Cannot upgrade %s, as no source repository could be found.",
 			}
@@ -923,7 +1439,15 @@ func (s *solver) backtrack() bool {
 	}
 
 	s.mtr.push("backtrack")
+	s.endTrail()
 	for {
+		if s.ctx.Err() != nil {
+			// Solving has been canceled; there's no point in looking for
+			// another solution to try.
+			s.mtr.pop()
+			return false
+		}
+
 		for {
 			if len(s.vqs) == 0 {
 				// no more versions, nowhere further to backtrack
@@ -957,9 +1481,12 @@ func (s *solver) backtrack() bool {
 			panic("canary - version queue stack and selected project stack are misaligned")
 		}
 
-		// Advance the queue past the current version, which we know is bad
-		// TODO(sdboyer) is it feasible to make available the failure reason here?
-		if q.advance(nil) == nil && !q.isExhausted() {
+		// Advance the queue past the current version, which we know is bad. If
+		// we got here by backjumping, we know why; otherwise, we don't.
+		fail := q.backjump
+		q.backjump = nil
+		s.mtr.advances++
+		if q.advance(fail) == nil && !q.isExhausted() {
 			// Search for another acceptable version of this failed dep in its queue
 			s.traceCheckQueue(q, awp.bmi(), true, 0)
 			if s.findValidVersion(q, awp.pl) == nil {
@@ -975,6 +1502,10 @@ func (s *solver) backtrack() bool {
 
 		s.traceBacktrack(awp.bmi(), false)
 		//s.traceInfo("no more versions of %s, backtracking", q.id.errString())
+		s.exhausted = &NoVersionError{
+			pn:    q.id,
+			fails: append([]failedVersion(nil), q.fails...),
+		}
 
 		// No solution found; continue backtracking after popping the queue
 		// we just inspected off the list
@@ -987,7 +1518,7 @@ func (s *solver) backtrack() bool {
 	if len(s.vqs) == 0 {
 		return false
 	}
-	s.attempts++
+	s.countAttempt()
 	return true
 }
 
@@ -1000,73 +1531,35 @@ func (s *solver) nextUnselected() (bimodalIdentifier, bool) {
 }
 
 func (s *solver) unselectedComparator(i, j int) bool {
-	ibmi, jbmi := s.unsel.sl[i], s.unsel.sl[j]
-	iname, jname := ibmi.id, jbmi.id
-
-	// Most important thing is pushing package additions ahead of project
-	// additions. Package additions can't walk their version queue, so all they
-	// do is narrow the possibility of success; better to find out early and
-	// fast if they're going to fail than wait until after we've done real work
-	// on a project and have to backtrack across it.
-
-	// FIXME the impl here is currently O(n) in the number of selections; it
-	// absolutely cannot stay in a hot sorting path like this
-	// FIXME while other solver invariants probably protect us from it, this
-	// call-out means that it's possible for external state change to invalidate
-	// heap invariants.
-	_, isel := s.sel.selected(iname)
-	_, jsel := s.sel.selected(jname)
-
-	if isel && !jsel {
-		return true
-	}
-	if !isel && jsel {
-		return false
-	}
-
-	if iname.eq(jname) {
-		return false
-	}
+	return s.heur.Less(selectionState{s: s}, s.candidate(s.unsel.sl[i]), s.candidate(s.unsel.sl[j]))
+}
 
-	_, ilock := s.rd.rlm[iname.ProjectRoot]
-	_, jlock := s.rd.rlm[jname.ProjectRoot]
+// holdUnselected takes out of the unselected queue the items for a project
+// whose selection is about to change, so that reorderUnselected can put them
+// back where they now belong. first indicates whether the project itself is
+// being selected or unselected, rather than just some of its packages.
+//
+// Under the default heuristic, whether a project is selected is the only
+// thing selecting changes that bears on the ordering, and it bears only on
+// items for that project. Any other heuristic may look at any part of the
+// selection state, so reorderUnselected reorders the whole queue instead.
+func (s *solver) holdUnselected(id ProjectIdentifier, first bool) []bimodalIdentifier {
+	if _, ok := s.heur.(DefaultSelectionHeuristic); !ok || !first {
+		return nil
+	}
+	return s.unsel.take(id)
+}
 
-	switch {
-	case ilock && !jlock:
-		return true
-	case !ilock && jlock:
-		return false
-	case ilock && jlock:
-		return iname.less(jname)
-	}
-
-	// Now, sort by number of available versions. This will trigger network
-	// activity, but at this point we know that the project we're looking at
-	// isn't locked by the root. And, because being locked by root is the only
-	// way avoid that call when making a version queue, we know we're gonna have
-	// to pay that cost anyway.
-
-	// We can safely ignore an err from listVersions here because, if there is
-	// an actual problem, it'll be noted and handled somewhere else saner in the
-	// solving algorithm.
-	ivl, _ := s.b.listVersions(iname)
-	jvl, _ := s.b.listVersions(jname)
-	iv, jv := len(ivl), len(jvl)
-
-	// Packages with fewer versions to pick from are less likely to benefit from
-	// backtracking, so deal with them earlier in order to minimize the amount
-	// of superfluous backtracking through them we do.
-	switch {
-	case iv == 0 && jv != 0:
-		return true
-	case iv != 0 && jv == 0:
-		return false
-	case iv != jv:
-		return iv < jv
+// reorderUnselected restores the ordering of the unselected queue after the
+// selection has changed, putting back the items taken by holdUnselected.
+func (s *solver) reorderUnselected(held []bimodalIdentifier) {
+	if _, ok := s.heur.(DefaultSelectionHeuristic); !ok {
+		heap.Init(s.unsel)
+		return
+	}
+	for _, bmi := range held {
+		heap.Push(s.unsel, bmi)
 	}
-
-	// Finally, if all else fails, fall back to comparing by name
-	return iname.less(jname)
 }
 
 func (s *solver) fail(id ProjectIdentifier) {
@@ -1100,14 +1593,17 @@ func (s *solver) selectAtom(a atomWithPackages, pkgonly bool) {
 
 	pl, deps, err := s.getImportsAndConstraintsOf(a)
 	if err != nil {
-		// This shouldn't be possible; other checks should have ensured all
-		// packages and deps are present for any argument passed to this method.
+		// This shouldn't be possible; the atom has just been checked, so its
+		// imports and constraints are already on hand.
 		panic(fmt.Sprintf("canary - shouldn't be possible %s", err))
 	}
+	s.recordStep(a, pl, pkgonly, deps)
+
 	// Assign the new internal package list into the atom, then push it onto the
 	// selection stack
 	a.pl = pl
-	s.sel.pushSelection(a, pkgonly)
+	held := s.holdUnselected(a.a.id, !pkgonly)
+	s.sel.pushSelection(a, pkgonly, deps)
 
 	// If this atom has a lock, pull it out so that we can potentially inject
 	// preferred versions into any bmis we enqueue
@@ -1131,10 +1627,10 @@ func (s *solver) selectAtom(a atomWithPackages, pkgonly bool) {
 		if s.rd.isRoot(dep.Ident.ProjectRoot) {
 			continue
 		}
-		// If this is dep isn't in the lock, do some prefetching. (If it is, we
-		// might be able to get away with zero network activity for it, so don't
-		// prefetch). This provides an opportunity for some parallelism wins, on
-		// two fronts:
+		// Prefetch the dep: its version list, if we'll need one, and the
+		// manifest and packages for the version we're likely to try first -
+		// the version in the dep's lock, if it has one. This provides an
+		// opportunity for some parallelism wins, on two fronts:
 		//
 		// 1. Because this loop may have multiple deps in it, we could end up
 		// simultaneously fetching both in the background while solving proceeds
@@ -1144,9 +1640,7 @@ func (s *solver) selectAtom(a atomWithPackages, pkgonly bool) {
 		// few microseconds before blocking later. Best case, the dep doesn't
 		// come up next, but some other dep comes up that wasn't prefetched, and
 		// both fetches proceed in parallel.
-		if s.rd.needVersionsFor(dep.Ident.ProjectRoot) {
-			go s.b.SyncSourceFor(dep.Ident)
-		}
+		s.b.prefetch(dep.Ident, lmap[dep.Ident])
 
 		s.sel.pushDep(dependency{depender: a.a, dep: dep})
 		// Go through all the packages introduced on this dep, selecting only
@@ -1173,21 +1667,21 @@ func (s *solver) selectAtom(a atomWithPackages, pkgonly bool) {
 		}
 	}
 
+	s.reorderUnselected(held)
+
 	s.traceSelect(a, pkgonly)
 	s.mtr.pop()
 }
 
 func (s *solver) unselectLast() (atomWithPackages, bool) {
 	s.mtr.push("unselect")
-	awp, first := s.sel.popSelection()
-	heap.Push(s.unsel, bimodalIdentifier{id: awp.a.id, pl: awp.pl})
-
-	_, deps, err := s.getImportsAndConstraintsOf(awp)
-	if err != nil {
-		// This shouldn't be possible; other checks should have ensured all
-		// packages and deps are present for any argument passed to this method.
-		panic(fmt.Sprintf("canary - shouldn't be possible %s", err))
+	last := s.sel.projects[len(s.sel.projects)-1]
+	held := s.holdUnselected(last.a.a.id, last.first)
+	awp, deps, first := s.sel.popSelection()
+	if first {
+		s.mtr.backtracks[awp.a.id.ProjectRoot]++
 	}
+	heap.Push(s.unsel, bimodalIdentifier{id: awp.a.id, pl: awp.pl})
 
 	for _, dep := range deps {
 		// Skip popping if the dep is the root project, which can occur if
@@ -1204,6 +1698,8 @@ func (s *solver) unselectLast() (atomWithPackages, bool) {
 		}
 	}
 
+	s.reorderUnselected(held)
+
 	s.mtr.pop()
 	return awp, first
 }
@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -106,6 +107,7 @@ func (smIsReleased) Error() string {
 }
 
 var _ SourceManager = &SourceMgr{}
+var _ ctxSourceManager = &SourceMgr{}
 
 // NewSourceManager produces an instance of gps's built-in SourceManager. It
 // takes a cache directory, where local instances of upstream sources are
@@ -302,31 +304,39 @@ func (sm *SourceMgr) doRelease() {
 // manifest and lock is delegated to the provided ProjectAnalyzer's
 // DeriveManifestAndLock() method.
 func (sm *SourceMgr) GetManifestAndLock(id ProjectIdentifier, v Version, an ProjectAnalyzer) (Manifest, Lock, error) {
+	return sm.getManifestAndLock(context.TODO(), id, v, an)
+}
+
+func (sm *SourceMgr) getManifestAndLock(ctx context.Context, id ProjectIdentifier, v Version, an ProjectAnalyzer) (Manifest, Lock, error) {
 	if atomic.CompareAndSwapInt32(&sm.releasing, 1, 1) {
 		return nil, nil, smIsReleased{}
 	}
 
-	srcg, err := sm.srcCoord.getSourceGatewayFor(context.TODO(), id)
+	srcg, err := sm.srcCoord.getSourceGatewayFor(ctx, id)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return srcg.getManifestAndLock(context.TODO(), id.ProjectRoot, v, an)
+	return srcg.getManifestAndLock(ctx, id.ProjectRoot, v, an)
 }
 
 // ListPackages parses the tree of the Go packages at and below the ProjectRoot
 // of the given ProjectIdentifier, at the given version.
 func (sm *SourceMgr) ListPackages(id ProjectIdentifier, v Version) (pkgtree.PackageTree, error) {
+	return sm.listPackages(context.TODO(), id, v)
+}
+
+func (sm *SourceMgr) listPackages(ctx context.Context, id ProjectIdentifier, v Version) (pkgtree.PackageTree, error) {
 	if atomic.CompareAndSwapInt32(&sm.releasing, 1, 1) {
 		return pkgtree.PackageTree{}, smIsReleased{}
 	}
 
-	srcg, err := sm.srcCoord.getSourceGatewayFor(context.TODO(), id)
+	srcg, err := sm.srcCoord.getSourceGatewayFor(ctx, id)
 	if err != nil {
 		return pkgtree.PackageTree{}, err
 	}
 
-	return srcg.listPackages(context.TODO(), id.ProjectRoot, v)
+	return srcg.listPackages(ctx, id.ProjectRoot, v)
 }
 
 // ListVersions retrieves a list of the available versions for a given
@@ -342,49 +352,65 @@ func (sm *SourceMgr) ListPackages(id ProjectIdentifier, v Version) (pkgtree.Pack
 // is not accessible (network outage, access issues, or the resource actually
 // went away), an error will be returned.
 func (sm *SourceMgr) ListVersions(id ProjectIdentifier) ([]PairedVersion, error) {
+	return sm.listVersions(context.TODO(), id)
+}
+
+func (sm *SourceMgr) listVersions(ctx context.Context, id ProjectIdentifier) ([]PairedVersion, error) {
 	if atomic.CompareAndSwapInt32(&sm.releasing, 1, 1) {
 		return nil, smIsReleased{}
 	}
 
-	srcg, err := sm.srcCoord.getSourceGatewayFor(context.TODO(), id)
+	srcg, err := sm.srcCoord.getSourceGatewayFor(ctx, id)
 	if err != nil {
 		// TODO(sdboyer) More-er proper-er errors
 		return nil, err
 	}
 
-	return srcg.listVersions(context.TODO())
+	return srcg.listVersions(ctx)
 }
 
 // RevisionPresentIn indicates whether the provided Revision is present in the given
 // repository.
 func (sm *SourceMgr) RevisionPresentIn(id ProjectIdentifier, r Revision) (bool, error) {
+	return sm.revisionPresentIn(context.TODO(), id, r)
+}
+
+func (sm *SourceMgr) revisionPresentIn(ctx context.Context, id ProjectIdentifier, r Revision) (bool, error) {
 	if atomic.CompareAndSwapInt32(&sm.releasing, 1, 1) {
 		return false, smIsReleased{}
 	}
 
-	srcg, err := sm.srcCoord.getSourceGatewayFor(context.TODO(), id)
+	srcg, err := sm.srcCoord.getSourceGatewayFor(ctx, id)
 	if err != nil {
 		// TODO(sdboyer) More-er proper-er errors
 		return false, err
 	}
 
-	return srcg.revisionPresentIn(context.TODO(), r)
+	return srcg.revisionPresentIn(ctx, r)
 }
 
 // SourceExists checks if a repository exists, either upstream or in the cache,
 // for the provided ProjectIdentifier.
 func (sm *SourceMgr) SourceExists(id ProjectIdentifier) (bool, error) {
+	return sm.sourceExists(context.TODO(), id)
+}
+
+func (sm *SourceMgr) sourceExists(ctx context.Context, id ProjectIdentifier) (bool, error) {
 	if atomic.CompareAndSwapInt32(&sm.releasing, 1, 1) {
 		return false, smIsReleased{}
 	}
 
-	srcg, err := sm.srcCoord.getSourceGatewayFor(context.TODO(), id)
+	srcg, err := sm.srcCoord.getSourceGatewayFor(ctx, id)
 	if err != nil {
 		return false, err
 	}
 
-	ctx := context.TODO()
-	return srcg.existsInCache(ctx) || srcg.existsUpstream(ctx), nil
+	exists := srcg.existsInCache(ctx) || srcg.existsUpstream(ctx)
+	if err := ctx.Err(); err != nil {
+		// Being canceled says nothing about whether the source exists.
+		return false, err
+	}
+	return exists, nil
 }
 
 // SyncSourceFor will ensure that all local caches and information about a
@@ -392,16 +418,20 @@ func (sm *SourceMgr) SourceExists(id ProjectIdentifier) (bool, error) {
 //
 // The primary use case for this is prefetching.
 func (sm *SourceMgr) SyncSourceFor(id ProjectIdentifier) error {
+	return sm.syncSourceFor(context.TODO(), id)
+}
+
+func (sm *SourceMgr) syncSourceFor(ctx context.Context, id ProjectIdentifier) error {
 	if atomic.CompareAndSwapInt32(&sm.releasing, 1, 1) {
 		return smIsReleased{}
 	}
 
-	srcg, err := sm.srcCoord.getSourceGatewayFor(context.TODO(), id)
+	srcg, err := sm.srcCoord.getSourceGatewayFor(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	return srcg.syncLocal(context.TODO())
+	return srcg.syncLocal(ctx)
 }
 
 // ExportProject writes out the tree of the provided ProjectIdentifier's
@@ -427,14 +457,66 @@ func (sm *SourceMgr) ExportProject(id ProjectIdentifier, v Version, to string) e
 // paths. (A special exception is written for gopkg.in to minimize network
 // activity, as its behavior is well-structured)
 func (sm *SourceMgr) DeduceProjectRoot(ip string) (ProjectRoot, error) {
+	return sm.deduceProjectRoot(context.TODO(), ip)
+}
+
+func (sm *SourceMgr) deduceProjectRoot(ctx context.Context, ip string) (ProjectRoot, error) {
 	if atomic.CompareAndSwapInt32(&sm.releasing, 1, 1) {
 		return "", smIsReleased{}
 	}
 
-	pd, err := sm.deduceCoord.deduceRootPath(context.TODO(), ip)
+	pd, err := sm.deduceCoord.deduceRootPath(ctx, ip)
 	return ProjectRoot(pd.root), err
 }
 
+// Stats returns a snapshot of the calls the SourceMgr has made to sources and
+// to the network, and of those still in progress.
+func (sm *SourceMgr) Stats() SourceMgrStats {
+	return sm.suprvsr.stats()
+}
+
+// SourceMgrStats is a snapshot of the work done by a SourceMgr.
+type SourceMgrStats struct {
+	// Calls gives the number and total duration of completed calls, by call
+	// type. Identical calls that overlap in time are counted once, for the
+	// whole time that any of them was running.
+	Calls map[string]CallStats
+	// Running lists the calls in progress, sorted by their start time.
+	Running []RunningCall
+}
+
+// CallStats describes the completed calls of one type.
+type CallStats struct {
+	Count    int
+	Duration time.Duration
+}
+
+// RunningCall describes a call in progress.
+type RunningCall struct {
+	// Name identifies the call's subject, typically a source URL.
+	Name string
+	// Type is the type of call, as used in SourceMgrStats.Calls.
+	Type string
+	// Count is the number of identical calls waiting on this one.
+	Count int
+	// Started is the time at which the call began.
+	Started time.Time
+}
+
+type runningCallsByStart []RunningCall
+
+func (s runningCallsByStart) Len() int      { return len(s) }
+func (s runningCallsByStart) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s runningCallsByStart) Less(i, j int) bool {
+	if !s[i].Started.Equal(s[j].Started) {
+		return s[i].Started.Before(s[j].Started)
+	}
+	if s[i].Type != s[j].Type {
+		return s[i].Type < s[j].Type
+	}
+	return s[i].Name < s[j].Name
+}
+
 type timeCount struct {
 	count int
 	start time.Time
@@ -513,6 +595,29 @@ func (sup *supervisor) start(ci callInfo) (context.Context, error) {
 	return sup.ctx, nil
 }
 
+func (sup *supervisor) stats() SourceMgrStats {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+
+	st := SourceMgrStats{
+		Calls: make(map[string]CallStats, len(sup.ran)),
+	}
+	for typ, dc := range sup.ran {
+		st.Calls[typ.String()] = CallStats{Count: dc.count, Duration: dc.dur}
+	}
+	for ci, tc := range sup.running {
+		st.Running = append(st.Running, RunningCall{
+			Name:    ci.name,
+			Type:    ci.typ.String(),
+			Count:   tc.count,
+			Started: tc.start,
+		})
+	}
+	sort.Sort(runningCallsByStart(st.Running))
+
+	return st
+}
+
 func (sup *supervisor) count() int {
 	sup.mu.Lock()
 	defer sup.mu.Unlock()
@@ -573,6 +678,30 @@ const (
 	ctExportTree
 )
 
+func (ct callType) String() string {
+	switch ct {
+	case ctHTTPMetadata:
+		return "http-metadata"
+	case ctListVersions:
+		return "list-versions"
+	case ctGetManifestAndLock:
+		return "get-manifest-and-lock"
+	case ctListPackages:
+		return "list-packages"
+	case ctSourcePing:
+		return "source-ping"
+	case ctSourceInit:
+		return "source-init"
+	case ctSourceFetch:
+		return "source-fetch"
+	case ctCheckoutVersion:
+		return "checkout-version"
+	case ctExportTree:
+		return "export-tree"
+	}
+	return fmt.Sprintf("unknown(%d)", uint(ct))
+}
+
 // callInfo provides metadata about an ongoing call.
 type callInfo struct {
 	name string
@@ -0,0 +1,150 @@
+package gps
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/semver"
+)
+
+// A SolveStrategy determines how the solver chooses from among the versions
+// available for each project.
+type SolveStrategy uint8
+
+const (
+	// DefaultSolveStrategy prefers the newest allowable version of each
+	// project (or the oldest, if SolveParameters.Downgrade is set), while
+	// preserving the versions in the root lock, and taking hints from
+	// dependencies' locks, wherever possible.
+	DefaultSolveStrategy SolveStrategy = iota
+
+	// MinimalVersionSelection treats every semver constraint as a minimum,
+	// then selects the lowest version of each project that satisfies all the
+	// minimums placed on it. Neither the root lock nor dependencies' locks
+	// are consulted, as the result is reproducible without them.
+	//
+	// Upper bounds are dropped, except that a constraint which excludes the
+	// next major version goes on excluding it, as a new major version is
+	// assumed to break compatibility.
+	//
+	// Constraints that are not semver, such as branches and revisions, are
+	// still applied exactly, as are any overrides in the root manifest.
+	MinimalVersionSelection
+
+	// MinimalLockChurn searches for the solution that changes the fewest of
+	// the projects in the root lock, rather than settling for the first
+	// solution found. Otherwise, versions are chosen as under
+	// DefaultSolveStrategy.
+	//
+	// A locked project counts as changed if the solution drops it, or selects
+	// a different version of it. Projects named in ToChange are not counted.
+	// Each locked project that had to change is reported by the Solution's
+	// ForcedChanges method.
+	//
+	// The search may have to visit considerably more of the solution space
+	// than a normal solve, so it is a good idea to use SolveContext with a
+	// deadline; if the run is canceled after a solution was found, the best
+	// one found so far is returned.
+	MinimalLockChurn
+)
+
+func (st SolveStrategy) String() string {
+	switch st {
+	case DefaultSolveStrategy:
+		return "default"
+	case MinimalVersionSelection:
+		return "mvs"
+	case MinimalLockChurn:
+		return "minimal-churn"
+	}
+	return "unknown"
+}
+
+// asMinimum converts a constraint into the minimum-only form that Minimal
+// Version Selection works with: the lowest available version the constraint
+// allows, and everything newer within the same major version - or, before
+// 1.0.0, the same minor version. Either is assumed to break compatibility, so
+// the bound is only lifted if the constraint itself allows the next major
+// version.
+//
+// Non-semver constraints and overrides are returned unchanged, as are
+// constraints that allow none of the project's versions; the latter will fail
+// in the usual way once the solver reaches them.
+func (s *solver) asMinimum(wc workingConstraint) Constraint {
+	if wc.overrConstraint {
+		return wc.Constraint
+	}
+	switch wc.Constraint.(type) {
+	case semverConstraint, semVersion:
+	default:
+		return wc.Constraint
+	}
+
+	vl, err := s.b.listVersions(wc.Ident)
+	if err != nil {
+		// Whatever the problem is, it'll be dealt with when the solver gets
+		// around to making a version queue for the project.
+		return wc.Constraint
+	}
+
+	var min *semver.Version
+	for _, v := range vl {
+		var sv semVersion
+		switch tv := v.(type) {
+		case semVersion:
+			sv = tv
+		case versionPair:
+			var ok bool
+			if sv, ok = tv.v.(semVersion); !ok {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if wc.Constraint.Matches(v) && (min == nil || sv.sv.LessThan(min)) {
+			min = sv.sv
+		}
+	}
+
+	if min == nil {
+		return wc.Constraint
+	}
+
+	body := ">=" + min.String()
+	if next := fmt.Sprintf("%d.0.0", min.Major()+1); !wc.Constraint.Matches(NewVersion(next)) {
+		if min.Major() == 0 {
+			next = fmt.Sprintf("0.%d.0", min.Minor()+1)
+		}
+		body += ", <" + next
+	}
+	c, err := NewSemverConstraint(body)
+	if err != nil {
+		return wc.Constraint
+	}
+	return c
+}
+
+// A VersionOrder determines the order in which the solver tries the available
+// versions of a project.
+type VersionOrder uint8
+
+const (
+	// DefaultOrder defers to SolveParameters.Downgrade.
+	DefaultOrder VersionOrder = iota
+	// UpgradeOrder tries newer versions first.
+	UpgradeOrder
+	// DowngradeOrder tries older versions first.
+	DowngradeOrder
+)
+
+func (o VersionOrder) String() string {
+	switch o {
+	case DefaultOrder:
+		return "default"
+	case UpgradeOrder:
+		return "upgrade"
+	case DowngradeOrder:
+		return "downgrade"
+	}
+	return "unknown"
+}
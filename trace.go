@@ -2,10 +2,9 @@ package gps
 
 import (
 	"fmt"
+	"log"
 	"strconv"
 	"strings"
-
-	"github.com/sdboyer/gps/pkgtree"
 )
 
 const (
@@ -18,161 +17,261 @@ const (
 )
 
 func (s *solver) traceCheckPkgs(bmi bimodalIdentifier) {
-	if s.tl == nil {
+	if s.obs == nil {
 		return
 	}
 
-	prefix := getprei(len(s.vqs) + 1)
-	s.tl.Printf("%s\n", tracePrefix(fmt.Sprintf("? revisit %s to add %v pkgs", bmi.id.errString(), len(bmi.pl)), prefix, prefix))
+	s.obs.Visit(VisitEvent{
+		Depth:    len(s.vqs) + 1,
+		Ident:    bmi.id,
+		Packages: bmi.pl,
+		PkgOnly:  true,
+	})
 }
 
 func (s *solver) traceCheckQueue(q *versionQueue, bmi bimodalIdentifier, cont bool, offset int) {
-	if s.tl == nil {
+	if s.obs == nil {
 		return
 	}
 
-	prefix := getprei(len(s.vqs) + offset)
-	vlen := strconv.Itoa(len(q.pi))
-	if !q.allLoaded {
-		vlen = "at least " + vlen
-	}
-
-	// TODO(sdboyer) how...to list the packages in the limited space we have?
-	var verb string
-	indent := ""
-	if cont {
-		// Continue is an "inner" message.. indenting
-		verb = "continue"
-		vlen = vlen + " more"
-		indent = innerIndent
-	} else {
-		verb = "attempt"
-	}
-
-	s.tl.Printf("%s\n", tracePrefix(fmt.Sprintf("%s? %s %s with %v pkgs; %s versions to try", indent, verb, bmi.id.errString(), len(bmi.pl), vlen), prefix, prefix))
+	s.obs.Visit(VisitEvent{
+		Depth:     len(s.vqs) + offset,
+		Ident:     bmi.id,
+		Packages:  bmi.pl,
+		Continue:  cont,
+		Versions:  len(q.pi),
+		AllLoaded: q.allLoaded,
+	})
 }
 
 // traceStartBacktrack is called with the bmi that first failed, thus initiating
 // backtracking
 func (s *solver) traceStartBacktrack(bmi bimodalIdentifier, err error, pkgonly bool) {
-	if s.tl == nil {
+	if s.obs == nil {
 		return
 	}
 
-	var msg string
-	if pkgonly {
-		msg = fmt.Sprintf("%s%s could not add %v pkgs to %s; begin backtrack", innerIndent, backChar, len(bmi.pl), bmi.id.errString())
-	} else {
-		msg = fmt.Sprintf("%s%s no more versions of %s to try; begin backtrack", innerIndent, backChar, bmi.id.errString())
-	}
-
-	prefix := getprei(len(s.sel.projects))
-	s.tl.Printf("%s\n", tracePrefix(msg, prefix, prefix))
+	s.obs.Backtrack(BacktrackEvent{
+		Depth:    len(s.sel.projects),
+		Ident:    bmi.id,
+		Packages: bmi.pl,
+		PkgOnly:  pkgonly,
+		Start:    true,
+	})
 }
 
 // traceBacktrack is called when a package or project is poppped off during
 // backtracking
 func (s *solver) traceBacktrack(bmi bimodalIdentifier, pkgonly bool) {
-	if s.tl == nil {
+	if s.obs == nil {
 		return
 	}
 
-	var msg string
-	if pkgonly {
-		msg = fmt.Sprintf("%s backtrack: popped %v pkgs from %s", backChar, len(bmi.pl), bmi.id.errString())
-	} else {
-		msg = fmt.Sprintf("%s backtrack: no more versions of %s to try", backChar, bmi.id.errString())
-	}
-
-	prefix := getprei(len(s.sel.projects))
-	s.tl.Printf("%s\n", tracePrefix(msg, prefix, prefix))
+	s.obs.Backtrack(BacktrackEvent{
+		Depth:    len(s.sel.projects),
+		Ident:    bmi.id,
+		Packages: bmi.pl,
+		PkgOnly:  pkgonly,
+	})
 }
 
 // Called just once after solving has finished, whether success or not
 func (s *solver) traceFinish(sol solution, err error) {
-	if s.tl == nil {
+	if s.obs == nil {
 		return
 	}
 
+	ev := FinishEvent{
+		Err:      err,
+		Attempts: s.attempts,
+	}
 	if err == nil {
-		var pkgcount int
-		for _, lp := range sol.Projects() {
-			pkgcount += len(lp.pkgs)
-		}
-		s.tl.Printf("%s%s found solution with %v packages from %v projects", innerIndent, successChar, pkgcount, len(sol.Projects()))
-	} else {
-		s.tl.Printf("%s%s solving failed", innerIndent, failChar)
+		ev.Solution = sol
 	}
+	s.obs.Finish(ev)
 }
 
-// traceSelectRoot is called just once, when the root project is selected
-func (s *solver) traceSelectRoot(ptree pkgtree.PackageTree, cdeps []completeDep) {
-	if s.tl == nil {
+// traceSelectRoot is called once for each root project, as it is selected
+func (s *solver) traceSelectRoot(r wsroot, cdeps []completeDep) {
+	if s.obs == nil {
 		return
 	}
 
-	// This duplicates work a bit, but we're in trace mode and it's only once,
+	// This duplicates work a bit, but we're observing and it's only once,
 	// so who cares
-	rm, _ := ptree.ToReachMap(true, true, false, s.rd.ig)
-
-	s.tl.Printf("Root project is %q", s.rd.rpt.ImportRoot)
+	rm, _ := r.rpt.ToReachMap(true, true, false, r.ig)
 
 	var expkgs int
 	for _, cdep := range cdeps {
 		expkgs += len(cdep.pl)
 	}
 
-	// TODO(sdboyer) include info on ignored pkgs/imports, etc.
-	s.tl.Printf(" %v transitively valid internal packages", len(rm))
-	s.tl.Printf(" %v external packages imported from %v projects", expkgs, len(cdeps))
-	s.tl.Printf("(0)   " + successCharSp + "select (root)")
+	s.obs.SelectRoot(RootEvent{
+		ImportRoot:       r.rpt.ImportRoot,
+		InternalPackages: len(rm),
+		ExternalPackages: expkgs,
+		Projects:         len(cdeps),
+	})
 }
 
 // traceSelect is called when an atom is successfully selected
 func (s *solver) traceSelect(awp atomWithPackages, pkgonly bool) {
-	if s.tl == nil {
+	if s.obs == nil {
 		return
 	}
 
-	var msg string
-	if pkgonly {
-		msg = fmt.Sprintf("%s%s include %v more pkgs from %s", innerIndent, successChar, len(awp.pl), a2vs(awp.a))
+	s.obs.Select(SelectEvent{
+		Depth:    len(s.sel.projects) - 1,
+		Ident:    awp.a.id,
+		Version:  awp.a.v,
+		Packages: awp.pl,
+		PkgOnly:  pkgonly,
+	})
+}
+
+// traceTry is called as each version of a project is taken up for checking
+func (s *solver) traceTry(pa atom) {
+	if s.obs == nil {
+		return
+	}
+
+	s.obs.TryVersion(TryEvent{
+		Depth:   len(s.sel.projects),
+		Ident:   pa.id,
+		Version: pa.v,
+	})
+}
+
+// traceFailure is called when an atom fails a check
+func (s *solver) traceFailure(pa atom, err error) {
+	if s.obs == nil {
+		return
+	}
+
+	ev := FailEvent{
+		Depth:   len(s.sel.projects) + 1,
+		Ident:   pa.id,
+		Version: pa.v,
+		Err:     err,
+	}
+	if te, ok := err.(traceError); ok {
+		// We got a special traceError, use its custom method
+		ev.Summary = te.traceString()
 	} else {
-		msg = fmt.Sprintf("%s select %s w/%v pkgs", successChar, a2vs(awp.a), len(awp.pl))
+		ev.Summary = err.Error()
 	}
+	s.obs.CheckFailed(ev)
+}
 
-	prefix := getprei(len(s.sel.projects) - 1)
-	s.tl.Printf("%s\n", tracePrefix(msg, prefix, prefix))
+func (s *solver) traceInfo(format string, args ...interface{}) {
+	if s.obs == nil {
+		return
+	}
+
+	s.obs.Note(NoteEvent{
+		Depth:   len(s.sel.projects),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// textTracer is the SolveObserver that writes the solver's trace output.
+type textTracer struct {
+	tl *log.Logger
+}
+
+func (t textTracer) printf(depth int, msg string) {
+	prefix := getprei(depth)
+	t.tl.Printf("%s\n", tracePrefix(msg, prefix, prefix))
 }
 
-func (s *solver) traceInfo(args ...interface{}) {
-	if s.tl == nil {
+func (t textTracer) SelectRoot(ev RootEvent) {
+	t.tl.Printf("Root project is %q", ev.ImportRoot)
+	// TODO(sdboyer) include info on ignored pkgs/imports, etc.
+	t.tl.Printf(" %v transitively valid internal packages", ev.InternalPackages)
+	t.tl.Printf(" %v external packages imported from %v projects", ev.ExternalPackages, ev.Projects)
+	t.tl.Printf("(0)   " + successCharSp + "select (root)")
+}
+
+func (t textTracer) Visit(ev VisitEvent) {
+	if ev.PkgOnly {
+		t.printf(ev.Depth, fmt.Sprintf("? revisit %s to add %v pkgs", ev.Ident.errString(), len(ev.Packages)))
 		return
 	}
 
-	if len(args) == 0 {
-		panic("must pass at least one param to traceInfo")
+	vlen := strconv.Itoa(ev.Versions)
+	if !ev.AllLoaded {
+		vlen = "at least " + vlen
+	}
+
+	// TODO(sdboyer) how...to list the packages in the limited space we have?
+	var verb string
+	indent := ""
+	if ev.Continue {
+		// Continue is an "inner" message.. indenting
+		verb = "continue"
+		vlen = vlen + " more"
+		indent = innerIndent
+	} else {
+		verb = "attempt"
 	}
 
-	preflen := len(s.sel.projects)
+	t.printf(ev.Depth, fmt.Sprintf("%s? %s %s with %v pkgs; %s versions to try", indent, verb, ev.Ident.errString(), len(ev.Packages), vlen))
+}
+
+func (t textTracer) TryVersion(ev TryEvent) {
+	t.Note(NoteEvent{
+		Depth:   ev.Depth,
+		Message: fmt.Sprintf("try %s@%s", ev.Ident.errString(), ev.Version),
+	})
+}
+
+func (t textTracer) CheckFailed(ev FailEvent) {
+	t.printf(ev.Depth, tracePrefix(innerIndent+ev.Summary, "  ", failCharSp))
+}
+
+func (t textTracer) Backtrack(ev BacktrackEvent) {
 	var msg string
-	switch data := args[0].(type) {
-	case string:
-		msg = tracePrefix(innerIndent+fmt.Sprintf(data, args[1:]...), "  ", "  ")
-	case traceError:
-		preflen++
-		// We got a special traceError, use its custom method
-		msg = tracePrefix(innerIndent+data.traceString(), "  ", failCharSp)
-	case error:
-		// Regular error; still use the x leader but default Error() string
-		msg = tracePrefix(innerIndent+data.Error(), "  ", failCharSp)
+	switch {
+	case ev.Start && ev.PkgOnly:
+		msg = fmt.Sprintf("%s%s could not add %v pkgs to %s; begin backtrack", innerIndent, backChar, len(ev.Packages), ev.Ident.errString())
+	case ev.Start:
+		msg = fmt.Sprintf("%s%s no more versions of %s to try; begin backtrack", innerIndent, backChar, ev.Ident.errString())
+	case ev.PkgOnly:
+		msg = fmt.Sprintf("%s backtrack: popped %v pkgs from %s", backChar, len(ev.Packages), ev.Ident.errString())
 	default:
-		// panic here because this can *only* mean a stupid internal bug
-		panic(fmt.Sprintf("canary - unknown type passed as first param to traceInfo %T", data))
+		msg = fmt.Sprintf("%s backtrack: no more versions of %s to try", backChar, ev.Ident.errString())
 	}
 
-	prefix := getprei(preflen)
-	s.tl.Printf("%s\n", tracePrefix(msg, prefix, prefix))
+	t.printf(ev.Depth, msg)
+}
+
+func (t textTracer) Select(ev SelectEvent) {
+	a := atom{id: ev.Ident, v: ev.Version}
+
+	var msg string
+	if ev.PkgOnly {
+		msg = fmt.Sprintf("%s%s include %v more pkgs from %s", innerIndent, successChar, len(ev.Packages), a2vs(a))
+	} else {
+		msg = fmt.Sprintf("%s select %s w/%v pkgs", successChar, a2vs(a), len(ev.Packages))
+	}
+
+	t.printf(ev.Depth, msg)
+}
+
+func (t textTracer) Note(ev NoteEvent) {
+	t.printf(ev.Depth, tracePrefix(innerIndent+ev.Message, "  ", "  "))
+}
+
+func (t textTracer) Finish(ev FinishEvent) {
+	if ev.Err == nil {
+		var pkgcount int
+		for _, lp := range ev.Solution.Projects() {
+			pkgcount += len(lp.Packages())
+		}
+		t.tl.Printf("%s%s found solution with %v packages from %v projects", innerIndent, successChar, pkgcount, len(ev.Solution.Projects()))
+	} else {
+		t.tl.Printf("%s%s solving failed", innerIndent, failChar)
+	}
 }
 
 func getprei(i int) string {
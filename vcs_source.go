@@ -64,6 +64,11 @@ func (bs *baseVCSSource) initLocal(ctx context.Context) error {
 	err := bs.repo.get(ctx)
 
 	if err != nil {
+		if ctx.Err() != nil {
+			// The clone was cut short. Don't leave it behind to be taken for
+			// a complete one by later callers.
+			removeAll(bs.repo.LocalPath())
+		}
 		return unwrapVcsErr(err)
 	}
 	return nil
@@ -19,6 +19,9 @@ type versionQueue struct {
 	failed       bool
 	allLoaded    bool
 	adverr       error
+	// The failure to record for the current version when backtracking
+	// arrives at this queue, set when it is the target of a backjump.
+	backjump error
 }
 
 func newVersionQueue(id ProjectIdentifier, lockv, prefv Version, b sourceBridge) (*versionQueue, error) {
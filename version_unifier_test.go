@@ -136,3 +136,11 @@ func (lb lvFixBridge) vendorCodeExists(ProjectIdentifier) (bool, error) {
 func (lb lvFixBridge) breakLock() {
 	panic("not implemented")
 }
+
+func (lb lvFixBridge) prefetch(ProjectIdentifier, Version) {
+	panic("not implemented")
+}
+
+func (lb lvFixBridge) waitPrefetch() {
+	panic("not implemented")
+}
@@ -0,0 +1,255 @@
+package gps
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"hash"
+)
+
+// A trailStep records a single selection made by the solver.
+type trailStep struct {
+	a       atom
+	pl      []string
+	pkgonly bool
+	// The packages that were actually selected - pl, plus those they import
+	// from the same project - and the dependencies they introduced.
+	sel  []string
+	deps []completeDep
+}
+
+func (ts trailStep) matches(a atomWithPackages, pkgonly bool) bool {
+	if ts.pkgonly != pkgonly || !ts.a.id.eq(a.a.id) || ts.a.v.typedString() != a.a.v.typedString() {
+		return false
+	}
+	if len(ts.pl) != len(a.pl) {
+		return false
+	}
+	for k, pkg := range ts.pl {
+		if a.pl[k] != pkg {
+			return false
+		}
+	}
+	return true
+}
+
+// A warmStart is kept with each solution, recording what a later solve run
+// needs in order to warm-start from it.
+type warmStart struct {
+	// The selections made, in order, before the solver first encountered a
+	// failure of any kind. Up to that point, each selection was made on the
+	// strength of the inputs and the selections before it alone, so a later
+	// run with sufficiently similar inputs is certain to make the same ones.
+	trail []trailStep
+
+	// Digests of each section of the hashing inputs.
+	sections map[string][]byte
+
+	// The applicable root constraints, in string form, keyed by project root.
+	cons map[ProjectRoot]string
+
+	// Inputs that aren't part of hashing, but which still direct the search.
+	lock    map[ProjectRoot]string
+	chng    map[ProjectRoot]struct{}
+	chngall bool
+	down    bool
+	heur    bool
+	// Whether a Policy was in effect. A Policy's decisions are not part of
+	// the inputs, so a run with one can't be compared to any other.
+	pol bool
+}
+
+// sectionHasher splits the hashing inputs into their sections, and digests
+// each separately.
+type sectionHasher struct {
+	cur string
+	hs  map[string]hash.Hash
+}
+
+func (sh *sectionHasher) beginSection(header string) {
+	sh.cur = header
+}
+
+func (sh *sectionHasher) Write(p []byte) (int, error) {
+	h, has := sh.hs[sh.cur]
+	if !has {
+		h = sha256.New()
+		sh.hs[sh.cur] = h
+	}
+	return h.Write(p)
+}
+
+// warmStart captures the solver's inputs, and the trail of its current run.
+// It is taken as each solution is found, so that a solution always carries the
+// trail of the run that found it, however much further the search goes.
+func (s *solver) warmStart() *warmStart {
+	ws := &warmStart{
+		trail:    make([]trailStep, len(s.trail)),
+		sections: make(map[string][]byte),
+		cons:     make(map[ProjectRoot]string),
+		lock:     make(map[ProjectRoot]string),
+		chng:     s.rd.chng,
+		chngall:  s.rd.chngall,
+		down:     s.rd.down,
+	}
+	copy(ws.trail, s.trail)
+	_, ws.heur = s.heur.(DefaultSelectionHeuristic)
+	ws.pol = s.pol != nil
+
+	sh := &sectionHasher{hs: make(map[string]hash.Hash)}
+	s.writeHashingInputs(sh)
+	for sec, h := range sh.hs {
+		ws.sections[sec] = h.Sum(nil)
+	}
+
+	for _, pd := range s.rd.getApplicableConstraints() {
+		ws.cons[pd.Ident.ProjectRoot] = pd.Ident.Source + " " + pd.Constraint.typedString()
+	}
+	for pr, lp := range s.rd.rlm {
+		ws.lock[pr] = lp.pi.Source
+		if v := lp.Version(); v != nil {
+			ws.lock[pr] += " " + v.typedString()
+		}
+	}
+
+	return ws
+}
+
+// replayable determines how much of the trail recorded in a previous solve run
+// the current run can follow without checking each selection.
+//
+// Only a change in the root project's constraints can be narrowed down to
+// particular projects; for any other difference in inputs, nothing from the
+// previous run can be trusted. Nor can anything be trusted if either run used
+// a custom SelectionHeuristic or a Policy, as they may decide differently from
+// one run to the next.
+func (s *solver) replayable(prev *warmStart) []trailStep {
+	cur := s.warmStart()
+	if !prev.heur || !cur.heur || prev.pol || cur.pol || prev.chngall != cur.chngall || prev.down != cur.down ||
+		!sameStrings(prev.lock, cur.lock) || len(prev.chng) != len(cur.chng) ||
+		len(prev.sections) != len(cur.sections) {
+		return nil
+	}
+	for pr := range cur.chng {
+		if _, has := prev.chng[pr]; !has {
+			return nil
+		}
+	}
+	for sec, d := range cur.sections {
+		if sec != hhConstraints && !bytes.Equal(d, prev.sections[sec]) {
+			return nil
+		}
+	}
+
+	changed := make(map[ProjectRoot]bool)
+	for pr, c := range cur.cons {
+		if prev.cons[pr] != c {
+			changed[pr] = true
+		}
+	}
+	for pr := range prev.cons {
+		if _, has := cur.cons[pr]; !has {
+			changed[pr] = true
+		}
+	}
+
+	// A selection is affected by a root constraint if the constraint is on
+	// the selected project itself, or on one of its dependencies.
+	for k, ts := range prev.trail {
+		if changed[ts.a.id.ProjectRoot] {
+			return prev.trail[:k]
+		}
+		for _, dep := range ts.deps {
+			if changed[dep.Ident.ProjectRoot] {
+				return prev.trail[:k]
+			}
+		}
+	}
+	return prev.trail
+}
+
+func sameStrings(a, b map[ProjectRoot]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, has := b[k]; !has || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// recordStep adds a selection to the trail for the current run, as long as
+// no failures have been encountered yet.
+func (s *solver) recordStep(a atomWithPackages, pl []string, pkgonly bool, deps []completeDep) {
+	if s.trailDone {
+		return
+	}
+	s.trail = append(s.trail, trailStep{
+		a:       a.a,
+		pl:      a.pl,
+		pkgonly: pkgonly,
+		sel:     pl,
+		deps:    deps,
+	})
+}
+
+// endTrail is called when the solver encounters its first failure. The trail
+// for the current run can't be followed past that point.
+func (s *solver) endTrail() {
+	s.trailDone = true
+}
+
+// followTrail makes the selections on the trail being replayed, in order,
+// putting the solver in the state a cold run would have reached by the end of
+// them. None of them are searched for or checked, and the imports and
+// constraints recorded with each are used instead of being worked out again.
+//
+// Each is taken from the front of the unselected queue, as the search would
+// take it, and the search is left to carry on from the first one that doesn't
+// match what's there. That should be impossible, but so long as it doesn't
+// happen, the run goes exactly as a cold one would.
+func (s *solver) followTrail() {
+	for len(s.replay) > 0 && s.ctx.Err() == nil {
+		ts := s.replay[0]
+		bmi, has := s.nextUnselected()
+		if !has {
+			break
+		}
+
+		var q *versionQueue
+		awp := atomWithPackages{pl: bmi.pl}
+		if sel, is := s.sel.selected(bmi.id); is {
+			awp.a = atom{id: bmi.id, v: sel.a.v}
+		} else {
+			var err error
+			q, err = s.newVersionQueueFor(bmi)
+			if err != nil || q.current() == nil {
+				break
+			}
+			awp.a = atom{id: q.id, v: q.current()}
+		}
+		if !ts.matches(awp, q == nil) {
+			break
+		}
+
+		s.imps.a, s.imps.pl, s.imps.deps = awp, ts.sel, ts.deps
+		s.selectAtom(awp, q == nil)
+		if q != nil {
+			s.vqs = append(s.vqs, q)
+		}
+		s.replay = s.replay[1:]
+		s.replayed++
+	}
+	s.replay = nil
+}
+
+// SolveFrom attempts to find a dependency solution for the given project,
+// making use of a solution previously found for similar inputs.
+func (s *solver) SolveFrom(ctx context.Context, prev Solution) (Solution, error) {
+	if ps, ok := prev.(solution); ok && ps.ws != nil {
+		s.replay = s.replayable(ps.ws)
+	}
+	return s.SolveContext(ctx)
+}
@@ -0,0 +1,219 @@
+package gps
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sdboyer/gps/pkgtree"
+)
+
+// A WorkspaceRoot is an additional root project, solved together with the one
+// described by SolveParameters, so that all of them share a single Solution.
+//
+// Each root contributes its own imports, constraints, ignores and requires,
+// just as the main root does; the solver treats them all as root-level
+// dependers. None of the roots is ever fetched through the SourceManager, even
+// where they import one another.
+type WorkspaceRoot struct {
+	// The tree of packages that comprise the root project, as well as the
+	// import path that should identify the root of that tree.
+	//
+	// As with SolveParameters.RootPackageTree, the ImportRoot must be a
+	// non-empty string, and at least one package must be present. The
+	// ImportRoot may be neither the same as, nor nested within, that of any
+	// other root.
+	RootPackageTree pkgtree.PackageTree
+
+	// The manifest for the root project. May be nil.
+	//
+	// Overrides and exclusions declared here apply across the whole solve, as
+	// do those in the main root's manifest. Two roots may not declare
+	// different overrides for the same project.
+	Manifest RootManifest
+}
+
+// wsroot holds the static data from one of the roots of a solve.
+type wsroot struct {
+	// Map of packages to ignore within this root's own reach.
+	ig map[string]bool
+
+	// Map of packages this root requires.
+	req map[string]bool
+
+	// A defensively copied instance of the root's manifest.
+	rm SimpleManifest
+
+	// A defensively copied instance of the root's package tree.
+	rpt pkgtree.PackageTree
+
+	// The root's packages to which the solve is scoped, or nil for all.
+	scope map[string]bool
+}
+
+// main returns the main root project, as a wsroot.
+func (rd rootdata) main() wsroot {
+	return wsroot{
+		ig:    rd.ig,
+		req:   rd.req,
+		rm:    rd.rm,
+		rpt:   rd.rpt,
+		scope: rd.scope,
+	}
+}
+
+// roots returns all of the roots of the solve, starting with the main root.
+func (rd rootdata) roots() []wsroot {
+	return append([]wsroot{rd.main()}, rd.ws...)
+}
+
+// nroots returns the number of roots in the solve. The roots are always the
+// first selections on the solver's selection stack.
+func (rd rootdata) nroots() int {
+	return len(rd.ws) + 1
+}
+
+// root returns the root with the given ProjectRoot. It must only be called
+// with a ProjectRoot for which isRoot returns true.
+func (rd rootdata) root(pr ProjectRoot) wsroot {
+	for _, r := range rd.ws {
+		if pr == ProjectRoot(r.rpt.ImportRoot) {
+			return r
+		}
+	}
+	return rd.main()
+}
+
+// inWorkspace indicates whether the given import path is a package within one
+// of the additional roots of a workspace solve, or, if there are any, within
+// the main root.
+func (rd rootdata) inWorkspace(path string) bool {
+	if len(rd.ws) == 0 {
+		return false
+	}
+	for _, r := range rd.roots() {
+		if inRoot(r.rpt.ImportRoot, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// inRoot indicates whether the given import path is within the given import
+// root.
+func inRoot(root, path string) bool {
+	return strings.HasPrefix(path, root) && isPathPrefixOrEqual(root, path)
+}
+
+// addWorkspace validates the additional roots of a workspace solve and folds
+// them into the rootdata.
+func (rd *rootdata) addWorkspace(wrl []WorkspaceRoot) error {
+	// Overrides apply to the whole solve, so they're merged together. Keep
+	// track of which root each came from, for reporting conflicts.
+	ovrfrom := make(map[ProjectRoot]string, len(rd.ovr))
+	for pr := range rd.ovr {
+		ovrfrom[pr] = rd.rpt.ImportRoot
+	}
+
+	for _, wr := range wrl {
+		ir := wr.RootPackageTree.ImportRoot
+		if ir == "" {
+			return badOptsFailure("workspace roots must include a non-empty import root")
+		}
+		if len(wr.RootPackageTree.Packages) == 0 {
+			return badOptsFailure(fmt.Sprintf("at least one package must be present in the PackageTree for workspace root %s", ir))
+		}
+		for _, r := range rd.roots() {
+			if inRoot(r.rpt.ImportRoot, ir) || inRoot(ir, r.rpt.ImportRoot) {
+				return badOptsFailure(fmt.Sprintf("workspace root %s overlaps with root %s", ir, r.rpt.ImportRoot))
+			}
+		}
+
+		m := wr.Manifest
+		if m == nil {
+			m = simpleRootManifest{}
+		}
+		r := wsroot{
+			ig:  m.IgnoredPackages(),
+			req: m.RequiredPackages(),
+			rm:  prepManifest(m),
+			rpt: wr.RootPackageTree.Copy(),
+		}
+		if r.ig == nil {
+			r.ig = make(map[string]bool)
+		}
+		if r.req == nil {
+			r.req = make(map[string]bool)
+		}
+
+		var both []string
+		for pkg := range r.req {
+			if r.ig[pkg] {
+				both = append(both, pkg)
+			}
+		}
+		sort.Strings(both)
+		switch len(both) {
+		case 0:
+			break
+		case 1:
+			return badOptsFailure(fmt.Sprintf("%q was given as both a required and ignored package by workspace root %s", both[0], ir))
+		default:
+			return badOptsFailure(fmt.Sprintf("multiple packages given as both required and ignored by workspace root %s: %s", ir, strings.Join(both, ", ")))
+		}
+
+		for pr, pp := range m.Overrides() {
+			if epp, has := rd.ovr[pr]; has {
+				if !samePropsAs(epp, pp) {
+					return badOptsFailure(fmt.Sprintf("roots %s and %s declared conflicting overrides for %s", ovrfrom[pr], ir, pr))
+				}
+				continue
+			}
+			rd.ovr[pr] = pp
+			ovrfrom[pr] = ir
+		}
+
+		if err := rd.addExclusions(m); err != nil {
+			return err
+		}
+
+		rd.ws = append(rd.ws, r)
+	}
+	sort.Sort(wsrootsorter(rd.ws))
+
+	// A package in a dependency can only be ignored if every root ignores it;
+	// otherwise, a root that doesn't ignore it could be left without packages
+	// it needs.
+	rd.dig = rd.ig
+	if len(rd.ws) > 0 {
+		rd.dig = make(map[string]bool)
+		for pkg := range rd.ig {
+			all := true
+			for _, r := range rd.ws {
+				all = all && r.ig[pkg]
+			}
+			if all {
+				rd.dig[pkg] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// samePropsAs indicates whether two ProjectProperties have the same effect
+// when used as overrides.
+func samePropsAs(a, b ProjectProperties) bool {
+	if a.Source != b.Source || (a.Constraint == nil) != (b.Constraint == nil) {
+		return false
+	}
+	return a.Constraint == nil || a.Constraint.typedString() == b.Constraint.typedString()
+}
+
+type wsrootsorter []wsroot
+
+func (s wsrootsorter) Len() int      { return len(s) }
+func (s wsrootsorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s wsrootsorter) Less(i, j int) bool {
+	return s[i].rpt.ImportRoot < s[j].rpt.ImportRoot
+}